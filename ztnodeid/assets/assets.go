@@ -0,0 +1,14 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+// Package assets embeds the files published next to ztmkworld.
+package assets
+
+import _ "embed"
+
+// MkWorldConfigSchema is the JSON Schema of the ztmkworld config
+//
+//go:embed mkworld.config.schema.json
+var MkWorldConfigSchema []byte
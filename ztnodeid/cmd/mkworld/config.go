@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"ztnodeid/assets"
+	"ztnodeid/pkg/home"
+	"ztnodeid/pkg/mkworld"
+)
+
+const configUsage = "usage: config convert [-to json|yaml|toml] <in> [out] | config schema"
+
+// runConfig converts configs between formats and prints the JSON Schema
+func runConfig(args []string) error {
+	if len(args) == 0 {
+		return errors.New(configUsage)
+	}
+	switch args[0] {
+	case "convert":
+		return runConfigConvert(args[1:])
+	case "schema":
+		_, err := os.Stdout.Write(assets.MkWorldConfigSchema)
+		return err
+	default:
+		return errors.New(configUsage)
+	}
+}
+
+// runConfigConvert writes the config in the format of -to, or of the output extension. Comments
+// in YAML and TOML are not carried over.
+func runConfigConvert(args []string) error {
+	fs := flag.NewFlagSet("config convert", flag.ExitOnError)
+	to := fs.String("to", "", "output format, defaults to the extension of out, or yaml when printing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return errors.New(configUsage)
+	}
+	conf, err := mkworld.LoadConfig(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	out := fs.Arg(1)
+	format := mkworld.ConfigFormatYAML
+	switch {
+	case *to != "":
+		format, err = mkworld.ParseConfigFormat(*to)
+		if err != nil {
+			return err
+		}
+	case out != "":
+		format = mkworld.ConfigFormatFromPath(out)
+	}
+	data, err := conf.Marshal(format)
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return home.WriteFileAtomic(out, data, home.PublicFilePerm)
+}
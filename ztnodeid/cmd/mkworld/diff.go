@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"ztnodeid/pkg/mkworld"
+	"ztnodeid/pkg/node"
+)
+
+// runDiff compares a deployed world file with its replacement, it fails when clients would not
+// accept the replacement as an update
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the differences as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("usage: diff [-json] <deployed world file> <new world file>")
+	}
+	worlds := make([]*node.ZtWorld, 2)
+	for i := range worlds {
+		data, err := os.ReadFile(fs.Arg(i))
+		if err != nil {
+			return err
+		}
+		worlds[i] = &node.ZtWorld{}
+		if err := worlds[i].UnmarshalBinary(data); err != nil {
+			return err
+		}
+	}
+	d := mkworld.DiffWorlds(worlds[0], worlds[1])
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(d); err != nil {
+			return err
+		}
+	} else if err := d.WriteText(os.Stdout); err != nil {
+		return err
+	}
+	return d.Err()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"ztnodeid/pkg/mkworld"
+	"ztnodeid/pkg/node"
+)
+
+// runInspect prints a readable report of a planet or moon file
+func runInspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	keyFile := fs.String("key", "", "check the signature against this signing key file, e.g. previous.c25519")
+	prevFile := fs.String("prev", "", "check the signature against the next key of this previous world file")
+	signedBy := fs.String("signed-by", "", "check the signature against this hex public key")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: inspect [-json] [-key file | -prev file | -signed-by hex] <world file>")
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	key, err := inspectKey(*keyFile, *prevFile, *signedBy)
+	if err != nil {
+		return err
+	}
+	report, err := mkworld.Inspect(data, key)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	return report.WriteText(os.Stdout)
+}
+
+// inspectKey returns the public key given by one of the inspect flags, nil if none is set
+func inspectKey(keyFile string, prevFile string, signedBy string) (*[node.ZT_C25519_PUBLIC_KEY_LEN]byte, error) {
+	switch {
+	case keyFile != "":
+		k, err := mkworld.LoadSigningKey(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		defer k.Zero()
+		pub := k.Public
+		return &pub, nil
+	case prevFile != "":
+		data, err := os.ReadFile(prevFile)
+		if err != nil {
+			return nil, err
+		}
+		prev := &node.ZtWorld{}
+		if err := prev.UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+		return &prev.PublicKeyMustBeSignedByNextTime, nil
+	case signedBy != "":
+		b, err := hex.DecodeString(signedBy)
+		if err != nil || len(b) != node.ZT_C25519_PUBLIC_KEY_LEN {
+			return nil, node.ErrInvalidData
+		}
+		pub := [node.ZT_C25519_PUBLIC_KEY_LEN]byte(b)
+		return &pub, nil
+	}
+	return nil, nil
+}
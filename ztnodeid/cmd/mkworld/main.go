@@ -6,33 +6,57 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"math/rand"
 	"os"
+	"path/filepath"
 	"time"
-	"ztnodeid/pkg/node"
-	"ztnodeid/pkg/ztcrypto"
+	"ztnodeid/pkg/home"
+	"ztnodeid/pkg/localconf"
+	"ztnodeid/pkg/mkworld"
 )
 
 var (
-	ErrWorldSigningKeyIllegal = errors.New("world signing key current.c25519 / previous.c25519 is illegal")
-	ErrPreflightCheckFailed   = errors.New("preflight check failed, internal requirement cannot be satisfied")
-	errUseRecommendValue      = errors.New("potential risk of failed execution, use recommendation if possible")
-)
-
-var (
-	prevkp     = make([]byte, node.ZT_C25519_PUBLIC_KEY_LEN+node.ZT_C25519_PRIVATE_KEY_LEN)
-	curkp      = make([]byte, node.ZT_C25519_PUBLIC_KEY_LEN+node.ZT_C25519_PRIVATE_KEY_LEN)
-	mConf      = &MkWorldConfig{}
-	gConfFile  = flag.String("c", "mkworld.config.json", "program config")
+	prevkp *mkworld.SigningKey
+	curkp  *mkworld.SigningKey
+	mConf  = &mkworld.MkWorldConfig{}
+	// fileConf is the config as read, before path resolution and MKWORLD_* overrides
+	fileConf   = &mkworld.MkWorldConfig{}
+	gConfFile  = flag.String("c", "mkworld.config.json", "program config, .json, .yaml / .yml or .toml, - reads stdin, empty uses MKWORLD_* variables only")
+	gConfFmt   = flag.String("format", "", "config format, defaults to the extension of -c, json for stdin")
+	gLocalConf = flag.String("localconf", "", "update this zerotier-one local.conf with the listening ports of the root")
+	gLocalRoot = flag.String("root", "", "address of the root the local.conf belongs to, defaults to the first root")
+	gStateFile = flag.String("state", "", "keeps the planet ID and last timestamp so rebuilds stay acceptable to clients, defaults to "+mkworld.DefaultStateFile+" next to the config")
+	wState     = &mkworld.WorldState{}
+	// gBaseDir is the directory of the config, relative paths in it are resolved against it
+	gBaseDir   = "."
 	alreadyMod = false
 )
 
-func init() {
+// subcommands, running without one builds the world from the config given by -c
+var commands = map[string]func(args []string) error{
+	"config":    runConfig,
+	"diff":      runDiff,
+	"inspect":   runInspect,
+	"pcap":      runPcap,
+	"probe":     runProbe,
+	"reconcile": runReconcile,
+	"serve":     runServe,
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				log.Fatalln(os.Args[1], "failed:", err)
+			}
+			return
+		}
+	}
 	flag.Parse()
 	log.Println("startup flag parsed: ", flag.Parsed())
+	runBuild()
 }
 
-func main() {
+func runBuild() {
 	/**
 	// current.c25519: public key 64 bytes, private key 64 bytes
 	// signature: must be signed by previous,
@@ -40,19 +64,26 @@ func main() {
 	// if initial, previous=current
 	// elliptic curve crypt operation are copied from NaCl
 	**/
+	// make sure signing keys are wiped from memory whatever happens
+	defer func() {
+		prevkp.Zero()
+		curkp.Zero()
+	}()
 	// Now Start Preflight Check
 	// Check Config Number limit and legal or not
 	if err := Preflight(); err != nil {
-		switch err {
-		case ErrPreflightCheckFailed:
+		switch {
+		case errors.Is(err, mkworld.ErrPreflightCheckFailed):
 			panic(err)
-		case errUseRecommendValue:
+		case errors.Is(err, mkworld.ErrUseRecommendValue):
+			log.Println("!WARNING!", err)
 			log.Println("!You've been warned! WARN! WARN! WARN!")
 			if mConf.PlanetRecommend {
 				log.Println("since you've set plRecommend to true, we will automatically choose a new value.")
 				log.Println("which might be much suitable for you.")
-				mConf.PlanetID = (uint64)(rand.Uint32())
-				mConf.PlanetBirth = (uint64)(time.Now().UnixMilli())
+				if err := mConf.Recommend(); err != nil {
+					panic(err)
+				}
 				log.Printf("Generated Planet ID: %d, Birth TimeStamp: %d . \n", mConf.PlanetID, mConf.PlanetBirth)
 				alreadyMod = true
 			} else {
@@ -68,18 +99,16 @@ func main() {
 	if err := PreFlightSigningKeyCheck(); err != nil {
 		// if signing key is illegal, generate new
 		switch err {
-		case ErrWorldSigningKeyIllegal:
+		case mkworld.ErrWorldSigningKeyIllegal:
 			log.Println("preflight check error occurred, but still can proceed.")
-			pub1, priv1 := ztcrypto.GenerateDualPair()
-			copy(prevkp[:node.ZT_C25519_PUBLIC_KEY_LEN], pub1[:])
-			copy(prevkp[node.ZT_C25519_PUBLIC_KEY_LEN:], priv1[:])
-			copy(curkp, prevkp)
-			err = os.WriteFile("current.c25519", prevkp, 0640)
+			prevkp = mkworld.GenerateSigningKey()
+			curkp = prevkp.Clone()
+			err = curkp.Save(mConf.SigningKeyFiles[1])
 			if err != nil {
 				log.Println("failed to write generate c25519 key pair to disk.")
 				panic(err)
 			}
-			err = os.WriteFile("previous.c25519", curkp, 0640)
+			err = prevkp.Save(mConf.SigningKeyFiles[0])
 			if err != nil {
 				log.Println("failed to write generate c25519 key pair to disk.")
 				panic(err)
@@ -94,63 +123,54 @@ func main() {
 	log.Println("preflight check successfully complete.")
 	// Preflight check successfully completed
 	// Start to build a world
-	ztW := &node.ZtWorld{
-		Type:      node.ZT_WORLD_TYPE_PLANET,
-		ID:        mConf.PlanetID,
-		Timestamp: mConf.PlanetBirth,
-	}
-	// fill the node
-	var err2 error
-	ztW.Nodes, err2 = buildPlanetNodeFromConfig()
-	if err2 != nil {
-		panic(err2)
-	}
-
-	// prepare for signing
-	var futurePubK = [node.ZT_C25519_PUBLIC_KEY_LEN]byte{}
-	copy(futurePubK[:], curkp[:node.ZT_C25519_PUBLIC_KEY_LEN])
-	ztW.PublicKeyMustBeSignedByNextTime = futurePubK
-	log.Println("generating pre-sign message.")
-	toSignZtW, err := ztW.Serialize(true, [node.ZT_C25519_SIGNATURE_LEN]byte{})
+	ztW, err := mConf.World()
 	if err != nil {
 		panic(err)
 	}
-	log.Println("pre-sign world generated and serialized successfully.")
-
-	var sigPubK [node.ZT_C25519_PUBLIC_KEY_LEN]byte
-	var sigPrivK [node.ZT_C25519_PRIVATE_KEY_LEN]byte
-	copy(sigPubK[:], prevkp[:node.ZT_C25519_PUBLIC_KEY_LEN])
-	copy(sigPrivK[:], prevkp[node.ZT_C25519_PUBLIC_KEY_LEN:])
 
-	sig4NewWorld, err := ztcrypto.SignMessage(sigPubK, sigPrivK, toSignZtW)
+	// sign with the next key of the last recorded world, previous if none, future updates must
+	// be signed by current
+	signer, err := wState.Signer(prevkp, curkp)
 	if err != nil {
 		panic(err)
 	}
-	log.Println("world has been signed.")
-
-	// pack and serialize
-	finalWorld, err := ztW.Serialize(false, sig4NewWorld)
+	log.Println("generating pre-sign message.")
+	finalWorld, err := mkworld.SignWorld(ztW, signer, curkp)
 	if err != nil {
 		panic(err)
 	}
+	log.Println("world has been signed.")
 	log.Println("new signed world are packed.")
 	err = os.WriteFile(mConf.OutputFile, finalWorld, 0644)
 	if err != nil {
 		panic(err)
 	}
 	log.Println("packed new signed world has been written to file.")
+	wState.Record(ztW)
+	if err := wState.Save(*gStateFile); err != nil {
+		panic(err)
+	}
+	log.Println("world ID and timestamp have been recorded in the state file.")
+	if *gLocalConf != "" {
+		if err := updateLocalConf(*gLocalConf, *gLocalRoot); err != nil {
+			panic(err)
+		}
+		log.Println("local.conf has been updated with root listening ports.")
+	}
 	log.Println(" ")
 
 	// if params are recommended, save
 	if alreadyMod {
-		mDt, err := json.Marshal(mConf)
+		// only the recommended values are new, paths and environment overrides stay as written
+		newConf := fileConf.Clone()
+		newConf.PlanetID = mConf.PlanetID
+		newConf.PlanetBirth = mConf.PlanetBirth
+		mDt, err := json.Marshal(newConf)
 		if err != nil {
 			log.Println("err when trying to save modified mkworld json, err: ", err)
+		} else if err2 := os.WriteFile(filepath.Join(gBaseDir, "mkworld.new.json"), mDt, 0644); err2 != nil {
+			log.Println("write file to disk failed, err:", err2)
 		} else {
-			err2 := os.WriteFile("mkworld.new.json", mDt, 0644)
-			if err2 != nil {
-				log.Println("write file to disk failed, err:", err2)
-			}
 			log.Println("write modified json successfully.")
 		}
 	}
@@ -159,110 +179,98 @@ func main() {
 	// get c output
 	log.Println("now c language output: ")
 	fmt.Println(" ")
-	fmt.Println("#define ZT_DEFAULT_WORLD_LENGTH ", len(finalWorld))
-	fmt.Printf("static const unsigned char ZT_DEFAULT_WORLD[ZT_DEFAULT_WORLD_LENGTH] = {")
-	for i, v := range finalWorld {
-		if i > 0 {
-			fmt.Printf(",")
-		}
-		fmt.Printf("0x%02x", v)
-	}
-	fmt.Printf("};\n")
+	fmt.Print(mkworld.CArray(finalWorld))
 	fmt.Println(" ")
 }
 
-type MkWorldConfig struct {
-	SigningKeyFiles []string      `json:"signing"`
-	OutputFile      string        `json:"output"`
-	RootNodes       []MkWorldNode `json:"rootNodes"`
-	PlanetID        uint64        `json:"plID"`
-	PlanetBirth     uint64        `json:"plBirth"`
-	PlanetRecommend bool          `json:"plRecommend"`
-}
-
-type MkWorldNode struct {
-	Comments    string   `json:"comments,omitempty"`
-	IdentityStr string   `json:"identity"`
-	Endpoints   []string `json:"endpoints"`
-}
-
 func Preflight() error {
-	gcfdata, err := os.ReadFile(*gConfFile)
+	conf, err := loadConfig()
 	if err != nil {
 		return err
 	}
-	log.Println("config file read.")
-	err = json.Unmarshal(gcfdata, mConf)
-	if err != nil {
+	mConf = conf
+	fileConf = conf.Clone()
+	log.Println("config file read and unmarshalled.")
+	// paths in the config are relative to it, paths from the environment to the working directory
+	mConf.ResolvePaths(gBaseDir)
+	if err := mConf.ApplyEnv(os.LookupEnv); err != nil {
 		return err
 	}
-	log.Println("config file unmarshalled.")
-	if len(mConf.SigningKeyFiles) != 2 {
-		log.Println("signing key must have 2 files.")
-		return ErrPreflightCheckFailed
+	if *gStateFile == "" {
+		*gStateFile = filepath.Join(gBaseDir, mkworld.DefaultStateFile)
+	}
+	// a recorded world keeps its ID and only moves forward in time
+	wState, err = mkworld.LoadWorldState(*gStateFile)
+	if err != nil {
+		return err
 	}
-	if len(mConf.RootNodes) > node.ZT_WORLD_MAX_ROOTS {
-		log.Println("root nodes are too many.")
-		return ErrPreflightCheckFailed
+	if err := wState.Apply(mConf, time.Now()); err != nil {
+		return fmt.Errorf("%w: %w", mkworld.ErrPreflightCheckFailed, err)
 	}
-	for _, v := range mConf.RootNodes {
-		if len(v.Endpoints) > node.ZT_WORLD_MAX_STABLE_ENDPOINTS_PER_ROOT {
-			log.Println("stable endpoints for current root node are too many.")
-			return ErrPreflightCheckFailed
+	return mConf.Check()
+}
+
+// loadConfig reads the config named by -c in the format of -format or its extension, and sets
+// gBaseDir
+func loadConfig() (*mkworld.MkWorldConfig, error) {
+	format := mkworld.ConfigFormatFromPath(*gConfFile)
+	if *gConfFmt != "" {
+		f, err := mkworld.ParseConfigFormat(*gConfFmt)
+		if err != nil {
+			return nil, err
 		}
+		format = f
 	}
-	if mConf.PlanetID == node.ZT_WORLD_ID_EARTH || mConf.PlanetID == node.ZT_WORLD_ID_MARS || mConf.PlanetBirth == 1567191349589 {
-		log.Println("!WARNING! You've specified a Planet ID / Birth that is currently in use.")
-		return errUseRecommendValue
+	switch *gConfFile {
+	case "":
+		return &mkworld.MkWorldConfig{}, nil
+	case "-":
+		return mkworld.ReadConfig(os.Stdin, format)
 	}
-	if mConf.PlanetBirth <= 1567191349589 {
-		log.Println("!WARNING! You've been created a world older than official, timestamp should be larger than 1567191349589.")
-		return errUseRecommendValue
+	gBaseDir = filepath.Dir(*gConfFile)
+	data, err := os.ReadFile(*gConfFile)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return mkworld.ParseConfig(data, format)
 }
 
 func PreFlightSigningKeyCheck() error {
 	var err1, err2 error
 	// "signing": ["previous.c25519", "current.c25519"]
-	tPrevkp, err1 := os.ReadFile(mConf.SigningKeyFiles[0])
-	tCurkp, err2 := os.ReadFile(mConf.SigningKeyFiles[1])
+	tPrevkp, err1 := mkworld.LoadSigningKey(mConf.SigningKeyFiles[0])
+	tCurkp, err2 := mkworld.LoadSigningKey(mConf.SigningKeyFiles[1])
 	if err1 != nil || err2 != nil {
 		log.Println("read world signing key failed: ", err1, " , ", err2)
-		return ErrWorldSigningKeyIllegal
-	}
-	preqLen := node.ZT_C25519_PRIVATE_KEY_LEN + node.ZT_C25519_PUBLIC_KEY_LEN
-	if len(prevkp) != preqLen || len(curkp) != preqLen {
-		log.Println("existing world signing key does not satisfy required length.")
-		return ErrWorldSigningKeyIllegal
-	} else {
-		curkp = tCurkp
-		prevkp = tPrevkp
+		tPrevkp.Zero()
+		tCurkp.Zero()
+		return mkworld.ErrWorldSigningKeyIllegal
 	}
+	prevkp = tPrevkp
+	curkp = tCurkp
 	return nil
 }
 
-func buildPlanetNodeFromConfig() ([]*node.ZtWorldPlanetNode, error) {
-	res := []*node.ZtWorldPlanetNode{}
-	for _, v := range mConf.RootNodes {
-		n1 := &node.ZtWorldPlanetNode{}
-		n1ep := make([]*node.ZtNodeInetAddr, 0)
-		n1id := &node.ZtWorldPlanetNodeIdentity{}
-		err := n1id.FromString(v.IdentityStr, false)
+// updateLocalConf sets the listening ports of the root in an existing local.conf, or creates one
+func updateLocalConf(path string, rootAddress string) error {
+	var base *localconf.LocalConf
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		base, err = localconf.Parse(data)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		for _, v2 := range v.Endpoints {
-			n1addr := &node.ZtNodeInetAddr{}
-			err := n1addr.FromString(v2)
-			if err != nil {
-				return nil, err
-			}
-			n1ep = append(n1ep, n1addr)
-		}
-		n1.Identity = n1id
-		n1.Endpoints = n1ep
-		res = append(res, n1)
+	case !os.IsNotExist(err):
+		return err
+	}
+	lc, err := mConf.LocalConf(rootAddress, base)
+	if err != nil {
+		return err
+	}
+	out, err := lc.Marshal()
+	if err != nil {
+		return err
 	}
-	return res, nil
+	return home.WriteFileAtomic(path, out, home.PublicFilePerm)
 }
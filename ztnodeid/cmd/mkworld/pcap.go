@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"ztnodeid/pkg/node"
+	"ztnodeid/pkg/pcap"
+	"ztnodeid/pkg/registry"
+	"ztnodeid/pkg/ztcrypto"
+	"ztnodeid/pkg/ztdump"
+)
+
+// runPcap prints a timeline of the ZeroTier packets in a pcap or pcapng capture, decrypting those
+// of the identities given with their private key
+func runPcap(args []string) error {
+	fs := flag.NewFlagSet("pcap", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print one JSON event per line")
+	port := fs.Uint("port", 0, "only decode datagrams from or to this UDP port, e.g. 9993")
+	var idFiles, worldFiles []string
+	fs.Func("identity", "identity.secret or identity.public file, may be repeated", func(v string) error {
+		idFiles = append(idFiles, v)
+		return nil
+	})
+	fs.Func("world", "planet or moon file whose roots are known, may be repeated", func(v string) error {
+		worldFiles = append(worldFiles, v)
+		return nil
+	})
+	regFile := fs.String("registry", "", "identity registry file with known public identities")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *port > 0xffff {
+		return errors.New("usage: pcap [-json] [-port 9993] [-identity file]... [-world file]... [-registry file] <capture>")
+	}
+	dec := ztdump.NewDecoder()
+	dec.Port = uint16(*port)
+	for _, path := range idFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		id, err := node.ParseZtIdentity(string(data))
+		ztcrypto.Wipe(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		defer id.DestroyPrivateKey()
+		dec.AddIdentity(id)
+	}
+	for _, path := range worldFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		w := &node.ZtWorld{}
+		if err := w.UnmarshalBinary(data); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for _, n := range w.Nodes {
+			dec.AddIdentity(n.Identity.ToIdentity())
+		}
+	}
+	if *regFile != "" {
+		reg, err := registry.OpenFile(*regFile)
+		if err != nil {
+			return err
+		}
+		ids, err := reg.List()
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			dec.AddIdentity(id)
+		}
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := pcap.NewReader(f)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for {
+		frame, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		u, ok := pcap.DecodeUDP(frame)
+		if !ok {
+			continue
+		}
+		for _, e := range dec.Decode(frame.Timestamp, u) {
+			if *asJSON {
+				if err := enc.Encode(e); err != nil {
+					return err
+				}
+			} else {
+				fmt.Println(e.String())
+			}
+		}
+	}
+}
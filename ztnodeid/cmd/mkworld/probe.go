@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"os/signal"
+	"ztnodeid/pkg/node"
+	"ztnodeid/pkg/probe"
+	"ztnodeid/pkg/ztcrypto"
+)
+
+// runProbe says HELLO to every endpoint of a world and fails unless all of them answer as the root
+// and serve that world
+func runProbe(args []string) error {
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the results as JSON")
+	idFile := fs.String("identity", "", "identity.secret to say HELLO with, a new identity is generated if empty")
+	timeout := fs.Duration("timeout", probe.DefaultTimeout, "how long each endpoint has to answer")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: probe [-json] [-identity identity.secret] [-timeout 3s] <world file>")
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	w := &node.ZtWorld{}
+	if err := w.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	opts := probe.Options{Timeout: *timeout}
+	if *idFile != "" {
+		idData, err := os.ReadFile(*idFile)
+		if err != nil {
+			return err
+		}
+		opts.Identity, err = node.ParseZtIdentity(string(idData))
+		ztcrypto.Wipe(idData)
+		if err != nil {
+			return err
+		}
+		defer opts.Identity.DestroyPrivateKey()
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	results, err := probe.World(ctx, w, opts)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	} else if err := probe.WriteText(os.Stdout, results); err != nil {
+		return err
+	}
+	return probe.Err(results)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+	"ztnodeid/pkg/home"
+	"ztnodeid/pkg/mkworld"
+	"ztnodeid/pkg/node"
+)
+
+// runReconcile re-signs the deployed world only when its roots differ from the desired state. The
+// deployed world is signed with whichever of -prev and -cur it names as its next key.
+func runReconcile(args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	deployedFile := fs.String("deployed", "planet.custom", "world file currently deployed")
+	desiredFile := fs.String("desired", "", "desired roots, YAML (.yaml / .yml) or JSON, a mkworld config works")
+	prevFile := fs.String("prev", "previous.c25519", "previous signing key, used when the deployed world still names it")
+	curFile := fs.String("cur", "current.c25519", "current signing key, the following update must be signed by it")
+	output := fs.String("o", "", "write the reconciled world here instead of replacing the deployed file")
+	stateFile := fs.String("state", mkworld.DefaultStateFile, "state file recording the world ID and last timestamp")
+	dryRun := fs.Bool("n", false, "only print what would change, no key is loaded")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *desiredFile == "" {
+		return errors.New("-desired is required")
+	}
+	data, err := os.ReadFile(*deployedFile)
+	if err != nil {
+		return err
+	}
+	deployed := &node.ZtWorld{}
+	if err := deployed.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	desired, err := mkworld.LoadDesiredState(*desiredFile)
+	if err != nil {
+		return err
+	}
+	state, err := mkworld.LoadWorldState(*stateFile)
+	if err != nil {
+		return err
+	}
+	if state.ID != 0 && state.ID != deployed.ID {
+		return fmt.Errorf("%w: state file has %d, deployed world has %d", mkworld.ErrWorldIDChanged, state.ID, deployed.ID)
+	}
+	w, err := mkworld.Reconcile(deployed, desired, time.Now())
+	if err != nil {
+		return err
+	}
+	if w == nil {
+		log.Println("deployed world already matches the desired state, nothing to do.")
+		return nil
+	}
+	w.Timestamp = state.NextTimestamp(w.Timestamp)
+	if *dryRun {
+		// nothing is signed yet, the signer is picked to match the deployed next key below
+		d := mkworld.DiffWorlds(deployed, w)
+		d.SignedByOld = true
+		if err := d.Err(); err != nil {
+			return err
+		}
+		return d.WriteText(os.Stdout)
+	}
+	prev, err := loadOptionalSigningKey(*prevFile)
+	if err != nil {
+		return err
+	}
+	defer prev.Zero()
+	cur, err := loadOptionalSigningKey(*curFile)
+	if err != nil {
+		return err
+	}
+	defer cur.Zero()
+	if cur == nil {
+		return fmt.Errorf("%s: %w", *curFile, os.ErrNotExist)
+	}
+	if err := mkworld.VerifyDeployed(deployed, prev, cur); err != nil {
+		return err
+	}
+	signer, err := mkworld.SelectSigner(deployed.PublicKeyMustBeSignedByNextTime, prev, cur)
+	if err != nil {
+		return err
+	}
+	out, err := mkworld.SignReconciled(deployed, w, signer, cur)
+	if err != nil {
+		return err
+	}
+	d := mkworld.DiffWorlds(deployed, w)
+	if err := d.Err(); err != nil {
+		return err
+	}
+	if err := d.WriteText(os.Stdout); err != nil {
+		return err
+	}
+	if *output == "" {
+		*output = *deployedFile
+	}
+	if err := home.WriteFileAtomic(*output, out, home.PublicFilePerm); err != nil {
+		return err
+	}
+	log.Println("reconciled world has been written to", *output)
+	state.Record(w)
+	return state.Save(*stateFile)
+}
+
+// loadOptionalSigningKey returns nil for a missing key file, previous.c25519 only exists after a
+// rotation
+func loadOptionalSigningKey(path string) (*mkworld.SigningKey, error) {
+	k, err := mkworld.LoadSigningKey(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return k, err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"ztnodeid/pkg/apiserver"
+)
+
+// runServe exposes world building over HTTP, see pkg/apiserver
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", "unix:/run/ztmkworld.sock", "unix:/path/to.sock or TCP host:port")
+	keyDir := fs.String("keys", ".", "directory holding previous.c25519 and current.c25519")
+	worldDir := fs.String("worlds", "", "distribute the planet and moons.d of this directory without authentication")
+	tlsCert := fs.String("tls-cert", "", "serve HTTPS on TCP with this certificate")
+	tlsKey := fs.String("tls-key", "", "private key of -tls-cert")
+	tokenFile := fs.String("token-file", "", "file holding the bearer token for TCP clients, overrides MKWORLD_API_TOKEN")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	token := os.Getenv("MKWORLD_API_TOKEN")
+	if *tokenFile != "" {
+		data, err := os.ReadFile(*tokenFile)
+		if err != nil {
+			return err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv := apiserver.New(apiserver.Options{
+		KeyDir:      *keyDir,
+		Token:       token,
+		WorldDir:    *worldDir,
+		TLSCertFile: *tlsCert,
+		TLSKeyFile:  *tlsKey,
+	})
+	return srv.ListenAndServe(ctx, *listen)
+}
@@ -0,0 +1,248 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package apiserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"ztnodeid/pkg/home"
+	"ztnodeid/pkg/mkworld"
+	"ztnodeid/pkg/node"
+	"ztnodeid/pkg/ztcrypto"
+)
+
+// Manifest lists the worlds served from Options.WorldDir
+type Manifest struct {
+	Worlds []ManifestWorld `json:"worlds"`
+}
+
+type ManifestWorld struct {
+	// Type is "planet" or "moon"
+	Type      string         `json:"type"`
+	ID        node.ZtWorldID `json:"id"`
+	Timestamp uint64         `json:"timestamp"`
+	// SHA256 is the hex hash of the world file, also used as its ETag
+	SHA256 string         `json:"sha256"`
+	Size   int            `json:"size"`
+	Path   string         `json:"path"`
+	Roots  []ManifestRoot `json:"roots"`
+}
+
+type ManifestRoot struct {
+	Address   string   `json:"address"`
+	Endpoints []string `json:"endpoints"`
+}
+
+// SignedManifest carries the manifest as the exact bytes that were signed
+type SignedManifest struct {
+	Manifest json.RawMessage `json:"manifest"`
+	// SignedBy is the hex public key of the signer, the next key of the last world built by the server
+	SignedBy  string `json:"signedBy"`
+	Signature string `json:"signature"`
+}
+
+// Verify checks the manifest was signed by key, e.g. the next key of a planet the client trusts,
+// and returns the decoded manifest
+func (m *SignedManifest) Verify(key [node.ZT_C25519_PUBLIC_KEY_LEN]byte) (*Manifest, error) {
+	sig, err := hex.DecodeString(m.Signature)
+	if err != nil || len(sig) != node.ZT_C25519_SIGNATURE_LEN {
+		return nil, node.ErrInvalidSignature
+	}
+	if !ztcrypto.VerifySignature(key, m.Manifest, [node.ZT_C25519_SIGNATURE_LEN]byte(sig)) {
+		return nil, node.ErrInvalidSignature
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(m.Manifest, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+type distWorld struct {
+	world *node.ZtWorld
+	data  []byte
+	sum   string
+}
+
+// distributionRoutes are public, planets and moons are handed to every node that joins anyway
+func (s *Server) distributionRoutes() {
+	s.mux.HandleFunc("GET /v1/dist/planet", s.handlePlanet)
+	s.mux.HandleFunc("GET /v1/dist/moons/{id}", s.handleMoon)
+	s.mux.HandleFunc("GET /v1/dist/manifest", s.handleManifest)
+}
+
+func (s *Server) handlePlanet(w http.ResponseWriter, r *http.Request) {
+	h := &home.Home{Dir: s.opts.WorldDir}
+	dw, err := loadDistWorld(h.Path(home.PlanetFile), node.ZT_WORLD_TYPE_PLANET)
+	if err != nil {
+		writeDistError(w, err)
+		return
+	}
+	serveWorld(w, r, dw)
+}
+
+func (s *Server) handleMoon(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(strings.TrimSuffix(r.PathValue("id"), ".moon"), 16, 64)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	h := &home.Home{Dir: s.opts.WorldDir}
+	dw, err := loadDistWorld(h.MoonPath(id), node.ZT_WORLD_TYPE_MOON)
+	if err != nil {
+		writeDistError(w, err)
+		return
+	}
+	serveWorld(w, r, dw)
+}
+
+func (s *Server) handleManifest(w http.ResponseWriter, r *http.Request) {
+	worlds, err := s.loadDistWorlds()
+	if err != nil {
+		writeDistError(w, err)
+		return
+	}
+	manifest := &Manifest{Worlds: make([]ManifestWorld, 0, len(worlds))}
+	for _, dw := range worlds {
+		manifest.Worlds = append(manifest.Worlds, manifestWorld(dw))
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.mu.Lock()
+	prev, cur, err := s.readKeys()
+	if err != nil {
+		s.mu.Unlock()
+		writeKeysError(w, err)
+		return
+	}
+	defer prev.Zero()
+	defer cur.Zero()
+	state, err := mkworld.LoadWorldState(filepath.Join(s.opts.KeyDir, mkworld.DefaultStateFile))
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	// the deployed planet names the signer as its next key, also right after a rotation
+	signer, err := state.Signer(prev, cur)
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	sig, err := signer.Sign(data)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, &SignedManifest{
+		Manifest:  data,
+		SignedBy:  hex.EncodeToString(signer.Public[:]),
+		Signature: hex.EncodeToString(sig[:]),
+	})
+}
+
+// loadDistWorlds reads the planet, if any, followed by the moons sorted by ID
+func (s *Server) loadDistWorlds() ([]*distWorld, error) {
+	h := &home.Home{Dir: s.opts.WorldDir}
+	worlds := make([]*distWorld, 0)
+	planet, err := loadDistWorld(h.Path(home.PlanetFile), node.ZT_WORLD_TYPE_PLANET)
+	switch {
+	case err == nil:
+		worlds = append(worlds, planet)
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(h.Dir, home.MoonsDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	moons := make([]*distWorld, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".moon") {
+			continue
+		}
+		dw, err := loadDistWorld(filepath.Join(h.Dir, home.MoonsDir, e.Name()), node.ZT_WORLD_TYPE_MOON)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		moons = append(moons, dw)
+	}
+	sort.Slice(moons, func(i, j int) bool { return moons[i].world.ID < moons[j].world.ID })
+	return append(worlds, moons...), nil
+}
+
+func loadDistWorld(path string, wantType node.ZtWorldType) (*distWorld, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &node.ZtWorld{}
+	if err := w.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	if w.Type != wantType {
+		return nil, home.ErrWrongWorldType
+	}
+	sum := sha256.Sum256(data)
+	return &distWorld{world: w, data: data, sum: hex.EncodeToString(sum[:])}, nil
+}
+
+func manifestWorld(dw *distWorld) ManifestWorld {
+	mw := ManifestWorld{
+		Type:      "planet",
+		ID:        dw.world.ID,
+		Timestamp: dw.world.Timestamp,
+		SHA256:    dw.sum,
+		Size:      len(dw.data),
+		Path:      "planet",
+		Roots:     make([]ManifestRoot, 0, len(dw.world.Nodes)),
+	}
+	if dw.world.Type == node.ZT_WORLD_TYPE_MOON {
+		mw.Type = "moon"
+		mw.Path = fmt.Sprintf("moons/%.16x.moon", dw.world.ID)
+	}
+	for _, n := range dw.world.Nodes {
+		root := ManifestRoot{
+			Address:   hex.EncodeToString(n.Identity.ZtNodeAddress[:]),
+			Endpoints: make([]string, 0, len(n.Endpoints)),
+		}
+		for _, ep := range n.Endpoints {
+			root.Endpoints = append(root.Endpoints, ep.String())
+		}
+		mw.Roots = append(mw.Roots, root)
+	}
+	return mw
+}
+
+// serveWorld lets http.ServeContent answer conditional and range requests, the world timestamp
+// is in milliseconds
+func serveWorld(w http.ResponseWriter, r *http.Request, dw *distWorld) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", `"`+dw.sum+`"`)
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", time.UnixMilli(int64(dw.world.Timestamp)), bytes.NewReader(dw.data))
+}
+
+func writeDistError(w http.ResponseWriter, err error) {
+	if os.IsNotExist(err) {
+		writeError(w, http.StatusNotFound, os.ErrNotExist)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
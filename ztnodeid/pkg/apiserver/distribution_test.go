@@ -0,0 +1,118 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package apiserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"ztnodeid/pkg/home"
+	"ztnodeid/pkg/mkworld"
+	"ztnodeid/pkg/node"
+)
+
+const testToken = "test-token"
+
+func testRequest(t *testing.T, srv *httptest.Server, method string, path string, body any) *http.Response {
+	t.Helper()
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rd = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, srv.URL+path, rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// deployBuild builds a planet through the API and deploys it to worldDir
+func deployBuild(t *testing.T, srv *httptest.Server, conf *mkworld.MkWorldConfig, worldDir string) *node.ZtWorld {
+	t.Helper()
+	resp := testRequest(t, srv, http.MethodPost, "/v1/world/build", conf)
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		t.Fatalf("build: %s %s", resp.Status, b)
+	}
+	built := &buildResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(built); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(worldDir, home.PlanetFile), built.World, 0644); err != nil {
+		t.Fatal(err)
+	}
+	w := &node.ZtWorld{}
+	if err := w.UnmarshalBinary(built.World); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// verifyManifest fetches the manifest and checks it against the next key of the deployed planet,
+// the key a bootstrap script pins
+func verifyManifest(t *testing.T, srv *httptest.Server, deployed *node.ZtWorld) {
+	t.Helper()
+	resp := testRequest(t, srv, http.MethodGet, "/v1/dist/manifest", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("manifest: %s", resp.Status)
+	}
+	signed := &SignedManifest{}
+	if err := json.NewDecoder(resp.Body).Decode(signed); err != nil {
+		t.Fatal(err)
+	}
+	m, err := signed.Verify(deployed.PublicKeyMustBeSignedByNextTime)
+	if err != nil {
+		t.Fatalf("manifest does not verify against the next key of the deployed planet: %v", err)
+	}
+	if len(m.Worlds) != 1 || m.Worlds[0].Timestamp != deployed.Timestamp {
+		t.Errorf("manifest lists %+v, want the deployed planet", m.Worlds)
+	}
+}
+
+func TestManifestFollowsKeyRotation(t *testing.T) {
+	worldDir := t.TempDir()
+	s := New(Options{KeyDir: t.TempDir(), Token: testToken, WorldDir: worldDir, Logger: log.New(io.Discard, "", 0)})
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	id, err := mkworld.RandomWorldID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := node.GenerateZtIdentity()
+	conf := &mkworld.MkWorldConfig{
+		RootNodes:   []mkworld.MkWorldNode{{IdentityStr: root.PublicKeyString(), Endpoints: []string{"127.0.0.1/9993"}}},
+		PlanetID:    id,
+		PlanetBirth: 1700000000000,
+	}
+	deployed := deployBuild(t, srv, conf, worldDir)
+	verifyManifest(t, srv, deployed)
+
+	if resp := testRequest(t, srv, http.MethodPost, "/v1/keys/rotate", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("rotate: %s", resp.Status)
+	}
+	// the deployed planet still names the key that is now previous.c25519
+	verifyManifest(t, srv, deployed)
+
+	deployed = deployBuild(t, srv, conf, worldDir)
+	verifyManifest(t, srv, deployed)
+}
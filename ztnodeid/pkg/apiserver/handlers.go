@@ -0,0 +1,352 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package apiserver
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"time"
+	"ztnodeid/pkg/mkworld"
+	"ztnodeid/pkg/node"
+)
+
+func (s *Server) routes() {
+	s.Handle("POST /v1/world/build", http.HandlerFunc(s.handleBuild))
+	s.Handle("POST /v1/world/verify", http.HandlerFunc(s.handleVerify))
+	s.Handle("POST /v1/world/inspect", http.HandlerFunc(s.handleInspect))
+	s.Handle("POST /v1/world/diff", http.HandlerFunc(s.handleDiff))
+	s.Handle("POST /v1/identity/generate", http.HandlerFunc(s.handleGenerateIdentity))
+	s.Handle("GET /v1/keys", http.HandlerFunc(s.handleKeys))
+	s.Handle("POST /v1/keys/rotate", http.HandlerFunc(s.handleRotateKeys))
+}
+
+type buildResponse struct {
+	// World is the signed world, base64 encoded
+	World []byte `json:"world"`
+	// Config is the request config with recommended planet ID / birth applied
+	Config    *mkworld.MkWorldConfig `json:"config"`
+	SignedBy  string                 `json:"signedBy"`
+	NextKey   string                 `json:"nextKey"`
+	CArray    string                 `json:"cArray"`
+	Warning   string                 `json:"warning,omitempty"`
+	ID        node.ZtWorldID         `json:"id"`
+	Timestamp uint64                 `json:"timestamp"`
+}
+
+// handleBuild signs the world described by a mkworld config with the server key the last built
+// world named as its successor's signer, or previous.c25519 for the first world. The next key is
+// always current.c25519. Signing key paths and output file of the config are ignored.
+func (s *Server) handleBuild(w http.ResponseWriter, r *http.Request) {
+	conf := &mkworld.MkWorldConfig{}
+	if !readJSON(w, r, conf) {
+		return
+	}
+	// the server keys are used, only their names are echoed in the response config
+	conf.SigningKeyFiles = []string{PreviousKeyFile, CurrentKeyFile}
+	conf.OutputFile = ""
+	resp := &buildResponse{Config: conf}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statePath := filepath.Join(s.opts.KeyDir, mkworld.DefaultStateFile)
+	state, err := mkworld.LoadWorldState(statePath)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := state.Apply(conf, time.Now()); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err := conf.Check(); err != nil {
+		switch {
+		case errors.Is(err, mkworld.ErrUseRecommendValue) && conf.PlanetRecommend:
+			if err := conf.Recommend(); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			resp.Warning = err.Error()
+		case errors.Is(err, mkworld.ErrUseRecommendValue):
+			resp.Warning = err.Error()
+		default:
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	world, err := conf.World()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	prev, cur, err := s.loadKeys()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer prev.Zero()
+	defer cur.Zero()
+	signer, err := state.Signer(prev, cur)
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	data, err := mkworld.SignWorld(world, signer, cur)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	state.Record(world)
+	if err := state.Save(statePath); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	resp.World = data
+	resp.SignedBy = hex.EncodeToString(signer.Public[:])
+	resp.NextKey = hex.EncodeToString(cur.Public[:])
+	resp.CArray = mkworld.CArray(data)
+	resp.ID = world.ID
+	resp.Timestamp = world.Timestamp
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type verifyRequest struct {
+	World []byte `json:"world"`
+	// PreviousWorld is the world clients currently hold, its next key must have signed World
+	PreviousWorld []byte `json:"previousWorld,omitempty"`
+	// SignedBy is a hex public key to verify against instead of PreviousWorld
+	SignedBy string `json:"signedBy,omitempty"`
+}
+
+type verifyResponse struct {
+	Valid    bool   `json:"valid"`
+	SignedBy string `json:"signedBy"`
+	Error    string `json:"error,omitempty"`
+}
+
+// handleVerify checks a world signature against the previous world, an explicit key, or either
+// server key, in that order of preference.
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	req := &verifyRequest{}
+	if !readJSON(w, r, req) {
+		return
+	}
+	world := &node.ZtWorld{}
+	if err := world.UnmarshalBinary(req.World); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var signedBy [node.ZT_C25519_PUBLIC_KEY_LEN]byte
+	switch {
+	case len(req.PreviousWorld) > 0:
+		prevWorld := &node.ZtWorld{}
+		if err := prevWorld.UnmarshalBinary(req.PreviousWorld); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		signedBy = prevWorld.PublicKeyMustBeSignedByNextTime
+	case req.SignedBy != "":
+		b, err := hex.DecodeString(req.SignedBy)
+		if err != nil || len(b) != len(signedBy) {
+			writeError(w, http.StatusBadRequest, node.ErrInvalidData)
+			return
+		}
+		copy(signedBy[:], b)
+	default:
+		s.mu.Lock()
+		prev, cur, err := s.readKeys()
+		s.mu.Unlock()
+		if err != nil {
+			writeKeysError(w, err)
+			return
+		}
+		signedBy = prev.Public
+		if world.Verify(prev.Public) != nil && world.Verify(cur.Public) == nil {
+			signedBy = cur.Public
+		}
+		prev.Zero()
+		cur.Zero()
+	}
+	resp := &verifyResponse{Valid: true, SignedBy: hex.EncodeToString(signedBy[:])}
+	if err := world.Verify(signedBy); err != nil {
+		resp.Valid = false
+		resp.Error = err.Error()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type worldRequest struct {
+	World []byte `json:"world"`
+}
+
+// handleInspect decodes a world, its signature is checked against its own next key
+func (s *Server) handleInspect(w http.ResponseWriter, r *http.Request) {
+	req := &worldRequest{}
+	if !readJSON(w, r, req) {
+		return
+	}
+	report, err := mkworld.Inspect(req.World, nil)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+type diffRequest struct {
+	// PreviousWorld is the deployed world, World its replacement
+	PreviousWorld []byte `json:"previousWorld"`
+	World         []byte `json:"world"`
+}
+
+type diffResponse struct {
+	*mkworld.WorldDiff
+	// Error is set when clients would not accept World as an update of PreviousWorld
+	Error string `json:"error,omitempty"`
+}
+
+// handleDiff reports the semantic differences between two worlds
+func (s *Server) handleDiff(w http.ResponseWriter, r *http.Request) {
+	req := &diffRequest{}
+	if !readJSON(w, r, req) {
+		return
+	}
+	prevWorld := &node.ZtWorld{}
+	if err := prevWorld.UnmarshalBinary(req.PreviousWorld); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	world := &node.ZtWorld{}
+	if err := world.UnmarshalBinary(req.World); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	resp := &diffResponse{WorldDiff: mkworld.DiffWorlds(prevWorld, world)}
+	if err := resp.Err(); err != nil {
+		resp.Error = err.Error()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type generateIdentityRequest struct {
+	Type node.ZtIdentityType `json:"type"`
+}
+
+type generateIdentityResponse struct {
+	Address string `json:"address"`
+	Public  string `json:"public"`
+	Secret  string `json:"secret"`
+}
+
+// handleGenerateIdentity creates a node identity, e.g. for a new root
+func (s *Server) handleGenerateIdentity(w http.ResponseWriter, r *http.Request) {
+	req := &generateIdentityRequest{}
+	if r.ContentLength != 0 && !readJSON(w, r, req) {
+		return
+	}
+	id, err := node.GenerateZtIdentityOfType(req.Type)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer id.DestroyPrivateKey()
+	writeJSON(w, http.StatusOK, &generateIdentityResponse{
+		Address: id.Address.String(),
+		Public:  id.PublicKeyString(),
+		Secret:  id.PrivateKeyString(),
+	})
+}
+
+type keysResponse struct {
+	// Previous signs the first world built by the server and the first one after a rotation
+	Previous string `json:"previous"`
+	// Current is named by built worlds as the key the next world must be signed by
+	Current string `json:"current"`
+}
+
+func (s *Server) handleKeys(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, cur, err := s.readKeys()
+	if err != nil {
+		writeKeysError(w, err)
+		return
+	}
+	defer prev.Zero()
+	defer cur.Zero()
+	writeJSON(w, http.StatusOK, &keysResponse{
+		Previous: hex.EncodeToString(prev.Public[:]),
+		Current:  hex.EncodeToString(cur.Public[:]),
+	})
+}
+
+// handleRotateKeys makes the current key the previous key and generates a new current key. The
+// next world built is signed by the old current key, which clients holding the last built world
+// expect, and names the new key; later worlds are signed by the new key. A second rotation before
+// a world was built would drop the key clients expect and is refused.
+func (s *Server) handleRotateKeys(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, cur, err := s.loadKeys()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	prev.Zero()
+	defer cur.Zero()
+	state, err := mkworld.LoadWorldState(filepath.Join(s.opts.KeyDir, mkworld.DefaultStateFile))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if signer, err := state.Signer(cur, nil); err != nil || signer != cur {
+		writeError(w, http.StatusConflict, ErrRotationPending)
+		return
+	}
+	next := mkworld.GenerateSigningKey()
+	defer next.Zero()
+	// write the new current key last, a failure in between leaves previous == current which
+	// still signs valid updates
+	if err := cur.Save(filepath.Join(s.opts.KeyDir, PreviousKeyFile)); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := next.Save(filepath.Join(s.opts.KeyDir, CurrentKeyFile)); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.opts.Logger.Println("world signing key rotated.")
+	writeJSON(w, http.StatusOK, &keysResponse{
+		Previous: hex.EncodeToString(cur.Public[:]),
+		Current:  hex.EncodeToString(next.Public[:]),
+	})
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, &errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,216 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+// Package apiserver exposes world building, verification, inspection, identity generation and
+// signing key rotation as a JSON HTTP API, so callers need neither a shell nor the signing keys.
+//
+// Requests over TCP must carry "Authorization: Bearer <token>". Requests over a Unix socket are
+// trusted, access is controlled by the socket file permission.
+//
+// When a world directory is configured, its planet and moons are also served to anyone under
+// /v1/dist/, with a manifest signed by the server's current key.
+package apiserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"ztnodeid/pkg/mkworld"
+)
+
+var (
+	ErrNoToken         = errors.New("a bearer token is required when listening on TCP without a world directory")
+	ErrNoSigningKeys   = errors.New("no world signing keys yet, build a world first")
+	ErrRotationPending = errors.New("the last built world does not name the current key, build a world before rotating again")
+)
+
+const (
+	// PreviousKeyFile signs the first world built by the server and the first one after a rotation
+	PreviousKeyFile = "previous.c25519"
+	// CurrentKeyFile is embedded in built worlds as the key the next world must be signed by, it
+	// signs the worlds built after them
+	CurrentKeyFile = "current.c25519"
+	// SocketFilePerm lets the owner and group of the server use the Unix socket
+	SocketFilePerm os.FileMode = 0660
+	// maxRequestBody is well above ZT_WORLD_MAX_SERIALIZED_LENGTH after base64 encoding
+	maxRequestBody = 1 << 20
+)
+
+type Options struct {
+	// KeyDir holds previous.c25519 and current.c25519, created on first use
+	KeyDir string
+	// Token authenticates TCP clients, required unless only Unix sockets are used
+	Token string
+	// WorldDir is laid out like a ZeroTier home directory, its planet and moons.d are distributed
+	WorldDir string
+	// TLSCertFile and TLSKeyFile enable HTTPS on TCP listeners
+	TLSCertFile string
+	TLSKeyFile  string
+	// Logger defaults to the standard logger
+	Logger *log.Logger
+}
+
+type Server struct {
+	opts Options
+	// mu serializes access to the signing key files
+	mu  sync.Mutex
+	mux *http.ServeMux
+}
+
+type ctxKey int
+
+const ctxKeyUnixSocket ctxKey = iota
+
+// New returns a server, routes are registered on Handler
+func New(opts Options) *Server {
+	if opts.Logger == nil {
+		opts.Logger = log.Default()
+	}
+	s := &Server{opts: opts, mux: http.NewServeMux()}
+	s.routes()
+	if opts.WorldDir != "" {
+		s.distributionRoutes()
+	}
+	return s
+}
+
+// Handle registers an additional authenticated route
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, s.authenticate(h))
+}
+
+// Handler returns the API handler
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// ListenAndServe serves on addr until ctx is cancelled. addr is either "unix:/path/to.sock" or a
+// TCP "host:port".
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	var ln net.Listener
+	var err error
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// remove a stale socket left by a previous run
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		ln, err = net.Listen("unix", path)
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(path, SocketFilePerm); err != nil {
+			_ = ln.Close()
+			return err
+		}
+	} else {
+		// without a token only the public distribution routes are usable
+		if s.opts.Token == "" && s.opts.WorldDir == "" {
+			return ErrNoToken
+		}
+		ln, err = net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+	}
+	srv := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			if _, ok := c.(*net.UnixConn); ok {
+				return context.WithValue(ctx, ctxKeyUnixSocket, true)
+			}
+			return ctx
+		},
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	s.opts.Logger.Println("api server listening on", addr)
+	if s.opts.TLSCertFile != "" && ln.Addr().Network() == "tcp" {
+		err = srv.ServeTLS(ln, s.opts.TLSCertFile, s.opts.TLSKeyFile)
+	} else {
+		err = srv.Serve(ln)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// authenticate lets Unix socket clients through and checks the bearer token of everyone else
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unix, _ := r.Context().Value(ctxKeyUnixSocket).(bool); unix {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || s.opts.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readKeys reads the signing keys, ErrNoSigningKeys means neither file exists yet. The caller
+// must hold s.mu and zero the keys.
+func (s *Server) readKeys() (prev *mkworld.SigningKey, cur *mkworld.SigningKey, err error) {
+	prev, err1 := mkworld.LoadSigningKey(filepath.Join(s.opts.KeyDir, PreviousKeyFile))
+	cur, err2 := mkworld.LoadSigningKey(filepath.Join(s.opts.KeyDir, CurrentKeyFile))
+	if err1 == nil && err2 == nil {
+		return prev, cur, nil
+	}
+	prev.Zero()
+	cur.Zero()
+	if os.IsNotExist(err1) && os.IsNotExist(err2) {
+		return nil, nil, ErrNoSigningKeys
+	}
+	return nil, nil, mkworld.ErrWorldSigningKeyIllegal
+}
+
+// loadKeys reads the signing keys, generating them like ztmkworld does when they are missing. Only
+// the authenticated build and rotate routes may call it. The caller must hold s.mu and zero the
+// keys.
+func (s *Server) loadKeys() (prev *mkworld.SigningKey, cur *mkworld.SigningKey, err error) {
+	prev, cur, err = s.readKeys()
+	if !errors.Is(err, ErrNoSigningKeys) {
+		return prev, cur, err
+	}
+	s.opts.Logger.Println("no world signing key found, generating a new one.")
+	prev = mkworld.GenerateSigningKey()
+	cur = prev.Clone()
+	if err := os.MkdirAll(s.opts.KeyDir, 0750); err != nil {
+		return nil, nil, err
+	}
+	if err := cur.Save(filepath.Join(s.opts.KeyDir, CurrentKeyFile)); err != nil {
+		return nil, nil, err
+	}
+	if err := prev.Save(filepath.Join(s.opts.KeyDir, PreviousKeyFile)); err != nil {
+		return nil, nil, err
+	}
+	return prev, cur, nil
+}
+
+// writeKeysError answers 503 while no world was built yet, 500 for unreadable keys
+func writeKeysError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNoSigningKeys) {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
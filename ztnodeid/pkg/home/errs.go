@@ -0,0 +1,14 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package home
+
+import "errors"
+
+var (
+	ErrIdentityMismatch = errors.New("identity.public does not match identity.secret")
+	ErrWrongWorldType   = errors.New("world has unexpected type")
+	ErrInvalidAuthToken = errors.New("auth token is empty or malformed")
+)
@@ -0,0 +1,257 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+// Package home reads and writes the files of a ZeroTier home directory, e.g. /var/lib/zerotier-one.
+package home
+
+import (
+	secrand "crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"ztnodeid/pkg/localconf"
+	"ztnodeid/pkg/node"
+	"ztnodeid/pkg/ztcrypto"
+)
+
+const (
+	IdentitySecretFile = "identity.secret"
+	IdentityPublicFile = "identity.public"
+	PlanetFile         = "planet"
+	MoonsDir           = "moons.d"
+	LocalConfFile      = "local.conf"
+	AuthTokenFile      = "authtoken.secret"
+)
+
+const (
+	// SecretFilePerm is used for identity.secret and authtoken.secret
+	SecretFilePerm os.FileMode = 0600
+	// PublicFilePerm is used for every other file
+	PublicFilePerm os.FileMode = 0644
+	// DirPerm is used for the home directory and moons.d
+	DirPerm os.FileMode = 0755
+)
+
+// same length and alphabet zerotier-one uses when it creates authtoken.secret
+const (
+	authTokenLen      = 24
+	authTokenAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// Home is a ZeroTier home directory
+type Home struct {
+	Dir string
+}
+
+// New returns a Home for dir, the directory is created if it does not exist
+func New(dir string) (*Home, error) {
+	if err := os.MkdirAll(dir, DirPerm); err != nil {
+		return nil, err
+	}
+	return &Home{Dir: dir}, nil
+}
+
+// Path returns the path of a file inside the home directory
+func (h *Home) Path(name string) string {
+	return filepath.Join(h.Dir, name)
+}
+
+// ReadIdentity reads identity.secret and, if present, checks identity.public matches it
+func (h *Home) ReadIdentity() (*node.ZtIdentity, error) {
+	data, err := os.ReadFile(h.Path(IdentitySecretFile))
+	if err != nil {
+		return nil, err
+	}
+	defer ztcrypto.Wipe(data)
+	id, err := node.ParseZtIdentity(string(data))
+	if err != nil {
+		return nil, err
+	}
+	if !id.HasPrivateKey() {
+		return nil, node.ErrNoPrivateKey
+	}
+	pub, err := h.ReadPublicIdentity()
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		id.DestroyPrivateKey()
+		return nil, err
+	case pub.PublicKeyString() != id.PublicKeyString():
+		id.DestroyPrivateKey()
+		return nil, ErrIdentityMismatch
+	}
+	return id, nil
+}
+
+// ReadPublicIdentity reads identity.public
+func (h *Home) ReadPublicIdentity() (*node.ZtIdentity, error) {
+	data, err := os.ReadFile(h.Path(IdentityPublicFile))
+	if err != nil {
+		return nil, err
+	}
+	id, err := node.ParseZtIdentity(string(data))
+	if err != nil {
+		return nil, err
+	}
+	return id.PublicOnly(), nil
+}
+
+// WriteIdentity writes identity.secret (0600) and the matching identity.public
+func (h *Home) WriteIdentity(id *node.ZtIdentity) error {
+	if !id.HasPrivateKey() {
+		return node.ErrNoPrivateKey
+	}
+	secret := []byte(id.PrivateKeyString())
+	defer ztcrypto.Wipe(secret)
+	if err := WriteFileAtomic(h.Path(IdentitySecretFile), secret, SecretFilePerm); err != nil {
+		return err
+	}
+	return WriteFileAtomic(h.Path(IdentityPublicFile), []byte(id.PublicKeyString()), PublicFilePerm)
+}
+
+// ReadPlanet reads and decodes the planet file
+func (h *Home) ReadPlanet() (*node.ZtWorld, error) {
+	return readWorld(h.Path(PlanetFile), node.ZT_WORLD_TYPE_PLANET)
+}
+
+// WritePlanet checks data decodes as a planet and writes it to the planet file
+func (h *Home) WritePlanet(data []byte) error {
+	if _, err := decodeWorld(data, node.ZT_WORLD_TYPE_PLANET); err != nil {
+		return err
+	}
+	return WriteFileAtomic(h.Path(PlanetFile), data, PublicFilePerm)
+}
+
+// MoonPath returns the path of a moon inside moons.d, named the way zerotier-one expects
+func (h *Home) MoonPath(id node.ZtWorldID) string {
+	return filepath.Join(h.Dir, MoonsDir, fmt.Sprintf("%.16x.moon", id))
+}
+
+// ReadMoons reads and decodes every moon in moons.d, sorted by ID
+func (h *Home) ReadMoons() ([]*node.ZtWorld, error) {
+	entries, err := os.ReadDir(filepath.Join(h.Dir, MoonsDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	moons := make([]*node.ZtWorld, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".moon") {
+			continue
+		}
+		w, err := readWorld(filepath.Join(h.Dir, MoonsDir, e.Name()), node.ZT_WORLD_TYPE_MOON)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		moons = append(moons, w)
+	}
+	sort.Slice(moons, func(i, j int) bool { return moons[i].ID < moons[j].ID })
+	return moons, nil
+}
+
+// WriteMoon checks data decodes as a moon and writes it to moons.d, returning the decoded moon
+func (h *Home) WriteMoon(data []byte) (*node.ZtWorld, error) {
+	w, err := decodeWorld(data, node.ZT_WORLD_TYPE_MOON)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(h.Dir, MoonsDir), DirPerm); err != nil {
+		return nil, err
+	}
+	return w, WriteFileAtomic(h.MoonPath(w.ID), data, PublicFilePerm)
+}
+
+// RemoveMoon deletes a moon from moons.d, a missing moon is not an error
+func (h *Home) RemoveMoon(id node.ZtWorldID) error {
+	err := os.Remove(h.MoonPath(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// ReadLocalConf reads and validates local.conf, a missing file yields the zerotier-one defaults
+func (h *Home) ReadLocalConf() (*localconf.LocalConf, error) {
+	data, err := os.ReadFile(h.Path(LocalConfFile))
+	if os.IsNotExist(err) {
+		return localconf.New(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return localconf.Parse(data)
+}
+
+// WriteLocalConf validates conf and replaces local.conf with it
+func (h *Home) WriteLocalConf(conf *localconf.LocalConf) error {
+	data, err := conf.Marshal()
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(h.Path(LocalConfFile), data, PublicFilePerm)
+}
+
+// ReadAuthToken returns the local service API token from authtoken.secret
+func (h *Home) ReadAuthToken() (string, error) {
+	data, err := os.ReadFile(h.Path(AuthTokenFile))
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", ErrInvalidAuthToken
+	}
+	return token, nil
+}
+
+// WriteAuthToken writes authtoken.secret (0600)
+func (h *Home) WriteAuthToken(token string) error {
+	if token == "" || strings.ContainsAny(token, " \t\r\n") {
+		return ErrInvalidAuthToken
+	}
+	return WriteFileAtomic(h.Path(AuthTokenFile), []byte(token), SecretFilePerm)
+}
+
+// GenerateAuthToken creates a random token and writes it to authtoken.secret
+func (h *Home) GenerateAuthToken() (string, error) {
+	token := make([]byte, 0, authTokenLen)
+	buf := make([]byte, authTokenLen)
+	// reject bytes above the largest multiple of the alphabet size to avoid modulo bias
+	limit := 256 - 256%len(authTokenAlphabet)
+	for len(token) < authTokenLen {
+		if _, err := secrand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, v := range buf {
+			if int(v) < limit && len(token) < authTokenLen {
+				token = append(token, authTokenAlphabet[int(v)%len(authTokenAlphabet)])
+			}
+		}
+	}
+	return string(token), h.WriteAuthToken(string(token))
+}
+
+func readWorld(path string, wantType node.ZtWorldType) (*node.ZtWorld, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeWorld(data, wantType)
+}
+
+func decodeWorld(data []byte, wantType node.ZtWorldType) (*node.ZtWorld, error) {
+	w := &node.ZtWorld{}
+	if err := w.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	if w.Type != wantType {
+		return nil, ErrWrongWorldType
+	}
+	return w, nil
+}
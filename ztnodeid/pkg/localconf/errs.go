@@ -0,0 +1,22 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package localconf
+
+import "errors"
+
+var (
+	ErrNoPorts = errors.New("at least one port is required")
+)
+
+// ValidationError reports the local.conf key holding an invalid value
+type ValidationError struct {
+	Key    string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return "local.conf " + e.Key + ": " + e.Reason
+}
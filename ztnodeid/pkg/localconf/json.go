@@ -0,0 +1,112 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package localconf
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// the alias types drop the methods below so the default encoding can be reused
+type (
+	localConfAlias        LocalConf
+	physicalSettingsAlias PhysicalSettings
+	virtualSettingsAlias  VirtualSettings
+	settingsAlias         Settings
+)
+
+func (c *LocalConf) UnmarshalJSON(data []byte) (err error) {
+	c.extra, err = unmarshalWithExtra(data, (*localConfAlias)(c))
+	return err
+}
+
+func (c LocalConf) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra((localConfAlias)(c), c.extra)
+}
+
+func (p *PhysicalSettings) UnmarshalJSON(data []byte) (err error) {
+	p.extra, err = unmarshalWithExtra(data, (*physicalSettingsAlias)(p))
+	return err
+}
+
+func (p PhysicalSettings) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra((physicalSettingsAlias)(p), p.extra)
+}
+
+func (v *VirtualSettings) UnmarshalJSON(data []byte) (err error) {
+	v.extra, err = unmarshalWithExtra(data, (*virtualSettingsAlias)(v))
+	return err
+}
+
+func (v VirtualSettings) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra((virtualSettingsAlias)(v), v.extra)
+}
+
+func (s *Settings) UnmarshalJSON(data []byte) (err error) {
+	s.extra, err = unmarshalWithExtra(data, (*settingsAlias)(s))
+	return err
+}
+
+func (s Settings) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra((settingsAlias)(s), s.extra)
+}
+
+// unmarshalWithExtra decodes data into v and returns the keys v has no field for
+func unmarshalWithExtra(data []byte, v any) (map[string]json.RawMessage, error) {
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	for _, k := range jsonKeys(reflect.TypeOf(v).Elem()) {
+		delete(all, k)
+	}
+	if len(all) == 0 {
+		return nil, nil
+	}
+	return all, nil
+}
+
+// marshalWithExtra encodes v and merges back the keys kept by unmarshalWithExtra
+func marshalWithExtra(v any, extra map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	for k, val := range extra {
+		if _, ok := all[k]; !ok {
+			all[k] = val
+		}
+	}
+	return json.Marshal(all)
+}
+
+// jsonKeys lists the JSON object keys of the exported fields of struct type t
+func jsonKeys(t reflect.Type) []string {
+	keys := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		keys = append(keys, name)
+	}
+	return keys
+}
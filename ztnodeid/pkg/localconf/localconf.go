@@ -0,0 +1,194 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+// Package localconf models zerotier-one's local.conf.
+//
+// Code reproduced from https://docs.zerotier.com/config/#local-configuration-options
+// Keys this package does not know about are kept when a file is read and written back.
+package localconf
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+	"ztnodeid/pkg/node"
+)
+
+// ZT_DEFAULT_PORT is the primary port zerotier-one listens on unless configured otherwise
+const ZT_DEFAULT_PORT = 9993
+
+// LocalConf is the content of local.conf
+type LocalConf struct {
+	// Physical holds per-network settings, keyed by CIDR, e.g. "10.0.0.0/24"
+	Physical map[string]*PhysicalSettings `json:"physical,omitempty"`
+	// Virtual holds per-peer settings, keyed by 10-digit ZeroTier address
+	Virtual  map[string]*VirtualSettings `json:"virtual,omitempty"`
+	Settings Settings                    `json:"settings"`
+	extra    map[string]json.RawMessage
+}
+
+type PhysicalSettings struct {
+	Blacklist     bool   `json:"blacklist,omitempty"`
+	TrustedPathID uint64 `json:"trustedPathId,omitempty"`
+	MTU           int    `json:"mtu,omitempty"`
+	extra         map[string]json.RawMessage
+}
+
+type VirtualSettings struct {
+	// Try lists endpoints in <IPADDR>/<PORT> format to try in addition to those learned
+	Try []string `json:"try,omitempty"`
+	// Blacklist lists CIDRs never to use for this peer
+	Blacklist []string `json:"blacklist,omitempty"`
+	extra     map[string]json.RawMessage
+}
+
+type Settings struct {
+	// PrimaryPort is left out when zero, zerotier-one then uses ZT_DEFAULT_PORT. Writing 0 would
+	// make it pick a random port instead.
+	PrimaryPort              uint16   `json:"primaryPort,omitempty"`
+	SecondaryPort            uint16   `json:"secondaryPort,omitempty"`
+	TertiaryPort             uint16   `json:"tertiaryPort,omitempty"`
+	AllowSecondaryPort       *bool    `json:"allowSecondaryPort,omitempty"`
+	PortMappingEnabled       *bool    `json:"portMappingEnabled,omitempty"`
+	AllowTcpFallbackRelay    *bool    `json:"allowTcpFallbackRelay,omitempty"`
+	ForceTcpRelay            *bool    `json:"forceTcpRelay,omitempty"`
+	TcpFallbackRelay         string   `json:"tcpFallbackRelay,omitempty"`
+	InterfacePrefixBlacklist []string `json:"interfacePrefixBlacklist,omitempty"`
+	AllowManagementFrom      []string `json:"allowManagementFrom,omitempty"`
+	Bind                     []string `json:"bind,omitempty"`
+	extra                    map[string]json.RawMessage
+}
+
+// New returns the configuration zerotier-one uses when there is no local.conf
+func New() *LocalConf {
+	return &LocalConf{Settings: Settings{PrimaryPort: ZT_DEFAULT_PORT}}
+}
+
+// Parse decodes and validates local.conf content
+func Parse(data []byte) (*LocalConf, error) {
+	c := &LocalConf{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Marshal validates and encodes the configuration the way zerotier-one writes it
+func (c *LocalConf) Marshal() ([]byte, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// SetPorts makes zerotier-one listen on the given ports: the first is the primary port, the second,
+// if any, the secondary port. This is how ztnet keeps local.conf in line with its root endpoints.
+func (c *LocalConf) SetPorts(ports []uint16) error {
+	if len(ports) == 0 {
+		return ErrNoPorts
+	}
+	c.Settings.PrimaryPort = ports[0]
+	if len(ports) > 1 {
+		allow := true
+		c.Settings.SecondaryPort = ports[1]
+		c.Settings.AllowSecondaryPort = &allow
+	} else {
+		c.Settings.SecondaryPort = 0
+		c.Settings.AllowSecondaryPort = nil
+	}
+	return nil
+}
+
+// PortsFromEndpoints returns the distinct ports of endpoints in <IPADDR>/<PORT> format, in order
+func PortsFromEndpoints(endpoints []string) ([]uint16, error) {
+	ports := make([]uint16, 0, 2)
+	seen := make(map[uint16]bool)
+	for _, ep := range endpoints {
+		addr := &node.ZtNodeInetAddr{}
+		if err := addr.FromString(ep); err != nil {
+			return nil, err
+		}
+		if !seen[addr.Port] {
+			seen[addr.Port] = true
+			ports = append(ports, addr.Port)
+		}
+	}
+	return ports, nil
+}
+
+// Validate checks the values zerotier-one would reject or silently ignore
+func (c *LocalConf) Validate() error {
+	s := c.Settings
+	primary := s.PrimaryPort
+	if primary == 0 {
+		primary = ZT_DEFAULT_PORT
+	}
+	if s.SecondaryPort != 0 && s.SecondaryPort == primary {
+		return &ValidationError{Key: "settings.secondaryPort", Reason: "must differ from primaryPort"}
+	}
+	if s.TertiaryPort != 0 && (s.TertiaryPort == primary || s.TertiaryPort == s.SecondaryPort) {
+		return &ValidationError{Key: "settings.tertiaryPort", Reason: "must differ from primaryPort and secondaryPort"}
+	}
+	if s.TcpFallbackRelay != "" {
+		if err := validateEndpoint(s.TcpFallbackRelay); err != nil {
+			return &ValidationError{Key: "settings.tcpFallbackRelay", Reason: err.Error()}
+		}
+	}
+	for _, p := range s.InterfacePrefixBlacklist {
+		if strings.TrimSpace(p) == "" {
+			return &ValidationError{Key: "settings.interfacePrefixBlacklist", Reason: "empty prefix"}
+		}
+	}
+	for _, n := range s.AllowManagementFrom {
+		if _, _, err := net.ParseCIDR(n); err != nil {
+			return &ValidationError{Key: "settings.allowManagementFrom", Reason: err.Error()}
+		}
+	}
+	for _, b := range s.Bind {
+		if net.ParseIP(b) == nil {
+			return &ValidationError{Key: "settings.bind", Reason: "invalid address " + strconv.Quote(b)}
+		}
+	}
+	for k, v := range c.Physical {
+		if _, _, err := net.ParseCIDR(k); err != nil {
+			return &ValidationError{Key: "physical." + k, Reason: err.Error()}
+		}
+		if v != nil && v.MTU < 0 {
+			return &ValidationError{Key: "physical." + k + ".mtu", Reason: "must not be negative"}
+		}
+	}
+	for k, v := range c.Virtual {
+		if _, err := node.ParseZtAddress(k); err != nil {
+			return &ValidationError{Key: "virtual." + k, Reason: "not a ZeroTier address"}
+		}
+		if v == nil {
+			continue
+		}
+		for _, t := range v.Try {
+			if err := validateEndpoint(t); err != nil {
+				return &ValidationError{Key: "virtual." + k + ".try", Reason: err.Error()}
+			}
+		}
+		for _, b := range v.Blacklist {
+			if _, _, err := net.ParseCIDR(b); err != nil {
+				return &ValidationError{Key: "virtual." + k + ".blacklist", Reason: err.Error()}
+			}
+		}
+	}
+	return nil
+}
+
+func validateEndpoint(ep string) error {
+	addr := &node.ZtNodeInetAddr{}
+	return addr.FromString(ep)
+}
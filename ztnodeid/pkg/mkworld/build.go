@@ -0,0 +1,129 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package mkworld
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"ztnodeid/pkg/node"
+)
+
+var (
+	ErrWorldSigningKeyIllegal = errors.New("world signing key current.c25519 / previous.c25519 is illegal")
+	ErrPreflightCheckFailed   = errors.New("preflight check failed, internal requirement cannot be satisfied")
+	ErrUseRecommendValue      = errors.New("potential risk of failed execution, use recommendation if possible")
+)
+
+// ZT_WORLD_EARTH_BIRTH is the timestamp of the official Earth planet, custom worlds must be newer
+const ZT_WORLD_EARTH_BIRTH = 1567191349589
+
+// Check validates the config against world limits. ErrPreflightCheckFailed means the world cannot
+// be built, ErrUseRecommendValue means planet ID or birth collide with the official world.
+func (c *MkWorldConfig) Check() error {
+	if len(c.SigningKeyFiles) != 2 {
+		return fmt.Errorf("%w: signing key must have 2 files", ErrPreflightCheckFailed)
+	}
+	if len(c.RootNodes) > node.ZT_WORLD_MAX_ROOTS {
+		return fmt.Errorf("%w: root nodes are too many", ErrPreflightCheckFailed)
+	}
+	for _, v := range c.RootNodes {
+		if len(v.Endpoints) > node.ZT_WORLD_MAX_STABLE_ENDPOINTS_PER_ROOT {
+			return fmt.Errorf("%w: stable endpoints for root node are too many", ErrPreflightCheckFailed)
+		}
+	}
+	if c.PlanetID == node.ZT_WORLD_ID_EARTH || c.PlanetID == node.ZT_WORLD_ID_MARS || c.PlanetBirth == ZT_WORLD_EARTH_BIRTH {
+		return fmt.Errorf("%w: planet ID / birth is currently in use", ErrUseRecommendValue)
+	}
+	if c.PlanetBirth <= ZT_WORLD_EARTH_BIRTH {
+		return fmt.Errorf("%w: timestamp should be larger than %d", ErrUseRecommendValue, uint64(ZT_WORLD_EARTH_BIRTH))
+	}
+	return nil
+}
+
+// Recommend replaces planet ID and birth with a random unreserved ID and the current time
+func (c *MkWorldConfig) Recommend() error {
+	id, err := RandomWorldID()
+	if err != nil {
+		return err
+	}
+	c.PlanetID = id
+	c.PlanetBirth = (uint64)(time.Now().UnixMilli())
+	return nil
+}
+
+// BuildRootNodes parses the root identities and endpoints of the config
+func (c *MkWorldConfig) BuildRootNodes() ([]*node.ZtWorldPlanetNode, error) {
+	res := []*node.ZtWorldPlanetNode{}
+	for _, v := range c.RootNodes {
+		n1 := &node.ZtWorldPlanetNode{}
+		n1ep := make([]*node.ZtNodeInetAddr, 0)
+		n1id := &node.ZtWorldPlanetNodeIdentity{}
+		err := n1id.FromString(v.IdentityStr, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, v2 := range v.Endpoints {
+			n1addr := &node.ZtNodeInetAddr{}
+			err := n1addr.FromString(v2)
+			if err != nil {
+				return nil, err
+			}
+			n1ep = append(n1ep, n1addr)
+		}
+		n1.Identity = n1id
+		n1.Endpoints = n1ep
+		res = append(res, n1)
+	}
+	return res, nil
+}
+
+// World returns the unsigned planet described by the config
+func (c *MkWorldConfig) World() (*node.ZtWorld, error) {
+	nodes, err := c.BuildRootNodes()
+	if err != nil {
+		return nil, err
+	}
+	return &node.ZtWorld{
+		Type:      node.ZT_WORLD_TYPE_PLANET,
+		ID:        c.PlanetID,
+		Timestamp: c.PlanetBirth,
+		Nodes:     nodes,
+	}, nil
+}
+
+// SignWorld makes next the key future updates must be signed by, signs w with signer and returns
+// the serialized world. w.Signature is updated.
+// signer must be the key designated by the world clients currently hold, or next itself initially.
+func SignWorld(w *node.ZtWorld, signer *SigningKey, next *SigningKey) ([]byte, error) {
+	w.PublicKeyMustBeSignedByNextTime = next.Public
+	toSign, err := w.Serialize(true, [node.ZT_C25519_SIGNATURE_LEN]byte{})
+	if err != nil {
+		return nil, err
+	}
+	sig, err := signer.Sign(toSign)
+	if err != nil {
+		return nil, err
+	}
+	w.Signature = sig
+	return w.Serialize(false, sig)
+}
+
+// CArray formats a serialized world as the C source zerotier-one embeds as its default world
+func CArray(world []byte) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "#define ZT_DEFAULT_WORLD_LENGTH %d\n", len(world))
+	sb.WriteString("static const unsigned char ZT_DEFAULT_WORLD[ZT_DEFAULT_WORLD_LENGTH] = {")
+	for i, v := range world {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, "0x%02x", v)
+	}
+	sb.WriteString("};\n")
+	return sb.String()
+}
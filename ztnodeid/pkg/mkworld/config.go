@@ -0,0 +1,101 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+// Package mkworld holds the configuration and world building logic behind the ztmkworld command.
+package mkworld
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"ztnodeid/pkg/localconf"
+	"ztnodeid/pkg/node"
+)
+
+var (
+	ErrRootNotFound = errors.New("root node not found in config")
+)
+
+// MkWorldConfig describes a planet. JSON keys are kept short for ztnet, YAML and TOML use readable
+// ones, see ConfigFormat.
+type MkWorldConfig struct {
+	SigningKeyFiles []string      `json:"signing" yaml:"signing" toml:"signing"`
+	OutputFile      string        `json:"output" yaml:"output" toml:"output"`
+	RootNodes       []MkWorldNode `json:"rootNodes" yaml:"rootNodes" toml:"rootNodes"`
+	PlanetID        uint64        `json:"plID" yaml:"planetId" toml:"planetId"`
+	PlanetBirth     uint64        `json:"plBirth" yaml:"planetBirth" toml:"planetBirth"`
+	PlanetRecommend bool          `json:"plRecommend" yaml:"planetRecommend" toml:"planetRecommend"`
+}
+
+type MkWorldNode struct {
+	Comments    string   `json:"comments,omitempty" yaml:"comments,omitempty" toml:"comments,omitempty"`
+	IdentityStr string   `json:"identity" yaml:"identity" toml:"identity"`
+	Endpoints   []string `json:"endpoints" yaml:"endpoints" toml:"endpoints"`
+}
+
+// Clone returns a deep copy, e.g. to keep the config as written before ResolvePaths and ApplyEnv
+func (c *MkWorldConfig) Clone() *MkWorldConfig {
+	res := *c
+	res.SigningKeyFiles = slices.Clone(c.SigningKeyFiles)
+	res.RootNodes = make([]MkWorldNode, 0, len(c.RootNodes))
+	for _, n := range c.RootNodes {
+		n.Endpoints = slices.Clone(n.Endpoints)
+		res.RootNodes = append(res.RootNodes, n)
+	}
+	return &res
+}
+
+// ResolvePaths makes relative signing key and output paths relative to baseDir, usually the
+// directory of the config file
+func (c *MkWorldConfig) ResolvePaths(baseDir string) {
+	for i, p := range c.SigningKeyFiles {
+		c.SigningKeyFiles[i] = resolvePath(baseDir, p)
+	}
+	c.OutputFile = resolvePath(baseDir, c.OutputFile)
+}
+
+func resolvePath(baseDir string, p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(baseDir, p)
+}
+
+// FindRoot returns the root node with the given address, an empty address selects the first root
+func (c *MkWorldConfig) FindRoot(address string) (*MkWorldNode, error) {
+	for i := range c.RootNodes {
+		if address == "" {
+			return &c.RootNodes[i], nil
+		}
+		id, err := node.ParseZtIdentity(c.RootNodes[i].IdentityStr)
+		if err != nil {
+			return nil, err
+		}
+		if id.Address.String() == address {
+			return &c.RootNodes[i], nil
+		}
+	}
+	return nil, ErrRootNotFound
+}
+
+// LocalConf updates conf so the root with the given address listens on the ports of its endpoints,
+// an empty address selects the first root. A nil conf starts from the zerotier-one defaults.
+func (c *MkWorldConfig) LocalConf(address string, conf *localconf.LocalConf) (*localconf.LocalConf, error) {
+	root, err := c.FindRoot(address)
+	if err != nil {
+		return nil, err
+	}
+	ports, err := localconf.PortsFromEndpoints(root.Endpoints)
+	if err != nil {
+		return nil, err
+	}
+	if conf == nil {
+		conf = localconf.New()
+	}
+	if err := conf.SetPorts(ports); err != nil {
+		return nil, err
+	}
+	return conf, conf.Validate()
+}
@@ -0,0 +1,263 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package mkworld
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"ztnodeid/pkg/node"
+	"ztnodeid/pkg/ztcrypto"
+)
+
+var (
+	ErrWorldIDChanged       = errors.New("world ID changed, clients treat the new world as unrelated")
+	ErrWorldTypeChanged     = errors.New("world type changed")
+	ErrTimestampNotIncrease = errors.New("world timestamp did not increase, clients ignore the update")
+	ErrSigningChainBroken   = errors.New("new world is not signed by the next key of the old world, clients reject the update")
+)
+
+// WorldDiff lists the semantic differences between a deployed world and its replacement
+type WorldDiff struct {
+	OldType      node.ZtWorldType `json:"oldType"`
+	NewType      node.ZtWorldType `json:"newType"`
+	OldID        node.ZtWorldID   `json:"oldId"`
+	NewID        node.ZtWorldID   `json:"newId"`
+	OldTimestamp uint64           `json:"oldTimestamp"`
+	NewTimestamp uint64           `json:"newTimestamp"`
+	// OldNextKey and NewNextKey are fingerprints of the key the following world must be signed by
+	OldNextKey string `json:"oldNextKey"`
+	NewNextKey string `json:"newNextKey"`
+	// SignedByOld is set when the new world verifies against the next key of the old world
+	SignedByOld  bool       `json:"signedByOld"`
+	RootsAdded   []string   `json:"rootsAdded"`
+	RootsRemoved []string   `json:"rootsRemoved"`
+	RootsChanged []RootDiff `json:"rootsChanged"`
+	// DictionaryChanged is set when the encoded moon dictionaries differ, even if only in entry
+	// order; the key lists say which entries differ
+	DictionaryChanged     bool     `json:"dictionaryChanged,omitempty"`
+	DictionaryKeysAdded   []string `json:"dictionaryKeysAdded"`
+	DictionaryKeysRemoved []string `json:"dictionaryKeysRemoved"`
+	DictionaryKeysChanged []string `json:"dictionaryKeysChanged"`
+}
+
+// RootDiff lists the changes of a root present in both worlds
+type RootDiff struct {
+	Address string `json:"address"`
+	// KeyChanged is set when the address is kept but the public key differs, which a valid
+	// identity cannot do
+	KeyChanged       bool     `json:"keyChanged,omitempty"`
+	EndpointsAdded   []string `json:"endpointsAdded"`
+	EndpointsRemoved []string `json:"endpointsRemoved"`
+	// EndpointsReordered is set when the endpoint set is equal but the order differs
+	EndpointsReordered bool `json:"endpointsReordered,omitempty"`
+}
+
+// DiffWorlds compares the deployed world old with its replacement next
+func DiffWorlds(old *node.ZtWorld, next *node.ZtWorld) *WorldDiff {
+	d := &WorldDiff{
+		OldType:      old.Type,
+		NewType:      next.Type,
+		OldID:        old.ID,
+		NewID:        next.ID,
+		OldTimestamp: old.Timestamp,
+		NewTimestamp: next.Timestamp,
+		OldNextKey:   ztcrypto.Fingerprint(old.PublicKeyMustBeSignedByNextTime[:]),
+		NewNextKey:   ztcrypto.Fingerprint(next.PublicKeyMustBeSignedByNextTime[:]),
+		SignedByOld:  next.Verify(old.PublicKeyMustBeSignedByNextTime) == nil,
+		RootsAdded:   []string{},
+		RootsRemoved: []string{},
+		RootsChanged: []RootDiff{},
+	}
+	oldRoots := make(map[string]*node.ZtWorldPlanetNode, len(old.Nodes))
+	for _, n := range old.Nodes {
+		oldRoots[rootAddress(n)] = n
+	}
+	newRoots := make(map[string]bool, len(next.Nodes))
+	for _, n := range next.Nodes {
+		addr := rootAddress(n)
+		newRoots[addr] = true
+		o, ok := oldRoots[addr]
+		if !ok {
+			d.RootsAdded = append(d.RootsAdded, addr)
+			continue
+		}
+		if rd, changed := diffRoot(o, n); changed {
+			d.RootsChanged = append(d.RootsChanged, rd)
+		}
+	}
+	for _, n := range old.Nodes {
+		if addr := rootAddress(n); !newRoots[addr] {
+			d.RootsRemoved = append(d.RootsRemoved, addr)
+		}
+	}
+	diffDictionary(d, &old.Dictionary, &next.Dictionary)
+	return d
+}
+
+// diffDictionary compares the attached dictionaries of two moons by key
+func diffDictionary(d *WorldDiff, old *node.Dictionary, next *node.Dictionary) {
+	d.DictionaryKeysAdded = []string{}
+	d.DictionaryKeysRemoved = []string{}
+	d.DictionaryKeysChanged = []string{}
+	d.DictionaryChanged = !bytes.Equal(old.Bytes(), next.Bytes())
+	if !d.DictionaryChanged {
+		return
+	}
+	oldEntries, newEntries := old.Map(), next.Map()
+	for k, v := range newEntries {
+		ov, ok := oldEntries[k]
+		switch {
+		case !ok:
+			d.DictionaryKeysAdded = append(d.DictionaryKeysAdded, k)
+		case ov != v:
+			d.DictionaryKeysChanged = append(d.DictionaryKeysChanged, k)
+		}
+	}
+	for k := range oldEntries {
+		if _, ok := newEntries[k]; !ok {
+			d.DictionaryKeysRemoved = append(d.DictionaryKeysRemoved, k)
+		}
+	}
+	sort.Strings(d.DictionaryKeysAdded)
+	sort.Strings(d.DictionaryKeysRemoved)
+	sort.Strings(d.DictionaryKeysChanged)
+}
+
+func rootAddress(n *node.ZtWorldPlanetNode) string {
+	return hex.EncodeToString(n.Identity.ZtNodeAddress[:])
+}
+
+func diffRoot(old *node.ZtWorldPlanetNode, next *node.ZtWorldPlanetNode) (RootDiff, bool) {
+	rd := RootDiff{
+		Address:          rootAddress(next),
+		KeyChanged:       old.Identity.ToString(false) != next.Identity.ToString(false),
+		EndpointsAdded:   []string{},
+		EndpointsRemoved: []string{},
+	}
+	oldEps := make(map[string]bool, len(old.Endpoints))
+	for _, ep := range old.Endpoints {
+		oldEps[ep.String()] = true
+	}
+	newEps := make(map[string]bool, len(next.Endpoints))
+	for _, ep := range next.Endpoints {
+		newEps[ep.String()] = true
+		if !oldEps[ep.String()] {
+			rd.EndpointsAdded = append(rd.EndpointsAdded, ep.String())
+		}
+	}
+	for _, ep := range old.Endpoints {
+		if !newEps[ep.String()] {
+			rd.EndpointsRemoved = append(rd.EndpointsRemoved, ep.String())
+		}
+	}
+	if len(rd.EndpointsAdded) == 0 && len(rd.EndpointsRemoved) == 0 && len(old.Endpoints) == len(next.Endpoints) {
+		for i := range old.Endpoints {
+			if old.Endpoints[i].String() != next.Endpoints[i].String() {
+				rd.EndpointsReordered = true
+				break
+			}
+		}
+	}
+	changed := rd.KeyChanged || rd.EndpointsReordered || len(rd.EndpointsAdded) > 0 || len(rd.EndpointsRemoved) > 0
+	return rd, changed
+}
+
+// SigningKeyRotated reports whether the new world names a different key for the following update
+func (d *WorldDiff) SigningKeyRotated() bool {
+	return d.OldNextKey != d.NewNextKey
+}
+
+// RootsModified reports whether the root set or any endpoint differs
+func (d *WorldDiff) RootsModified() bool {
+	return len(d.RootsAdded) > 0 || len(d.RootsRemoved) > 0 || len(d.RootsChanged) > 0
+}
+
+// Err returns why clients would not accept the new world as an update of the old one, or nil
+func (d *WorldDiff) Err() error {
+	var errs []error
+	if d.OldID != d.NewID {
+		errs = append(errs, ErrWorldIDChanged)
+	}
+	if d.OldType != d.NewType {
+		errs = append(errs, ErrWorldTypeChanged)
+	}
+	if d.NewTimestamp <= d.OldTimestamp {
+		errs = append(errs, ErrTimestampNotIncrease)
+	}
+	if !d.SignedByOld {
+		errs = append(errs, ErrSigningChainBroken)
+	}
+	return errors.Join(errs...)
+}
+
+// WriteText prints the differences for humans, fatal problems first
+func (d *WorldDiff) WriteText(out io.Writer) error {
+	var err error
+	printf := func(format string, a ...any) {
+		if err == nil {
+			_, err = fmt.Fprintf(out, format, a...)
+		}
+	}
+	if d.OldID != d.NewID {
+		printf("FATAL id changed: %d -> %d\n", d.OldID, d.NewID)
+	}
+	if d.OldType != d.NewType {
+		printf("FATAL type changed: %s -> %s\n", worldTypeName(d.OldType), worldTypeName(d.NewType))
+	}
+	switch {
+	case d.NewTimestamp > d.OldTimestamp:
+		printf("timestamp bumped: %d -> %d\n", d.OldTimestamp, d.NewTimestamp)
+	default:
+		printf("FATAL timestamp not increased: %d -> %d\n", d.OldTimestamp, d.NewTimestamp)
+	}
+	if !d.SignedByOld {
+		printf("FATAL not signed by the next key of the old world %s\n", d.OldNextKey)
+	}
+	if d.SigningKeyRotated() {
+		printf("signing key rotated: %s -> %s\n", d.OldNextKey, d.NewNextKey)
+	}
+	for _, addr := range d.RootsAdded {
+		printf("+ root %s\n", addr)
+	}
+	for _, addr := range d.RootsRemoved {
+		printf("- root %s\n", addr)
+	}
+	for _, rd := range d.RootsChanged {
+		printf("~ root %s\n", rd.Address)
+		if rd.KeyChanged {
+			printf("    public key changed\n")
+		}
+		for _, ep := range rd.EndpointsAdded {
+			printf("    + %s\n", ep)
+		}
+		for _, ep := range rd.EndpointsRemoved {
+			printf("    - %s\n", ep)
+		}
+		if rd.EndpointsReordered {
+			printf("    endpoints reordered\n")
+		}
+	}
+	if !d.RootsModified() {
+		printf("roots unchanged\n")
+	}
+	for _, k := range d.DictionaryKeysAdded {
+		printf("+ dictionary %s\n", k)
+	}
+	for _, k := range d.DictionaryKeysRemoved {
+		printf("- dictionary %s\n", k)
+	}
+	for _, k := range d.DictionaryKeysChanged {
+		printf("~ dictionary %s\n", k)
+	}
+	if d.DictionaryChanged && len(d.DictionaryKeysAdded)+len(d.DictionaryKeysRemoved)+len(d.DictionaryKeysChanged) == 0 {
+		printf("dictionary reordered\n")
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package mkworld
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// environment variables overriding config fields, list values are comma separated and
+// MKWORLD_ROOT_NODES holds the rootNodes array as JSON
+const (
+	EnvSigning         = "MKWORLD_SIGNING"
+	EnvOutput          = "MKWORLD_OUTPUT"
+	EnvRootNodes       = "MKWORLD_ROOT_NODES"
+	EnvPlanetID        = "MKWORLD_PLANET_ID"
+	EnvPlanetBirth     = "MKWORLD_PLANET_BIRTH"
+	EnvPlanetRecommend = "MKWORLD_PLANET_RECOMMEND"
+)
+
+var envOverrides = []struct {
+	name  string
+	apply func(c *MkWorldConfig, v string) error
+}{
+	{EnvSigning, func(c *MkWorldConfig, v string) error {
+		c.SigningKeyFiles = strings.Split(v, ",")
+		for i := range c.SigningKeyFiles {
+			c.SigningKeyFiles[i] = strings.TrimSpace(c.SigningKeyFiles[i])
+		}
+		return nil
+	}},
+	{EnvOutput, func(c *MkWorldConfig, v string) error {
+		c.OutputFile = v
+		return nil
+	}},
+	{EnvRootNodes, func(c *MkWorldConfig, v string) error {
+		var nodes []MkWorldNode
+		if err := json.Unmarshal([]byte(v), &nodes); err != nil {
+			return err
+		}
+		c.RootNodes = nodes
+		return nil
+	}},
+	{EnvPlanetID, func(c *MkWorldConfig, v string) (err error) {
+		c.PlanetID, err = strconv.ParseUint(v, 10, 64)
+		return err
+	}},
+	{EnvPlanetBirth, func(c *MkWorldConfig, v string) (err error) {
+		c.PlanetBirth, err = strconv.ParseUint(v, 10, 64)
+		return err
+	}},
+	{EnvPlanetRecommend, func(c *MkWorldConfig, v string) (err error) {
+		c.PlanetRecommend, err = strconv.ParseBool(v)
+		return err
+	}},
+}
+
+// ApplyEnv overrides config fields with the MKWORLD_* variables that are set, lookup is usually
+// os.LookupEnv. Paths are taken as given.
+func (c *MkWorldConfig) ApplyEnv(lookup func(string) (string, bool)) error {
+	for _, o := range envOverrides {
+		v, ok := lookup(o.name)
+		if !ok {
+			continue
+		}
+		if err := o.apply(c, v); err != nil {
+			return fmt.Errorf("%s: %w", o.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package mkworld
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrUnknownConfigFormat = errors.New("unknown config format, use json, yaml or toml")
+)
+
+// ConfigFormat is the file format of a mkworld config. JSON uses the short keys ztnet writes
+// (plID, plBirth, plRecommend) and also accepts the readable keys YAML and TOML use (planetId,
+// planetBirth, planetRecommend).
+type ConfigFormat string
+
+const (
+	ConfigFormatJSON ConfigFormat = "json"
+	ConfigFormatYAML ConfigFormat = "yaml"
+	ConfigFormatTOML ConfigFormat = "toml"
+)
+
+// ConfigFormatFromPath picks the format by extension, anything but .yaml, .yml and .toml is JSON
+func ConfigFormatFromPath(path string) ConfigFormat {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return ConfigFormatYAML
+	case ".toml":
+		return ConfigFormatTOML
+	default:
+		return ConfigFormatJSON
+	}
+}
+
+// ParseConfigFormat validates a format name given by the user
+func ParseConfigFormat(name string) (ConfigFormat, error) {
+	switch f := ConfigFormat(strings.ToLower(name)); f {
+	case ConfigFormatJSON, ConfigFormatYAML, ConfigFormatTOML:
+		return f, nil
+	case "yml":
+		return ConfigFormatYAML, nil
+	default:
+		return "", ErrUnknownConfigFormat
+	}
+}
+
+// LoadConfig reads a config in the format given by its extension
+func LoadConfig(path string) (*MkWorldConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data, ConfigFormatFromPath(path))
+}
+
+// ReadConfig decodes a config read from r, e.g. stdin
+func ReadConfig(r io.Reader, format ConfigFormat) (*MkWorldConfig, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data, format)
+}
+
+// ParseConfig decodes a config, unknown keys are rejected in YAML and TOML since they are written
+// by hand
+func ParseConfig(data []byte, format ConfigFormat) (*MkWorldConfig, error) {
+	c := &MkWorldConfig{}
+	switch format {
+	case ConfigFormatJSON:
+		if err := json.Unmarshal(data, c); err != nil {
+			return nil, err
+		}
+	case ConfigFormatYAML:
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil {
+			return nil, err
+		}
+	case ConfigFormatTOML:
+		md, err := toml.Decode(string(data), c)
+		if err != nil {
+			return nil, err
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, errors.New("unknown config key " + undecoded[0].String())
+		}
+	default:
+		return nil, ErrUnknownConfigFormat
+	}
+	return c, nil
+}
+
+// Marshal encodes the config in the given format
+func (c *MkWorldConfig) Marshal(format ConfigFormat) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case ConfigFormatJSON:
+		data, err := json.MarshalIndent(c, "", "    ")
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	case ConfigFormatYAML:
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(c); err != nil {
+			return nil, err
+		}
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+	case ConfigFormatTOML:
+		if err := toml.NewEncoder(&buf).Encode(c); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnknownConfigFormat
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON accepts the readable keys next to the short ones, the readable key wins
+func (c *MkWorldConfig) UnmarshalJSON(data []byte) error {
+	type plain MkWorldConfig
+	aux := struct {
+		*plain
+		PlanetID        *uint64 `json:"planetId"`
+		PlanetBirth     *uint64 `json:"planetBirth"`
+		PlanetRecommend *bool   `json:"planetRecommend"`
+	}{plain: (*plain)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.PlanetID != nil {
+		c.PlanetID = *aux.PlanetID
+	}
+	if aux.PlanetBirth != nil {
+		c.PlanetBirth = *aux.PlanetBirth
+	}
+	if aux.PlanetRecommend != nil {
+		c.PlanetRecommend = *aux.PlanetRecommend
+	}
+	return nil
+}
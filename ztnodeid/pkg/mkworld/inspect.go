@@ -0,0 +1,164 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package mkworld
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	"ztnodeid/pkg/node"
+	"ztnodeid/pkg/ztcrypto"
+)
+
+// WorldReport is a readable summary of a serialized planet or moon
+type WorldReport struct {
+	Type      string           `json:"type"`
+	TypeID    node.ZtWorldType `json:"typeId"`
+	ID        node.ZtWorldID   `json:"id"`
+	Timestamp uint64           `json:"timestamp"`
+	Birth     time.Time        `json:"birth"`
+	// NextKey must sign the next world with the same ID
+	NextKey            string          `json:"nextKey"`
+	NextKeyFingerprint string          `json:"nextKeyFingerprint"`
+	Signature          SignatureReport `json:"signature"`
+	Roots              []RootReport    `json:"roots"`
+	MaxRoots           int             `json:"maxRoots"`
+	Size               int             `json:"size"`
+	MaxSize            int             `json:"maxSize"`
+}
+
+type SignatureReport struct {
+	Valid bool `json:"valid"`
+	// SignedBy is the fingerprint of the key the signature was checked against
+	SignedBy string `json:"signedBy"`
+	// SelfSigned is set when no key was given and the world was checked against its own next key,
+	// which only holds for the first world built from a pair of signing keys
+	SelfSigned bool   `json:"selfSigned"`
+	Error      string `json:"error,omitempty"`
+}
+
+type RootReport struct {
+	Address      string              `json:"address"`
+	IdentityType node.ZtIdentityType `json:"identityType"`
+	Fingerprint  string              `json:"fingerprint"`
+	Identity     string              `json:"identity"`
+	Endpoints    []EndpointReport    `json:"endpoints"`
+}
+
+type EndpointReport struct {
+	Address string `json:"address"`
+	// Family is "IPv4" or "IPv6"
+	Family string `json:"family"`
+}
+
+// Inspect decodes a world and checks its signature against signedBy, or against the next key of
+// the world itself if signedBy is nil
+func Inspect(data []byte, signedBy *[node.ZT_C25519_PUBLIC_KEY_LEN]byte) (*WorldReport, error) {
+	// UnmarshalBinary would refuse a world above ZT_WORLD_MAX_SERIALIZED_LENGTH, which the report
+	// should flag instead
+	w := &node.ZtWorld{}
+	n, err := w.Deserialize(data)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(data) {
+		return nil, node.ErrInvalidData
+	}
+	r := &WorldReport{
+		Type:               worldTypeName(w.Type),
+		TypeID:             w.Type,
+		ID:                 w.ID,
+		Timestamp:          w.Timestamp,
+		Birth:              time.UnixMilli(int64(w.Timestamp)).UTC(),
+		NextKey:            hex.EncodeToString(w.PublicKeyMustBeSignedByNextTime[:]),
+		NextKeyFingerprint: ztcrypto.Fingerprint(w.PublicKeyMustBeSignedByNextTime[:]),
+		Roots:              make([]RootReport, 0, len(w.Nodes)),
+		MaxRoots:           node.ZT_WORLD_MAX_ROOTS,
+		Size:               len(data),
+		MaxSize:            node.ZT_WORLD_MAX_SERIALIZED_LENGTH,
+	}
+	key := w.PublicKeyMustBeSignedByNextTime
+	if signedBy != nil {
+		key = *signedBy
+	} else {
+		r.Signature.SelfSigned = true
+	}
+	r.Signature.SignedBy = ztcrypto.Fingerprint(key[:])
+	if err := w.Verify(key); err != nil {
+		r.Signature.Error = err.Error()
+	} else {
+		r.Signature.Valid = true
+	}
+	for _, n := range w.Nodes {
+		id := n.Identity.ToIdentity()
+		root := RootReport{
+			Address:      id.Address.String(),
+			IdentityType: id.Type,
+			Fingerprint:  id.Fingerprint(),
+			Identity:     id.PublicKeyString(),
+			Endpoints:    make([]EndpointReport, 0, len(n.Endpoints)),
+		}
+		for _, ep := range n.Endpoints {
+			family := "IPv4"
+			if ep.Family() == syscall.AF_INET6 {
+				family = "IPv6"
+			}
+			root.Endpoints = append(root.Endpoints, EndpointReport{Address: ep.String(), Family: family})
+		}
+		r.Roots = append(r.Roots, root)
+	}
+	return r, nil
+}
+
+// WriteText prints the report for humans
+func (r *WorldReport) WriteText(out io.Writer) error {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "type:\t%s (%d)\n", r.Type, r.TypeID)
+	fmt.Fprintf(tw, "id:\t%d (%.16x)\n", r.ID, r.ID)
+	fmt.Fprintf(tw, "birth:\t%s (%d)\n", r.Birth.Format(time.RFC3339Nano), r.Timestamp)
+	fmt.Fprintf(tw, "next signing key:\t%s\n", r.NextKeyFingerprint)
+	switch {
+	case r.Size > r.MaxSize:
+		fmt.Fprintf(tw, "signature:\tnot checked, the world is too large to be signed\n")
+	case r.Signature.Valid && r.Signature.SelfSigned:
+		fmt.Fprintf(tw, "signature:\tvalid, signed by its own next key %s\n", r.Signature.SignedBy)
+	case r.Signature.Valid:
+		fmt.Fprintf(tw, "signature:\tvalid, signed by %s\n", r.Signature.SignedBy)
+	case r.Signature.SelfSigned:
+		fmt.Fprintf(tw, "signature:\tnot signed by its own next key, give the signing key to check it\n")
+	default:
+		fmt.Fprintf(tw, "signature:\tINVALID for %s: %s\n", r.Signature.SignedBy, r.Signature.Error)
+	}
+	if r.Size > r.MaxSize {
+		fmt.Fprintf(tw, "size:\t%d / %d bytes, TOO LARGE: zerotier-one rejects it\n", r.Size, r.MaxSize)
+	} else {
+		fmt.Fprintf(tw, "size:\t%d / %d bytes\n", r.Size, r.MaxSize)
+	}
+	fmt.Fprintf(tw, "roots:\t%d / %d\n", len(r.Roots), r.MaxRoots)
+	for _, root := range r.Roots {
+		fmt.Fprintf(tw, "  %s\ttype %d, key %s\n", root.Address, root.IdentityType, root.Fingerprint)
+		for _, ep := range root.Endpoints {
+			fmt.Fprintf(tw, "    %s\t%s\n", ep.Family, ep.Address)
+		}
+	}
+	return tw.Flush()
+}
+
+func worldTypeName(t node.ZtWorldType) string {
+	switch t {
+	case node.ZT_WORLD_TYPE_PLANET:
+		return "planet"
+	case node.ZT_WORLD_TYPE_MOON:
+		return "moon"
+	case node.ZT_WORLD_TYPE_NULL:
+		return "null"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,104 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package mkworld
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+	"ztnodeid/pkg/node"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrSignerNotInChain    = errors.New("signing key is not the next key of the deployed world")
+	ErrDeployedNotVerified = errors.New("deployed world is not signed by its next key or by any available signing key")
+)
+
+// DesiredState is the root set a world should have. A mkworld config is a valid desired state, only
+// its rootNodes are used.
+type DesiredState struct {
+	RootNodes []MkWorldNode `json:"rootNodes" yaml:"rootNodes" toml:"rootNodes"`
+}
+
+// LoadDesiredState reads a desired state in the format given by its extension, see
+// ConfigFormatFromPath. Keys other than rootNodes are ignored.
+func LoadDesiredState(path string) (*DesiredState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ds := &DesiredState{}
+	switch ConfigFormatFromPath(path) {
+	case ConfigFormatYAML:
+		err = yaml.Unmarshal(data, ds)
+	case ConfigFormatTOML:
+		_, err = toml.Decode(string(data), ds)
+	default:
+		err = json.Unmarshal(data, ds)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// Reconcile returns the world deployed should become to have the desired roots, keeping its type,
+// ID, next key and moon dictionary. The timestamp is now in milliseconds, or one above the deployed
+// timestamp if that is not newer. A nil world is returned when the roots already match.
+func Reconcile(deployed *node.ZtWorld, desired *DesiredState, now time.Time) (*node.ZtWorld, error) {
+	conf := &MkWorldConfig{RootNodes: desired.RootNodes}
+	if len(conf.RootNodes) > node.ZT_WORLD_MAX_ROOTS {
+		return nil, node.ErrMaxRootsExceeded
+	}
+	nodes, err := conf.BuildRootNodes()
+	if err != nil {
+		return nil, err
+	}
+	w := &node.ZtWorld{
+		Type:                            deployed.Type,
+		ID:                              deployed.ID,
+		Timestamp:                       deployed.Timestamp,
+		PublicKeyMustBeSignedByNextTime: deployed.PublicKeyMustBeSignedByNextTime,
+		Nodes:                           nodes,
+		Dictionary:                      deployed.Dictionary,
+	}
+	if !DiffWorlds(deployed, w).RootsModified() {
+		return nil, nil
+	}
+	w.Timestamp = uint64(now.UnixMilli())
+	if w.Timestamp <= deployed.Timestamp {
+		w.Timestamp = deployed.Timestamp + 1
+	}
+	return w, nil
+}
+
+// SignReconciled signs a world returned by Reconcile so clients holding deployed accept it. signer
+// must be the next key of deployed, next becomes the key of the following update.
+func SignReconciled(deployed *node.ZtWorld, w *node.ZtWorld, signer *SigningKey, next *SigningKey) ([]byte, error) {
+	if signer.Public != deployed.PublicKeyMustBeSignedByNextTime {
+		return nil, ErrSignerNotInChain
+	}
+	return SignWorld(w, signer, next)
+}
+
+// VerifyDeployed checks the signature of deployed before its chain is extended. A world is signed
+// by the next key of its predecessor, which is its own next key unless the keys were rotated, in
+// which case it is one of keys.
+func VerifyDeployed(deployed *node.ZtWorld, keys ...*SigningKey) error {
+	if deployed.Verify(deployed.PublicKeyMustBeSignedByNextTime) == nil {
+		return nil
+	}
+	for _, k := range keys {
+		if k != nil && deployed.Verify(k.Public) == nil {
+			return nil
+		}
+	}
+	return ErrDeployedNotVerified
+}
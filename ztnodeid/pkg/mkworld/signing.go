@@ -0,0 +1,103 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package mkworld
+
+import (
+	"os"
+	"ztnodeid/pkg/home"
+	"ztnodeid/pkg/node"
+	"ztnodeid/pkg/ztcrypto"
+)
+
+// SigningKeyFilePerm is the permission of current.c25519 / previous.c25519
+const SigningKeyFilePerm os.FileMode = 0640
+
+// SigningKey is the content of current.c25519 / previous.c25519:
+// public key 64 bytes, then private key 64 bytes
+type SigningKey struct {
+	Public [node.ZT_C25519_PUBLIC_KEY_LEN]byte
+	priv   *ztcrypto.PrivateKey
+}
+
+// GenerateSigningKey creates a new world signing key pair
+func GenerateSigningKey() *SigningKey {
+	pub, priv := ztcrypto.GenerateDualPair()
+	k := &SigningKey{Public: pub, priv: ztcrypto.NewPrivateKey(priv[:])}
+	ztcrypto.Wipe(priv[:])
+	return k
+}
+
+// ParseSigningKey decodes a key pair in file format
+func ParseSigningKey(data []byte) (*SigningKey, error) {
+	if len(data) != node.ZT_C25519_PUBLIC_KEY_LEN+node.ZT_C25519_PRIVATE_KEY_LEN {
+		return nil, ErrWorldSigningKeyIllegal
+	}
+	k := &SigningKey{priv: ztcrypto.NewPrivateKey(data[node.ZT_C25519_PUBLIC_KEY_LEN:])}
+	copy(k.Public[:], data)
+	return k, nil
+}
+
+// LoadSigningKey reads a key pair file
+func LoadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer ztcrypto.Wipe(data)
+	return ParseSigningKey(data)
+}
+
+// Save writes the key pair file atomically
+func (k *SigningKey) Save(path string) error {
+	data := k.Bytes()
+	defer ztcrypto.Wipe(data)
+	return home.WriteFileAtomic(path, data, SigningKeyFilePerm)
+}
+
+// Bytes returns the key pair in file format, the caller should wipe it after use
+func (k *SigningKey) Bytes() []byte {
+	buf := make([]byte, 0, node.ZT_C25519_PUBLIC_KEY_LEN+node.ZT_C25519_PRIVATE_KEY_LEN)
+	buf = append(buf, k.Public[:]...)
+	priv := k.priv.Bytes()
+	buf = append(buf, priv...)
+	ztcrypto.Wipe(priv)
+	return buf
+}
+
+// Clone returns an independent copy of the key pair
+func (k *SigningKey) Clone() *SigningKey {
+	return &SigningKey{Public: k.Public, priv: k.priv.Clone()}
+}
+
+// Sign produces a 96-byte ZeroTier signature of msg
+func (k *SigningKey) Sign(msg []byte) ([node.ZT_C25519_SIGNATURE_LEN]byte, error) {
+	if !k.priv.IsSet() {
+		return [node.ZT_C25519_SIGNATURE_LEN]byte{}, node.ErrNoPrivateKey
+	}
+	var priv [node.ZT_C25519_PRIVATE_KEY_LEN]byte
+	b := k.priv.Bytes()
+	copy(priv[:], b)
+	ztcrypto.Wipe(b)
+	defer ztcrypto.Wipe(priv[:])
+	return ztcrypto.SignMessage(k.Public, priv, msg)
+}
+
+// SelectSigner returns the key whose public half is want, the next key of the world clients hold
+func SelectSigner(want [node.ZT_C25519_PUBLIC_KEY_LEN]byte, keys ...*SigningKey) (*SigningKey, error) {
+	for _, k := range keys {
+		if k != nil && k.Public == want {
+			return k, nil
+		}
+	}
+	return nil, ErrSignerNotInChain
+}
+
+// Zero wipes the private key, safe to call on nil
+func (k *SigningKey) Zero() {
+	if k != nil {
+		k.priv.Zero()
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package mkworld
+
+import (
+	secrand "crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+	"ztnodeid/pkg/home"
+	"ztnodeid/pkg/node"
+)
+
+// DefaultStateFile records the world ID and last timestamp between builds
+const DefaultStateFile = "mkworld.state.json"
+
+// maxWorldID keeps IDs exact in JavaScript, ztnet reads and writes plID as a JSON number
+const maxWorldID = 1<<53 - 1
+
+// WorldState is what must stay stable across builds for clients to accept updates: the world ID,
+// the last timestamp, which every following world must exceed, and the key the last world named to
+// sign the following one
+type WorldState struct {
+	ID        node.ZtWorldID `json:"id"`
+	Timestamp uint64         `json:"timestamp"`
+	// NextKey is the hex public key, empty until a world was recorded
+	NextKey string `json:"nextKey,omitempty"`
+}
+
+// IsReservedWorldID reports whether id must not be used for a custom world
+func IsReservedWorldID(id node.ZtWorldID) bool {
+	return id == 0 || id == node.ZT_WORLD_ID_EARTH || id == node.ZT_WORLD_ID_MARS
+}
+
+// RandomWorldID returns a random world ID that is not reserved
+func RandomWorldID() (node.ZtWorldID, error) {
+	var buf [8]byte
+	for {
+		if _, err := secrand.Read(buf[:]); err != nil {
+			return 0, err
+		}
+		id := binary.BigEndian.Uint64(buf[:]) & maxWorldID
+		if !IsReservedWorldID(id) {
+			return id, nil
+		}
+	}
+}
+
+// LoadWorldState reads a state file, a missing file yields an empty state
+func LoadWorldState(path string) (*WorldState, error) {
+	s := &WorldState{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save writes the state file
+func (s *WorldState) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return home.WriteFileAtomic(path, append(data, '\n'), home.PublicFilePerm)
+}
+
+// NextTimestamp returns want, or the smallest timestamp following the recorded one if want is not
+// newer
+func (s *WorldState) NextTimestamp(want uint64) uint64 {
+	if want <= s.Timestamp {
+		return s.Timestamp + 1
+	}
+	return want
+}
+
+// Apply makes the config keep the recorded world ID and a timestamp newer than the recorded one.
+// The birth becomes now when plRecommend is set. A config naming a different world ID is rejected,
+// an empty state leaves the config unchanged.
+func (s *WorldState) Apply(c *MkWorldConfig, now time.Time) error {
+	if s.ID == 0 {
+		return nil
+	}
+	switch {
+	case c.PlanetID == s.ID:
+	case c.PlanetRecommend || c.PlanetID == 0:
+		c.PlanetID = s.ID
+	default:
+		return fmt.Errorf("%w: state file has %d, config has %d", ErrWorldIDChanged, s.ID, c.PlanetID)
+	}
+	if c.PlanetRecommend {
+		c.PlanetBirth = uint64(now.UnixMilli())
+	}
+	c.PlanetBirth = s.NextTimestamp(c.PlanetBirth)
+	return nil
+}
+
+// Signer returns the key the next world must be signed by: the one matching the next key of the
+// last recorded world, or prev if none was recorded yet
+func (s *WorldState) Signer(prev *SigningKey, cur *SigningKey) (*SigningKey, error) {
+	if s.NextKey == "" {
+		return prev, nil
+	}
+	b, err := hex.DecodeString(s.NextKey)
+	if err != nil || len(b) != node.ZT_C25519_PUBLIC_KEY_LEN {
+		return nil, node.ErrInvalidData
+	}
+	var want [node.ZT_C25519_PUBLIC_KEY_LEN]byte
+	copy(want[:], b)
+	return SelectSigner(want, prev, cur)
+}
+
+// Record remembers the ID, timestamp and next key of a world that was built
+func (s *WorldState) Record(w *node.ZtWorld) {
+	s.ID = w.ID
+	s.NextKey = hex.EncodeToString(w.PublicKeyMustBeSignedByNextTime[:])
+	if w.Timestamp > s.Timestamp {
+		s.Timestamp = w.Timestamp
+	}
+}
@@ -0,0 +1,79 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package node
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	// ZT_ADDRESS_LENGTH is the length of a ZeroTier address in bytes
+	ZT_ADDRESS_LENGTH = 5
+	// ZT_ADDRESS_RESERVED_PREFIX is the first byte of addresses that are reserved and cannot be used
+	ZT_ADDRESS_RESERVED_PREFIX = 0xff
+)
+
+// ZtAddress is a 40-bit ZeroTier node address, only the least significant 40 bits are used
+type ZtAddress uint64
+
+// NewZtAddressFromBytes reads a big-endian 40-bit address from the first 5 bytes of b
+func NewZtAddressFromBytes(b []byte) (ZtAddress, error) {
+	if len(b) < ZT_ADDRESS_LENGTH {
+		return 0, ErrInvalidData
+	}
+	var a uint64
+	for _, v := range b[:ZT_ADDRESS_LENGTH] {
+		a = (a << 8) | uint64(v)
+	}
+	return ZtAddress(a), nil
+}
+
+// ParseZtAddress parses a 10-digit hex address as found in identity.public
+func ParseZtAddress(s string) (ZtAddress, error) {
+	if len(s) != ZT_ADDRESS_LENGTH*2 {
+		return 0, ErrInvalidData
+	}
+	a, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, err
+	}
+	return ZtAddress(a), nil
+}
+
+// Bytes returns the address in big-endian byte order
+func (a ZtAddress) Bytes() (b [ZT_ADDRESS_LENGTH]byte) {
+	for i := ZT_ADDRESS_LENGTH - 1; i >= 0; i-- {
+		b[i] = byte(a)
+		a >>= 8
+	}
+	return
+}
+
+// String returns the address as a 10-digit hex string
+func (a ZtAddress) String() string {
+	return fmt.Sprintf("%.10x", uint64(a)&0xffffffffff)
+}
+
+// IsReserved returns true for the null address and addresses with the reserved prefix
+func (a ZtAddress) IsReserved() bool {
+	return a == 0 || (a>>32)&0xff == ZT_ADDRESS_RESERVED_PREFIX
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (a ZtAddress) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (a *ZtAddress) UnmarshalText(text []byte) error {
+	v, err := ParseZtAddress(string(text))
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
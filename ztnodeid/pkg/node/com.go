@@ -0,0 +1,286 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package node
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+const (
+	// ZT_NETWORK_COM_MAX_QUALIFIERS is the most qualifiers a certificate of membership may carry
+	ZT_NETWORK_COM_MAX_QUALIFIERS = 8
+	// ZT_NETWORK_COM_QUALIFIER_LENGTH is ID, value and max delta, 8 bytes each
+	ZT_NETWORK_COM_QUALIFIER_LENGTH = 24
+	// ZT_NETWORK_COM_MAX_SERIALIZED_LENGTH is version, qualifier count, qualifiers, signer and signature
+	ZT_NETWORK_COM_MAX_SERIALIZED_LENGTH = 1 + 2 + ZT_NETWORK_COM_MAX_QUALIFIERS*ZT_NETWORK_COM_QUALIFIER_LENGTH + ZT_ADDRESS_LENGTH + ZT_C25519_SIGNATURE_LEN
+	// ZT_NETWORKCONFIG_DEFAULT_CREDENTIAL_TIME_MAX_MAX_DELTA is the timestamp max delta controllers
+	// put in the certificates they issue, in milliseconds
+	ZT_NETWORKCONFIG_DEFAULT_CREDENTIAL_TIME_MAX_MAX_DELTA = 7200000
+)
+
+// reserved qualifier IDs, IDs 3 to 6 hold the first 32 bytes of the SHA-384 of the public key
+// the certificate was issued to
+const (
+	COM_RESERVED_ID_TIMESTAMP  uint64 = 0
+	COM_RESERVED_ID_NETWORK_ID uint64 = 1
+	COM_RESERVED_ID_ISSUED_TO  uint64 = 2
+	COM_RESERVED_ID_KEY_HASH   uint64 = 3
+	comKeyHashQualifiers              = 4
+)
+
+// ComQualifier is one tuple of a certificate of membership. Two certificates agree on it when
+// their values differ by at most MaxDelta.
+type ComQualifier struct {
+	ID       uint64 `json:"id"`
+	Value    uint64 `json:"value"`
+	MaxDelta uint64 `json:"maxDelta"`
+}
+
+// CertificateOfMembership is the credential a network controller issues to each member. Members
+// exchange them and only talk to peers whose certificate agrees with their own.
+type CertificateOfMembership struct {
+	// Qualifiers are sorted by ID
+	Qualifiers []ComQualifier `json:"qualifiers"`
+	// SignedBy is zero for an unsigned certificate
+	SignedBy  ZtAddress                     `json:"signedBy"`
+	Signature [ZT_C25519_SIGNATURE_LEN]byte `json:"-"`
+}
+
+// NewCertificateOfMembership builds the unsigned certificate a controller issues to issuedTo for
+// nwid, valid while the timestamps of two members differ by at most timestampMaxDelta
+func NewCertificateOfMembership(timestamp uint64, timestampMaxDelta uint64, nwid NetworkID, issuedTo *ZtIdentity) *CertificateOfMembership {
+	com := &CertificateOfMembership{
+		Qualifiers: []ComQualifier{
+			{ID: COM_RESERVED_ID_TIMESTAMP, Value: timestamp, MaxDelta: timestampMaxDelta},
+			{ID: COM_RESERVED_ID_NETWORK_ID, Value: uint64(nwid), MaxDelta: 0},
+			{ID: COM_RESERVED_ID_ISSUED_TO, Value: uint64(issuedTo.Address), MaxDelta: 0xffffffffffffffff},
+		},
+	}
+	hash := issuedTo.PublicKeyHash()
+	for i := 0; i < comKeyHashQualifiers; i++ {
+		com.Qualifiers = append(com.Qualifiers, ComQualifier{
+			ID:       COM_RESERVED_ID_KEY_HASH + uint64(i),
+			Value:    binary.BigEndian.Uint64(hash[i*8:]),
+			MaxDelta: 0xffffffffffffffff,
+		})
+	}
+	return com
+}
+
+// Qualifier returns the value of the qualifier with the given ID
+func (com *CertificateOfMembership) Qualifier(id uint64) (uint64, bool) {
+	for _, q := range com.Qualifiers {
+		if q.ID == id {
+			return q.Value, true
+		}
+	}
+	return 0, false
+}
+
+// SetQualifier adds or replaces a qualifier, keeping them sorted, and drops the signature
+func (com *CertificateOfMembership) SetQualifier(q ComQualifier) error {
+	com.SignedBy = 0
+	com.Signature = [ZT_C25519_SIGNATURE_LEN]byte{}
+	i := sort.Search(len(com.Qualifiers), func(i int) bool { return com.Qualifiers[i].ID >= q.ID })
+	if i < len(com.Qualifiers) && com.Qualifiers[i].ID == q.ID {
+		com.Qualifiers[i] = q
+		return nil
+	}
+	if len(com.Qualifiers) >= ZT_NETWORK_COM_MAX_QUALIFIERS {
+		return ErrMaxQualifiersExceeded
+	}
+	com.Qualifiers = append(com.Qualifiers, ComQualifier{})
+	copy(com.Qualifiers[i+1:], com.Qualifiers[i:])
+	com.Qualifiers[i] = q
+	return nil
+}
+
+// Timestamp returns the revision timestamp the controller issued the certificate at
+func (com *CertificateOfMembership) Timestamp() uint64 {
+	v, _ := com.Qualifier(COM_RESERVED_ID_TIMESTAMP)
+	return v
+}
+
+// NetworkID returns the network the certificate is for
+func (com *CertificateOfMembership) NetworkID() NetworkID {
+	v, _ := com.Qualifier(COM_RESERVED_ID_NETWORK_ID)
+	return NetworkID(v)
+}
+
+// IssuedTo returns the address of the member the certificate was issued to
+func (com *CertificateOfMembership) IssuedTo() ZtAddress {
+	v, _ := com.Qualifier(COM_RESERVED_ID_ISSUED_TO)
+	return ZtAddress(v)
+}
+
+// IsSigned reports whether the certificate carries a signature, see Verify to check it
+func (com *CertificateOfMembership) IsSigned() bool {
+	return com.SignedBy != 0
+}
+
+// signedData returns the qualifiers as big-endian ID, value and max delta triples
+func (com *CertificateOfMembership) signedData() []byte {
+	buf := make([]byte, 0, len(com.Qualifiers)*ZT_NETWORK_COM_QUALIFIER_LENGTH)
+	for _, q := range com.Qualifiers {
+		buf = binary.BigEndian.AppendUint64(buf, q.ID)
+		buf = binary.BigEndian.AppendUint64(buf, q.Value)
+		buf = binary.BigEndian.AppendUint64(buf, q.MaxDelta)
+	}
+	return buf
+}
+
+// checkQualifiers checks the count limit and the order ZeroTier expects
+func (com *CertificateOfMembership) checkQualifiers() error {
+	if len(com.Qualifiers) > ZT_NETWORK_COM_MAX_QUALIFIERS {
+		return ErrMaxQualifiersExceeded
+	}
+	for i := 1; i < len(com.Qualifiers); i++ {
+		if com.Qualifiers[i].ID < com.Qualifiers[i-1].ID {
+			return ErrInvalidData
+		}
+	}
+	return nil
+}
+
+// Sign signs the qualifiers with the controller identity, which must hold a private key
+func (com *CertificateOfMembership) Sign(controller *ZtIdentity) error {
+	if err := com.checkQualifiers(); err != nil {
+		return err
+	}
+	sig, err := controller.Sign(com.signedData())
+	if err != nil {
+		return err
+	}
+	com.SignedBy = controller.Address
+	com.Signature = sig
+	return nil
+}
+
+// Verify checks the certificate was signed by controller and controller runs the network the
+// certificate is for
+func (com *CertificateOfMembership) Verify(controller *ZtIdentity) error {
+	if !com.IsSigned() || com.SignedBy != com.NetworkID().Controller() || com.SignedBy != controller.Address {
+		return ErrWrongSigner
+	}
+	if err := com.checkQualifiers(); err != nil {
+		return err
+	}
+	return controller.Verify(com.signedData(), com.Signature)
+}
+
+// AgreesWith reports whether a member holding com may talk to the member holding other, whose
+// identity is otherIdentity. Every qualifier of com must be present in other and lie within its
+// max delta. If com carries a public key hash, other must carry the hash of otherIdentity too.
+// Signatures are not checked, see Verify.
+func (com *CertificateOfMembership) AgreesWith(other *CertificateOfMembership, otherIdentity *ZtIdentity) bool {
+	if len(com.Qualifiers) == 0 || len(other.Qualifiers) == 0 {
+		return false
+	}
+	fullIdentityVerification := false
+	for _, q := range com.Qualifiers {
+		if q.ID >= COM_RESERVED_ID_KEY_HASH && q.ID < COM_RESERVED_ID_KEY_HASH+comKeyHashQualifiers {
+			fullIdentityVerification = true
+		}
+		v, ok := other.Qualifier(q.ID)
+		if !ok {
+			return false
+		}
+		delta := q.Value - v
+		if v > q.Value {
+			delta = v - q.Value
+		}
+		if delta > q.MaxDelta {
+			return false
+		}
+	}
+	if fullIdentityVerification {
+		hash := otherIdentity.PublicKeyHash()
+		for i := 0; i < comKeyHashQualifiers; i++ {
+			v, ok := other.Qualifier(COM_RESERVED_ID_KEY_HASH + uint64(i))
+			if !ok || v != binary.BigEndian.Uint64(hash[i*8:]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Serialize writes the binary form carried in network configs and NETWORK_CREDENTIALS packets:
+// version 1, the qualifier count, the qualifiers, the signer address and, if signed, the signature
+func (com *CertificateOfMembership) Serialize() ([]byte, error) {
+	if err := com.checkQualifiers(); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, ZT_NETWORK_COM_MAX_SERIALIZED_LENGTH)
+	buf = append(buf, 1)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(com.Qualifiers)))
+	buf = append(buf, com.signedData()...)
+	signedBy := com.SignedBy.Bytes()
+	buf = append(buf, signedBy[:]...)
+	if com.IsSigned() {
+		buf = append(buf, com.Signature[:]...)
+	}
+	return buf, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler
+func (com *CertificateOfMembership) MarshalBinary() ([]byte, error) {
+	return com.Serialize()
+}
+
+// Deserialize reads a certificate as written by Serialize from the start of b and returns the
+// number of bytes consumed
+func (com *CertificateOfMembership) Deserialize(b []byte) (int, error) {
+	p := 0
+	if len(b) < 3 || b[0] != 1 {
+		return 0, ErrInvalidData
+	}
+	p++
+	count := int(binary.BigEndian.Uint16(b[p:]))
+	p += 2
+	if count > ZT_NETWORK_COM_MAX_QUALIFIERS {
+		return 0, ErrMaxQualifiersExceeded
+	}
+	if len(b) < p+count*ZT_NETWORK_COM_QUALIFIER_LENGTH+ZT_ADDRESS_LENGTH {
+		return 0, ErrInvalidData
+	}
+	res := CertificateOfMembership{Qualifiers: make([]ComQualifier, 0, count)}
+	for i := 0; i < count; i++ {
+		res.Qualifiers = append(res.Qualifiers, ComQualifier{
+			ID:       binary.BigEndian.Uint64(b[p:]),
+			Value:    binary.BigEndian.Uint64(b[p+8:]),
+			MaxDelta: binary.BigEndian.Uint64(b[p+16:]),
+		})
+		p += ZT_NETWORK_COM_QUALIFIER_LENGTH
+	}
+	if err := res.checkQualifiers(); err != nil {
+		return 0, err
+	}
+	res.SignedBy, _ = NewZtAddressFromBytes(b[p:])
+	p += ZT_ADDRESS_LENGTH
+	if res.IsSigned() {
+		if len(b) < p+ZT_C25519_SIGNATURE_LEN {
+			return 0, ErrInvalidData
+		}
+		copy(res.Signature[:], b[p:])
+		p += ZT_C25519_SIGNATURE_LEN
+	}
+	*com = res
+	return p, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, data must hold exactly one certificate
+func (com *CertificateOfMembership) UnmarshalBinary(data []byte) error {
+	n, err := com.Deserialize(data)
+	if err != nil {
+		return err
+	}
+	if n != len(data) {
+		return ErrInvalidData
+	}
+	return nil
+}
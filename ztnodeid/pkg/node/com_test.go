@@ -0,0 +1,182 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package node
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+const testComTimestamp = 1700000000000
+
+var (
+	testComOnce                  sync.Once
+	testController, testA, testB *ZtIdentity
+	testNetworkID                NetworkID
+	errTestNetworkID             error
+)
+
+// comTestIdentities generates the controller and two members once, identities take a while
+func comTestIdentities(t *testing.T) (controller, a, b *ZtIdentity, nwid NetworkID) {
+	t.Helper()
+	testComOnce.Do(func() {
+		testController, testA, testB = GenerateZtIdentity(), GenerateZtIdentity(), GenerateZtIdentity()
+		testNetworkID, errTestNetworkID = NewNetworkID(testController.Address, 0x123456)
+	})
+	if errTestNetworkID != nil {
+		t.Fatal(errTestNetworkID)
+	}
+	return testController, testA, testB, testNetworkID
+}
+
+func signedCom(t *testing.T, timestamp uint64, nwid NetworkID, issuedTo *ZtIdentity, signer *ZtIdentity) *CertificateOfMembership {
+	t.Helper()
+	com := NewCertificateOfMembership(timestamp, ZT_NETWORKCONFIG_DEFAULT_CREDENTIAL_TIME_MAX_MAX_DELTA, nwid, issuedTo)
+	if err := com.Sign(signer); err != nil {
+		t.Fatal(err)
+	}
+	return com
+}
+
+func TestComSignVerify(t *testing.T) {
+	controller, a, b, nwid := comTestIdentities(t)
+	com := signedCom(t, testComTimestamp, nwid, a, controller)
+	if !com.IsSigned() || com.SignedBy != controller.Address {
+		t.Fatalf("signed by %s, want %s", com.SignedBy, controller.Address)
+	}
+	if com.NetworkID() != nwid || com.IssuedTo() != a.Address || com.Timestamp() != testComTimestamp {
+		t.Errorf("qualifiers %v do not match the issued certificate", com.Qualifiers)
+	}
+	if err := com.Verify(controller); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if err := com.Verify(b); !errors.Is(err, ErrWrongSigner) {
+		t.Errorf("Verify with another identity: %v, want ErrWrongSigner", err)
+	}
+	// signed by a member, not the controller of the network
+	forged := signedCom(t, testComTimestamp, nwid, a, b)
+	if err := forged.Verify(b); !errors.Is(err, ErrWrongSigner) {
+		t.Errorf("Verify of a certificate not signed by the controller: %v, want ErrWrongSigner", err)
+	}
+	unsigned := NewCertificateOfMembership(testComTimestamp, 0, nwid, a)
+	if err := unsigned.Verify(controller); !errors.Is(err, ErrWrongSigner) {
+		t.Errorf("Verify of an unsigned certificate: %v, want ErrWrongSigner", err)
+	}
+}
+
+func TestComRoundTrip(t *testing.T) {
+	controller, a, _, nwid := comTestIdentities(t)
+	com := signedCom(t, testComTimestamp, nwid, a, controller)
+	data, err := com.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 1 + 2 + len(com.Qualifiers)*ZT_NETWORK_COM_QUALIFIER_LENGTH + ZT_ADDRESS_LENGTH + ZT_C25519_SIGNATURE_LEN; len(data) != want {
+		t.Errorf("serialized %d bytes, want %d", len(data), want)
+	}
+	got := &CertificateOfMembership{}
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, com) {
+		t.Errorf("round trip changed the certificate:\n got %+v\nwant %+v", got, com)
+	}
+	if err := got.Verify(controller); err != nil {
+		t.Errorf("Verify after round trip: %v", err)
+	}
+	if err := got.UnmarshalBinary(append(data, 0)); !errors.Is(err, ErrInvalidData) {
+		t.Errorf("trailing byte: %v, want ErrInvalidData", err)
+	}
+	if _, err := got.Deserialize(data[:len(data)-1]); !errors.Is(err, ErrInvalidData) {
+		t.Errorf("truncated signature: %v, want ErrInvalidData", err)
+	}
+
+	unsigned := NewCertificateOfMembership(testComTimestamp, 0, nwid, a)
+	data, err = unsigned.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = &CertificateOfMembership{}
+	if n, err := got.Deserialize(data); err != nil || n != len(data) {
+		t.Fatalf("unsigned: read %d of %d bytes, %v", n, len(data), err)
+	}
+	if !reflect.DeepEqual(got, unsigned) {
+		t.Errorf("unsigned round trip changed the certificate:\n got %+v\nwant %+v", got, unsigned)
+	}
+}
+
+func TestComAgreesWith(t *testing.T) {
+	controller, a, b, nwid := comTestIdentities(t)
+	comA := signedCom(t, testComTimestamp, nwid, a, controller)
+	comB := signedCom(t, testComTimestamp+ZT_NETWORKCONFIG_DEFAULT_CREDENTIAL_TIME_MAX_MAX_DELTA, nwid, b, controller)
+	if !comA.AgreesWith(comB, b) || !comB.AgreesWith(comA, a) {
+		t.Error("certificates within the timestamp max delta do not agree")
+	}
+	stale := signedCom(t, testComTimestamp+ZT_NETWORKCONFIG_DEFAULT_CREDENTIAL_TIME_MAX_MAX_DELTA+1, nwid, b, controller)
+	if comA.AgreesWith(stale, b) {
+		t.Error("certificates beyond the timestamp max delta agree")
+	}
+	otherNetwork := signedCom(t, testComTimestamp, nwid+1, b, controller)
+	if comA.AgreesWith(otherNetwork, b) {
+		t.Error("certificates for different networks agree")
+	}
+	if comA.AgreesWith(&CertificateOfMembership{}, b) {
+		t.Error("agrees with an empty certificate")
+	}
+}
+
+func TestComTamperedQualifier(t *testing.T) {
+	controller, a, _, nwid := comTestIdentities(t)
+	com := signedCom(t, testComTimestamp, nwid, a, controller)
+	// change the value without SetQualifier, which would drop the signature
+	com.Qualifiers[0].Value++
+	if err := com.Verify(controller); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify of a changed timestamp: %v, want ErrInvalidSignature", err)
+	}
+
+	com = signedCom(t, testComTimestamp, nwid, a, controller)
+	data, err := com.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// last byte of the max delta of the timestamp qualifier
+	data[1+2+ZT_NETWORK_COM_QUALIFIER_LENGTH-1] ^= 1
+	got := &CertificateOfMembership{}
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := got.Verify(controller); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify of a tampered serialized certificate: %v, want ErrInvalidSignature", err)
+	}
+
+	if err := com.SetQualifier(ComQualifier{ID: COM_RESERVED_ID_ISSUED_TO, Value: uint64(controller.Address), MaxDelta: 0xffffffffffffffff}); err != nil {
+		t.Fatal(err)
+	}
+	if com.IsSigned() {
+		t.Error("SetQualifier kept the signature")
+	}
+}
+
+func TestComWrongKeyHash(t *testing.T) {
+	controller, a, b, nwid := comTestIdentities(t)
+	comA := signedCom(t, testComTimestamp, nwid, a, controller)
+	comB := signedCom(t, testComTimestamp, nwid, b, controller)
+	// comB carries the key hash of b, not of the identity presenting it
+	if comA.AgreesWith(comB, a) {
+		t.Error("agrees with a certificate issued to another identity")
+	}
+
+	forged := signedCom(t, testComTimestamp, nwid, b, controller)
+	forged.Qualifiers[COM_RESERVED_ID_KEY_HASH].Value ^= 1
+	if comA.AgreesWith(forged, b) {
+		t.Error("agrees with a certificate carrying a wrong key hash")
+	}
+	if err := forged.Verify(controller); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify of a changed key hash: %v, want ErrInvalidSignature", err)
+	}
+}
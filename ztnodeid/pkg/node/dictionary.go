@@ -0,0 +1,247 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package node
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Code reproduced from https://github.com/zerotier/ZeroTierOne/blob/e0a3291235230352148d5d30e51b341bfd9ad458/node/Dictionary.hpp
+
+// Dictionary is a ZeroTier key/value dictionary: key=value lines separated by \n, with \0, \r,
+// \n, \\ and = escaped in values. Like ZeroTier it is kept in encoded form, so a decoded dictionary
+// serializes to the same bytes and signatures over it stay valid. Add appends, Get returns the
+// first entry with a key.
+type Dictionary struct {
+	data []byte
+}
+
+// NewDictionary wraps an encoded dictionary, it ends at the first NUL byte like in ZeroTier
+func NewDictionary(encoded []byte) *Dictionary {
+	if i := bytes.IndexByte(encoded, 0); i >= 0 {
+		encoded = encoded[:i]
+	}
+	return &Dictionary{data: bytes.Clone(encoded)}
+}
+
+// Bytes returns the encoded dictionary
+func (d *Dictionary) Bytes() []byte {
+	return d.data
+}
+
+// Len returns the length of the encoded dictionary
+func (d *Dictionary) Len() int {
+	return len(d.data)
+}
+
+// entries calls fn with the key and raw value of every line holding a =, until fn returns false.
+// Parsing ends at a NUL byte.
+func (d *Dictionary) entries(fn func(key []byte, rawValue []byte) bool) {
+	data := d.data
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	for _, line := range bytes.FieldsFunc(data, func(r rune) bool { return r == '\n' || r == '\r' }) {
+		k, v, ok := bytes.Cut(line, []byte{'='})
+		if ok && !fn(k, v) {
+			return
+		}
+	}
+}
+
+// Get returns the unescaped value of the first entry with key
+func (d *Dictionary) Get(key string) (value []byte, found bool) {
+	d.entries(func(k []byte, v []byte) bool {
+		if string(k) != key {
+			return true
+		}
+		value, found = unescapeDictionaryValue(v), true
+		return false
+	})
+	return
+}
+
+// GetString returns the value of key as a string
+func (d *Dictionary) GetString(key string) (string, bool) {
+	v, ok := d.Get(key)
+	return string(v), ok
+}
+
+// GetBool returns true if the value of key starts with 1, t, T, y or Y, or dfl if key is missing
+func (d *Dictionary) GetBool(key string, dfl bool) bool {
+	v, ok := d.Get(key)
+	if !ok || len(v) == 0 {
+		return dfl
+	}
+	return strings.IndexByte("1tTyY", v[0]) >= 0
+}
+
+// GetUint64 returns the hex value of key, or dfl if key is missing or not hex
+func (d *Dictionary) GetUint64(key string, dfl uint64) uint64 {
+	v, ok := d.Get(key)
+	if !ok {
+		return dfl
+	}
+	n, err := strconv.ParseUint(string(v), 16, 64)
+	if err != nil {
+		return dfl
+	}
+	return n
+}
+
+// GetInt64 returns the hex value of key, negative values carry a leading -, or dfl if key is
+// missing or not hex
+func (d *Dictionary) GetInt64(key string, dfl int64) int64 {
+	v, ok := d.Get(key)
+	if !ok {
+		return dfl
+	}
+	s := string(v)
+	neg := strings.HasPrefix(s, "-")
+	n, err := strconv.ParseUint(strings.TrimPrefix(s, "-"), 16, 64)
+	if err != nil {
+		return dfl
+	}
+	if neg {
+		return -int64(n)
+	}
+	return int64(n)
+}
+
+// GetAddress returns the hex address value of key, or dfl if key is missing or not hex
+func (d *Dictionary) GetAddress(key string, dfl ZtAddress) ZtAddress {
+	return ZtAddress(d.GetUint64(key, uint64(dfl)) & 0xffffffffff)
+}
+
+// Keys returns the key of every entry in order, repeated keys are listed each time
+func (d *Dictionary) Keys() []string {
+	var keys []string
+	d.entries(func(k []byte, _ []byte) bool {
+		keys = append(keys, string(k))
+		return true
+	})
+	return keys
+}
+
+// Map returns every entry as a string, the first entry of a repeated key wins
+func (d *Dictionary) Map() map[string]string {
+	m := make(map[string]string)
+	d.entries(func(k []byte, v []byte) bool {
+		if _, ok := m[string(k)]; !ok {
+			m[string(k)] = string(unescapeDictionaryValue(v))
+		}
+		return true
+	})
+	return m
+}
+
+// Add appends an entry, value may be binary. Keys must be non-empty and may not hold \0, \r, \n
+// or =.
+func (d *Dictionary) Add(key string, value []byte) error {
+	if key == "" || strings.ContainsAny(key, "\x00\r\n=") {
+		return ErrInvalidDictionaryKey
+	}
+	if len(d.data) > 0 {
+		d.data = append(d.data, '\n')
+	}
+	d.data = append(d.data, key...)
+	d.data = append(d.data, '=')
+	for _, c := range value {
+		switch c {
+		case 0:
+			d.data = append(d.data, '\\', '0')
+		case '\r':
+			d.data = append(d.data, '\\', 'r')
+		case '\n':
+			d.data = append(d.data, '\\', 'n')
+		case '\\':
+			d.data = append(d.data, '\\', '\\')
+		case '=':
+			d.data = append(d.data, '\\', 'e')
+		default:
+			d.data = append(d.data, c)
+		}
+	}
+	return nil
+}
+
+// AddString appends a string entry
+func (d *Dictionary) AddString(key string, value string) error {
+	return d.Add(key, []byte(value))
+}
+
+// AddBool appends 1 or 0
+func (d *Dictionary) AddBool(key string, value bool) error {
+	if value {
+		return d.AddString(key, "1")
+	}
+	return d.AddString(key, "0")
+}
+
+// AddUint64 appends value as 16 hex digits
+func (d *Dictionary) AddUint64(key string, value uint64) error {
+	return d.AddString(key, fmt.Sprintf("%.16x", value))
+}
+
+// AddInt64 appends value as 16 hex digits, with a leading - if negative
+func (d *Dictionary) AddInt64(key string, value int64) error {
+	if value < 0 {
+		return d.AddString(key, fmt.Sprintf("-%.16x", uint64(-value)))
+	}
+	return d.AddUint64(key, uint64(value))
+}
+
+// AddAddress appends addr as 10 hex digits
+func (d *Dictionary) AddAddress(key string, addr ZtAddress) error {
+	return d.AddString(key, addr.String())
+}
+
+// Erase removes every entry with key
+func (d *Dictionary) Erase(key string) {
+	kept := make([]byte, 0, len(d.data))
+	for _, line := range bytes.FieldsFunc(d.data, func(r rune) bool { return r == '\n' || r == '\r' }) {
+		if k, _, ok := bytes.Cut(line, []byte{'='}); ok && string(k) == key {
+			continue
+		}
+		if len(kept) > 0 {
+			kept = append(kept, '\n')
+		}
+		kept = append(kept, line...)
+	}
+	d.data = kept
+}
+
+// unescapeDictionaryValue reverses the escaping of Add, unknown escapes yield the escaped byte and
+// a trailing backslash is dropped
+func unescapeDictionaryValue(raw []byte) []byte {
+	v := make([]byte, 0, len(raw))
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		if c == '\\' {
+			i++
+			if i == len(raw) {
+				break
+			}
+			switch raw[i] {
+			case '0':
+				c = 0
+			case 'r':
+				c = '\r'
+			case 'n':
+				c = '\n'
+			case 'e':
+				c = '='
+			default:
+				c = raw[i]
+			}
+		}
+		v = append(v, c)
+	}
+	return v
+}
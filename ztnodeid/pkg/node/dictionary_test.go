@@ -0,0 +1,169 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package node
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDictionaryBinaryRoundTrip(t *testing.T) {
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	values := [][]byte{
+		[]byte("\x00\r\n\\="),
+		[]byte("\\0\\r\\n\\e\\\\"),
+		[]byte("=\x00=\n\n\\"),
+		all,
+		{},
+	}
+	d := &Dictionary{}
+	for i, v := range values {
+		if err := d.Add(string(rune('a'+i)), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.AddString("last", "x"); err != nil {
+		t.Fatal(err)
+	}
+	// only the separators between entries may be raw
+	if got := bytes.Count(d.Bytes(), []byte{'\n'}); got != len(values) {
+		t.Errorf("encoded dictionary holds %d newlines, want %d", got, len(values))
+	}
+	if bytes.ContainsAny(d.Bytes(), "\x00\r") {
+		t.Error("encoded dictionary holds a raw NUL or carriage return")
+	}
+
+	decoded := NewDictionary(append(bytes.Clone(d.Bytes()), 0, 'z', '=', '1'))
+	for i, v := range values {
+		key := string(rune('a' + i))
+		for _, dict := range []*Dictionary{d, decoded} {
+			got, ok := dict.Get(key)
+			if !ok || !bytes.Equal(got, v) {
+				t.Errorf("%s: got %q %v, want %q", key, got, ok, v)
+			}
+		}
+	}
+	if s, ok := decoded.GetString("last"); !ok || s != "x" {
+		t.Errorf("entry after binary values: %q %v", s, ok)
+	}
+	if _, ok := decoded.Get("z"); ok {
+		t.Error("entry after the NUL terminator was read")
+	}
+
+	for _, key := range []string{"", "a=b", "a\nb", "a\rb", "a\x00b"} {
+		if err := d.Add(key, nil); !errors.Is(err, ErrInvalidDictionaryKey) {
+			t.Errorf("key %q: %v, want ErrInvalidDictionaryKey", key, err)
+		}
+	}
+}
+
+// testNetconfController issued the certificate in testNetconf to member cbadd4c05c
+const testNetconfController = "65640b8ad5:0:1b51d66329c017e13645eb770c47c9b8edc56954434aecb7657101abf04fe97878074a88c9d3a1883d71da25c8e6ebcda9550d489f401759f5726ad32797cbec"
+
+// testNetconf is a network config laid out the way zerotier-one's NetworkConfig::toDictionary
+// writes it: a signed certificate, a route through the ZeroTier interface (nil via), a default
+// route via 10.147.17.1 with metric 5, and an IPv4 and an RFC 4193 static IP. The binary values
+// were escaped outside this package, not with Dictionary.Add.
+const testNetconf = "" +
+	"v=0000000000000007\n" +
+	"nwid=65640b8ad5000001\n" +
+	"ts=0000018bcfe56800\n" +
+	"r=0000000000000003\n" +
+	"id=000000cbadd4c05c\n" +
+	"f=0000000000000006\n" +
+	"ml=0000000000000020\n" +
+	"t=0000000000000000\n" +
+	"n=home-lab\n" +
+	"mtu=0000000000000af0\n" +
+	"ctmd=00000000006ddd00\n" +
+	"C=\x01\\0\x07\\0\\0\\0\\0\\0\\0\\0\\0\\0\\0\x01\x8b\xcf\xe5h\\0\\0\\0\\0\\0\\0m\xdd\\0" +
+	"\\0\\0\\0\\0\\0\\0\\0\x01ed\x0b\x8a\xd5\\0\\0\x01\\0\\0\\0\\0\\0\\0\\0\\0\\0\\0\\0\\0\\0" +
+	"\\0\\0\x02\\0\\0\\0\xcb\xad\xd4\xc0\\\\\xff\xff\xff\xff\xff\xff\xff\xff\\0\\0\\0\\0\\0" +
+	"\\0\\0\x03\xb7W\x95\xe8A\xa0\xb3Z\xff\xff\xff\xff\xff\xff\xff\xff\\0\\0\\0\\0\\0\\0\\0" +
+	"\x04\\e\x0f1\x83\xda\xf3\x9f\xd9\xff\xff\xff\xff\xff\xff\xff\xff\\0\\0\\0\\0\\0\\0\\0" +
+	"\x05\x0e\xee\x02\xa7\x0bN\xe3\x86\xff\xff\xff\xff\xff\xff\xff\xff\\0\\0\\0\\0\\0\\0\\0" +
+	"\x06.\x0c\xfa2\xab\xc4\xf5\xf5\xff\xff\xff\xff\xff\xff\xff\xffed\x0b\x8a\xd5\\n\x09]\x9a" +
+	"\xd7\xef\x99#\xbc-\x15\xaa\xfcy\x12A\xd1\x1a|?\xd5_\xab\x86\x80D\xdc\xc3R\xe9\x98\x94" +
+	"\x8d\x8a\xfb$N\xae\xfa\xea\x11Z\xd3\x88w\xb4\x14\xaf\xc3a\xe3\xf7BKT\x92\xb9\\e\xec\xd9" +
+	"\x93K\xe9\x02]\xac\xe3W~\xff\xa6\xc8\xc4\xc8,\x8b\\n\xb6f\x89$[\x9dW\xe4\x9d\x95\x9a\x87" +
+	"\xf8}\xd8\x89\xb7/5\n" +
+	"RT=\x04\\n\x93\x11\\0\\0\x18\\0\\0\\0\\0\\0\x04\\0\\0\\0\\0\\0\\0\x04\\n\x93\x11\x01\\0" +
+	"\\0\\0\\0\\0\x05\n" +
+	"I=\x04\\n\x93\x11\x05\\0\x18\x06\xfded\x0b\x8a\xd5\\0\\0\x01\x99\x93\xcb\xad\xd4\xc0\\\\" +
+	"\\0X"
+
+func TestNetworkConfigFromControllerDictionary(t *testing.T) {
+	controller, err := ParseZtIdentity(testNetconfController)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc, err := NewNetworkConfigFromDictionary(NewDictionary([]byte(testNetconf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nc.Version != ZT_NETWORKCONFIG_VERSION || nc.NetworkID != 0x65640b8ad5000001 || nc.Timestamp != 0x18bcfe56800 ||
+		nc.Revision != 3 || nc.IssuedTo != 0xcbadd4c05c || nc.MulticastLimit != 32 || nc.Type != ZT_NETWORK_TYPE_PRIVATE ||
+		nc.Name != "home-lab" || nc.MTU != 2800 || nc.CredentialTimeMaxDelta != ZT_NETWORKCONFIG_DEFAULT_CREDENTIAL_TIME_MAX_MAX_DELTA {
+		t.Errorf("decoded %+v", nc)
+	}
+	if nc.Flags != ZT_NETWORKCONFIG_FLAG_ENABLE_BROADCAST|ZT_NETWORKCONFIG_FLAG_ENABLE_IPV6_NDP_EMULATION {
+		t.Errorf("flags %x", nc.Flags)
+	}
+
+	if nc.COM == nil {
+		t.Fatal("no certificate of membership")
+	}
+	if err := nc.COM.Verify(controller); err != nil {
+		t.Errorf("certificate does not verify: %v", err)
+	}
+	if nc.COM.NetworkID() != nc.NetworkID || nc.COM.IssuedTo() != nc.IssuedTo || nc.COM.Timestamp() != nc.Timestamp {
+		t.Errorf("certificate qualifiers %v do not match the config", nc.COM.Qualifiers)
+	}
+
+	wantRoutes := []struct {
+		target string
+		via    string
+		metric uint16
+	}{
+		{"10.147.17.0/24", "", 0},
+		{"0.0.0.0/0", "10.147.17.1/0", 5},
+	}
+	if len(nc.Routes) != len(wantRoutes) {
+		t.Fatalf("got %d routes, want %d", len(nc.Routes), len(wantRoutes))
+	}
+	for i, want := range wantRoutes {
+		r := nc.Routes[i]
+		if r.Target.String() != want.target || r.Via.String() != want.via || r.Flags != 0 || r.Metric != want.metric {
+			t.Errorf("route %d: %s via %q flags %d metric %d, want %s via %q metric %d", i, r.Target, r.Via.String(), r.Flags, r.Metric, want.target, want.via, want.metric)
+		}
+	}
+	if nc.Routes[0].Via != nil {
+		t.Error("route through the ZeroTier interface has a via address")
+	}
+
+	wantIPs := []string{"10.147.17.5/24", "fd65:640b:8ad5:0:199:93cb:add4:c05c/88"}
+	if len(nc.StaticIPs) != len(wantIPs) {
+		t.Fatalf("got %d static IPs, want %d", len(nc.StaticIPs), len(wantIPs))
+	}
+	for i, want := range wantIPs {
+		if got := nc.StaticIPs[i].String(); got != want {
+			t.Errorf("static IP %d: %s, want %s", i, got, want)
+		}
+	}
+
+	// nothing is lost on the way back, the nil via is written as a single zero byte
+	d, err := nc.ToDictionary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(d.Bytes()) != testNetconf {
+		t.Errorf("ToDictionary wrote\n%q\nwant\n%q", d.Bytes(), testNetconf)
+	}
+}
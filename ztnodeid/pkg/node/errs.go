@@ -8,9 +8,17 @@ package node
 import "errors"
 
 var (
-	ErrMaxEndpointsExceeded   = errors.New("zerotier node has too many endpoints")
-	ErrMaxRootsExceeded       = errors.New("zerotier root exceeds limits")
-	ErrSerializedDataTooLarge = errors.New("serialized data longer than restriction")
-	ErrInvalidData            = errors.New("data input invalid")
-	ErrUnknown                = errors.New("unknown error")
+	ErrMaxEndpointsExceeded    = errors.New("zerotier node has too many endpoints")
+	ErrMaxRootsExceeded        = errors.New("zerotier root exceeds limits")
+	ErrSerializedDataTooLarge  = errors.New("serialized data longer than restriction")
+	ErrInvalidData             = errors.New("data input invalid")
+	ErrNoPrivateKey            = errors.New("identity does not contain a private key")
+	ErrInvalidSignature        = errors.New("signature verification failed")
+	ErrInvalidIdentity         = errors.New("identity failed local validation")
+	ErrUnsupportedIdentityType = errors.New("identity type is not supported")
+	ErrInvalidNetworkID        = errors.New("network ID invalid or its controller address reserved")
+	ErrMaxQualifiersExceeded   = errors.New("certificate of membership has too many qualifiers")
+	ErrWrongSigner             = errors.New("certificate not signed by the network controller")
+	ErrInvalidDictionaryKey    = errors.New("dictionary key empty or holds a reserved character")
+	ErrUnknown                 = errors.New("unknown error")
 )
@@ -39,78 +39,73 @@ package node
  * code for deriveAddress() for this algorithm.)
  */
 
-import (
-	"fmt"
-	"ztnodeid/pkg/ztcrypto"
-)
+import "fmt"
 
 const ztIdentityHashCashFirstByteLessThan = 17
 
 // ZeroTierIdentity contains a public key, a private key, and a string representation of the identity.
+// It is kept as a compatible wrapper around ZtIdentity.
 type ZeroTierIdentity struct {
-	address    uint64 // ZeroTier address, only least significant 40 bits are used
-	publicKey  [64]byte
-	privateKey *[64]byte
+	id ZtIdentity
 }
 
 // NewZeroTierIdentity creates a new ZeroTier Identity.
 // This can be a little bit time-consuming due to one way proof of work requirements (usually a few hundred milliseconds).
 func NewZeroTierIdentity() (id ZeroTierIdentity) {
-	for {
-		pub, priv := ztcrypto.GenerateDualPair()
-		dig := ztcrypto.ComputeZeroTierIdentityMemoryHardHash(pub[:])
-		if dig[0] < ztIdentityHashCashFirstByteLessThan && dig[59] != 0xff {
-			id.address = uint64(dig[59])
-			id.address <<= 8
-			id.address |= uint64(dig[60])
-			id.address <<= 8
-			id.address |= uint64(dig[61])
-			id.address <<= 8
-			id.address |= uint64(dig[62])
-			id.address <<= 8
-			id.address |= uint64(dig[63])
-			if id.address != 0 {
-				id.publicKey = pub
-				id.privateKey = &priv
-				break
-			}
-		}
-	}
-	return
+	return GenerateZtIdentity().ToZeroTierIdentity()
 }
 
 // PrivateKeyString returns the full identity.secret if the private key is set, or an empty string if no private key is set.
 func (id *ZeroTierIdentity) PrivateKeyString() string {
-	if id.privateKey != nil {
-		s := fmt.Sprintf("%.10x:0:%x:%x", id.address, id.publicKey, *id.privateKey)
-		return s
-	}
-	return ""
+	return id.id.PrivateKeyString()
 }
 
 // PublicKeyString returns identity.public contents.
 func (id *ZeroTierIdentity) PublicKeyString() string {
-	s := fmt.Sprintf("%.10x:0:%x", id.address, id.publicKey)
-	return s
+	return id.id.PublicKeyString()
 }
 
 // IDString returns the NodeID as a 10-digit hex string
 func (id *ZeroTierIdentity) IDString() string {
-	s := fmt.Sprintf("%.10x", id.address)
-	return s
+	return id.id.Address.String()
 }
 
 // ID returns the ZeroTier address as a uint64
 func (id *ZeroTierIdentity) ID() uint64 {
-	return id.address
+	return uint64(id.id.Address)
 }
 
-// PrivateKey returns the bytes of the private key (or nil if not set)
+// PrivateKey returns a copy of the private key bytes (or nil if not set)
 func (id *ZeroTierIdentity) PrivateKey() *[64]byte {
-	return id.privateKey
+	return id.id.PrivateKey()
 }
 
 // PublicKey returns the public key bytes
 func (id *ZeroTierIdentity) PublicKey() [64]byte {
-	return id.publicKey
+	return id.id.PublicKey
+}
+
+// Sign signs msg with the identity's private key, producing a 96-byte ZeroTier signature.
+func (id *ZeroTierIdentity) Sign(msg []byte) ([ZT_C25519_SIGNATURE_LEN]byte, error) {
+	return id.id.Sign(msg)
+}
+
+// Verify checks a 96-byte ZeroTier signature of msg against the identity's public key.
+func (id *ZeroTierIdentity) Verify(msg []byte, sig [ZT_C25519_SIGNATURE_LEN]byte) error {
+	return id.id.Verify(msg, sig)
+}
+
+// DestroyPrivateKey wipes the private key from memory
+func (id *ZeroTierIdentity) DestroyPrivateKey() {
+	id.id.DestroyPrivateKey()
+}
+
+// Format implements fmt.Formatter so %v and friends never print the private key
+func (id ZeroTierIdentity) Format(f fmt.State, verb rune) {
+	_, _ = f.Write([]byte(id.id.PublicKeyString()))
+}
+
+// ToIdentity converts to the unified ZtIdentity representation
+func (id *ZeroTierIdentity) ToIdentity() *ZtIdentity {
+	return id.id.clone()
 }
@@ -0,0 +1,233 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package node
+
+import "encoding/binary"
+
+// Code reproduced from https://github.com/zerotier/ZeroTierOne/blob/e0a3291235230352148d5d30e51b341bfd9ad458/node/NetworkConfig.hpp
+
+const (
+	// ZT_NETWORKCONFIG_VERSION is the config version controllers write
+	ZT_NETWORKCONFIG_VERSION = 7
+	// ZT_MAX_NETWORK_ROUTES is the most managed routes a config may carry
+	ZT_MAX_NETWORK_ROUTES = 128
+	// ZT_MAX_ZT_ASSIGNED_ADDRESSES is the most static IPs a config may assign
+	ZT_MAX_ZT_ASSIGNED_ADDRESSES = 32
+	// ZT_MAX_NETWORK_SPECIALISTS is the most specialists (anchors, relays) a config may list
+	ZT_MAX_NETWORK_SPECIALISTS = 256
+)
+
+const (
+	ZT_NETWORKCONFIG_DICT_KEY_VERSION                   = "v"
+	ZT_NETWORKCONFIG_DICT_KEY_NETWORK_ID                = "nwid"
+	ZT_NETWORKCONFIG_DICT_KEY_TIMESTAMP                 = "ts"
+	ZT_NETWORKCONFIG_DICT_KEY_REVISION                  = "r"
+	ZT_NETWORKCONFIG_DICT_KEY_ISSUED_TO                 = "id"
+	ZT_NETWORKCONFIG_DICT_KEY_FLAGS                     = "f"
+	ZT_NETWORKCONFIG_DICT_KEY_MULTICAST_LIMIT           = "ml"
+	ZT_NETWORKCONFIG_DICT_KEY_TYPE                      = "t"
+	ZT_NETWORKCONFIG_DICT_KEY_NAME                      = "n"
+	ZT_NETWORKCONFIG_DICT_KEY_MTU                       = "mtu"
+	ZT_NETWORKCONFIG_DICT_KEY_CREDENTIAL_TIME_MAX_DELTA = "ctmd"
+	ZT_NETWORKCONFIG_DICT_KEY_COM                       = "C"
+	ZT_NETWORKCONFIG_DICT_KEY_SPECIALISTS               = "S"
+	ZT_NETWORKCONFIG_DICT_KEY_ROUTES                    = "RT"
+	ZT_NETWORKCONFIG_DICT_KEY_STATIC_IPS                = "I"
+	ZT_NETWORKCONFIG_DICT_KEY_RULES                     = "R"
+	ZT_NETWORKCONFIG_DICT_KEY_CAPABILITIES              = "CAP"
+	ZT_NETWORKCONFIG_DICT_KEY_TAGS                      = "TAG"
+	ZT_NETWORKCONFIG_DICT_KEY_CERTIFICATES_OF_OWNERSHIP = "COO"
+	ZT_NETWORKCONFIG_DICT_KEY_DNS                       = "DNS"
+)
+
+const (
+	ZT_NETWORKCONFIG_FLAG_ENABLE_BROADCAST                  = 0x0000000000000002
+	ZT_NETWORKCONFIG_FLAG_ENABLE_IPV6_NDP_EMULATION         = 0x0000000000000004
+	ZT_NETWORKCONFIG_FLAG_RULES_RESULT_OF_UNSUPPORTED_MATCH = 0x0000000000000008
+	ZT_NETWORKCONFIG_FLAG_DISABLE_COMPRESSION               = 0x0000000000000010
+)
+
+const (
+	ZT_NETWORK_TYPE_PRIVATE uint8 = 0
+	ZT_NETWORK_TYPE_PUBLIC  uint8 = 1
+)
+
+// NetworkRoute is a managed route pushed by the controller, a nil Via routes through the
+// ZeroTier interface itself. The port of Target holds the prefix length.
+type NetworkRoute struct {
+	Target *ZtNodeInetAddr
+	Via    *ZtNodeInetAddr
+	Flags  uint16
+	Metric uint16
+}
+
+// NetworkConfig is the part of a controller's network config dictionary this package decodes.
+// Rules, capabilities, tags, certificates of ownership and DNS stay in Dictionary.
+type NetworkConfig struct {
+	Version                uint64
+	NetworkID              NetworkID
+	Timestamp              uint64
+	CredentialTimeMaxDelta uint64
+	Revision               uint64
+	IssuedTo               ZtAddress
+	Flags                  uint64
+	MulticastLimit         uint64
+	Type                   uint8
+	Name                   string
+	MTU                    uint64
+	// Specialists are 40-bit addresses with role flags in the upper bits
+	Specialists []uint64
+	Routes      []NetworkRoute
+	// StaticIPs are the managed addresses of the member, the port holds the prefix length
+	StaticIPs []*ZtNodeInetAddr
+	COM       *CertificateOfMembership
+	// Dictionary is the dictionary the config was decoded from, nil for a config built in Go
+	Dictionary *Dictionary
+}
+
+// NewNetworkConfigFromDictionary decodes the fields of a network config dictionary
+func NewNetworkConfigFromDictionary(d *Dictionary) (*NetworkConfig, error) {
+	nc := &NetworkConfig{
+		Version:                d.GetUint64(ZT_NETWORKCONFIG_DICT_KEY_VERSION, 0),
+		NetworkID:              NetworkID(d.GetUint64(ZT_NETWORKCONFIG_DICT_KEY_NETWORK_ID, 0)),
+		Timestamp:              d.GetUint64(ZT_NETWORKCONFIG_DICT_KEY_TIMESTAMP, 0),
+		CredentialTimeMaxDelta: d.GetUint64(ZT_NETWORKCONFIG_DICT_KEY_CREDENTIAL_TIME_MAX_DELTA, 0),
+		Revision:               d.GetUint64(ZT_NETWORKCONFIG_DICT_KEY_REVISION, 0),
+		IssuedTo:               d.GetAddress(ZT_NETWORKCONFIG_DICT_KEY_ISSUED_TO, 0),
+		Flags:                  d.GetUint64(ZT_NETWORKCONFIG_DICT_KEY_FLAGS, 0),
+		MulticastLimit:         d.GetUint64(ZT_NETWORKCONFIG_DICT_KEY_MULTICAST_LIMIT, 0),
+		Type:                   uint8(d.GetUint64(ZT_NETWORKCONFIG_DICT_KEY_TYPE, uint64(ZT_NETWORK_TYPE_PRIVATE))),
+		MTU:                    d.GetUint64(ZT_NETWORKCONFIG_DICT_KEY_MTU, 0),
+		Dictionary:             d,
+	}
+	nc.Name, _ = d.GetString(ZT_NETWORKCONFIG_DICT_KEY_NAME)
+	if nc.NetworkID == 0 {
+		return nil, ErrInvalidNetworkID
+	}
+	if b, ok := d.Get(ZT_NETWORKCONFIG_DICT_KEY_COM); ok && len(b) > 0 {
+		nc.COM = &CertificateOfMembership{}
+		if err := nc.COM.UnmarshalBinary(b); err != nil {
+			return nil, err
+		}
+	}
+	if b, ok := d.Get(ZT_NETWORKCONFIG_DICT_KEY_SPECIALISTS); ok {
+		if len(b)%8 != 0 || len(b)/8 > ZT_MAX_NETWORK_SPECIALISTS {
+			return nil, ErrInvalidData
+		}
+		for p := 0; p < len(b); p += 8 {
+			nc.Specialists = append(nc.Specialists, binary.BigEndian.Uint64(b[p:]))
+		}
+	}
+	if b, ok := d.Get(ZT_NETWORKCONFIG_DICT_KEY_STATIC_IPS); ok {
+		for p := 0; p < len(b); {
+			ip := &ZtNodeInetAddr{}
+			n, err := ip.Deserialize(b[p:])
+			if err != nil {
+				return nil, err
+			}
+			p += n
+			nc.StaticIPs = append(nc.StaticIPs, ip)
+		}
+		if len(nc.StaticIPs) > ZT_MAX_ZT_ASSIGNED_ADDRESSES {
+			return nil, ErrInvalidData
+		}
+	}
+	if b, ok := d.Get(ZT_NETWORKCONFIG_DICT_KEY_ROUTES); ok {
+		for p := 0; p < len(b); {
+			r := NetworkRoute{Target: &ZtNodeInetAddr{}, Via: &ZtNodeInetAddr{}}
+			n, err := r.Target.Deserialize(b[p:])
+			if err != nil {
+				return nil, err
+			}
+			p += n
+			n, err = r.Via.Deserialize(b[p:])
+			if err != nil {
+				return nil, err
+			}
+			p += n
+			if len(b) < p+4 {
+				return nil, ErrInvalidData
+			}
+			r.Flags = binary.BigEndian.Uint16(b[p:])
+			r.Metric = binary.BigEndian.Uint16(b[p+2:])
+			p += 4
+			if r.Via.IP == nil {
+				r.Via = nil
+			}
+			nc.Routes = append(nc.Routes, r)
+		}
+		if len(nc.Routes) > ZT_MAX_NETWORK_ROUTES {
+			return nil, ErrInvalidData
+		}
+	}
+	return nc, nil
+}
+
+// ToDictionary encodes the config the way controllers do. Keys this package does not decode are
+// not written, a config that needs rules or credentials must add them to the result.
+func (nc *NetworkConfig) ToDictionary() (*Dictionary, error) {
+	if len(nc.Specialists) > ZT_MAX_NETWORK_SPECIALISTS || len(nc.Routes) > ZT_MAX_NETWORK_ROUTES || len(nc.StaticIPs) > ZT_MAX_ZT_ASSIGNED_ADDRESSES {
+		return nil, ErrInvalidData
+	}
+	d := &Dictionary{}
+	version := nc.Version
+	if version == 0 {
+		version = ZT_NETWORKCONFIG_VERSION
+	}
+	// keys are valid constants, Add can not fail on them
+	d.AddUint64(ZT_NETWORKCONFIG_DICT_KEY_VERSION, version)
+	d.AddUint64(ZT_NETWORKCONFIG_DICT_KEY_NETWORK_ID, uint64(nc.NetworkID))
+	d.AddUint64(ZT_NETWORKCONFIG_DICT_KEY_TIMESTAMP, nc.Timestamp)
+	d.AddUint64(ZT_NETWORKCONFIG_DICT_KEY_REVISION, nc.Revision)
+	d.AddUint64(ZT_NETWORKCONFIG_DICT_KEY_ISSUED_TO, uint64(nc.IssuedTo))
+	d.AddUint64(ZT_NETWORKCONFIG_DICT_KEY_FLAGS, nc.Flags)
+	d.AddUint64(ZT_NETWORKCONFIG_DICT_KEY_MULTICAST_LIMIT, nc.MulticastLimit)
+	d.AddUint64(ZT_NETWORKCONFIG_DICT_KEY_TYPE, uint64(nc.Type))
+	d.AddString(ZT_NETWORKCONFIG_DICT_KEY_NAME, nc.Name)
+	d.AddUint64(ZT_NETWORKCONFIG_DICT_KEY_MTU, nc.MTU)
+	d.AddUint64(ZT_NETWORKCONFIG_DICT_KEY_CREDENTIAL_TIME_MAX_DELTA, nc.CredentialTimeMaxDelta)
+	if nc.COM != nil {
+		b, err := nc.COM.Serialize()
+		if err != nil {
+			return nil, err
+		}
+		d.Add(ZT_NETWORKCONFIG_DICT_KEY_COM, b)
+	}
+	if len(nc.Specialists) > 0 {
+		b := make([]byte, 0, len(nc.Specialists)*8)
+		for _, s := range nc.Specialists {
+			b = binary.BigEndian.AppendUint64(b, s)
+		}
+		d.Add(ZT_NETWORKCONFIG_DICT_KEY_SPECIALISTS, b)
+	}
+	if len(nc.Routes) > 0 {
+		var b []byte
+		for _, r := range nc.Routes {
+			for _, a := range []*ZtNodeInetAddr{r.Target, r.Via} {
+				ab, err := a.Serialize()
+				if err != nil {
+					return nil, err
+				}
+				b = append(b, ab...)
+			}
+			b = binary.BigEndian.AppendUint16(b, r.Flags)
+			b = binary.BigEndian.AppendUint16(b, r.Metric)
+		}
+		d.Add(ZT_NETWORKCONFIG_DICT_KEY_ROUTES, b)
+	}
+	if len(nc.StaticIPs) > 0 {
+		var b []byte
+		for _, ip := range nc.StaticIPs {
+			ab, err := ip.Serialize()
+			if err != nil {
+				return nil, err
+			}
+			b = append(b, ab...)
+		}
+		d.Add(ZT_NETWORKCONFIG_DICT_KEY_STATIC_IPS, b)
+	}
+	return d, nil
+}
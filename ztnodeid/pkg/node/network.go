@@ -0,0 +1,131 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package node
+
+import (
+	secrand "crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// ZT_NETWORK_ID_LENGTH is the length of a network ID in bytes
+	ZT_NETWORK_ID_LENGTH = 8
+	// ZT_NETWORK_NUMBER_MAX is the largest network number a controller can assign
+	ZT_NETWORK_NUMBER_MAX = 0xffffff
+)
+
+// NetworkID is a 64-bit ZeroTier network ID: the 40-bit address of the controller followed by a
+// 24-bit network number the controller picks
+type NetworkID uint64
+
+// NewNetworkID composes the ID of network number on controller
+func NewNetworkID(controller ZtAddress, number uint32) (NetworkID, error) {
+	if controller.IsReserved() || uint64(controller) > 0xffffffffff {
+		return 0, ErrInvalidNetworkID
+	}
+	if number > ZT_NETWORK_NUMBER_MAX {
+		return 0, ErrInvalidNetworkID
+	}
+	return NetworkID(uint64(controller)<<24 | uint64(number)), nil
+}
+
+// NewNetworkIDFromBytes reads a big-endian network ID from the first 8 bytes of b
+func NewNetworkIDFromBytes(b []byte) (NetworkID, error) {
+	if len(b) < ZT_NETWORK_ID_LENGTH {
+		return 0, ErrInvalidData
+	}
+	return NetworkID(binary.BigEndian.Uint64(b)), nil
+}
+
+// ParseNetworkID parses a 16-digit hex network ID and validates it
+func ParseNetworkID(s string) (NetworkID, error) {
+	if len(s) != ZT_NETWORK_ID_LENGTH*2 {
+		return 0, ErrInvalidNetworkID
+	}
+	v, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, ErrInvalidNetworkID
+	}
+	n := NetworkID(v)
+	if err := n.Validate(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// RandomNetworkID returns the ID of a random network number on controller, as the controller
+// does for new networks
+func RandomNetworkID(controller *ZtIdentity) (NetworkID, error) {
+	var buf [4]byte
+	if _, err := secrand.Read(buf[:]); err != nil {
+		return 0, err
+	}
+	return NewNetworkID(controller.Address, binary.BigEndian.Uint32(buf[:])&ZT_NETWORK_NUMBER_MAX)
+}
+
+// DeriveNetworkID returns the ID of the network number derived from the controller public key and
+// name, so provisioning the same name twice yields the same network. Distinct names collide with a
+// chance of 1 in 2^24 per pair, callers must check the ID is not taken yet.
+func DeriveNetworkID(controller *ZtIdentity, name string) (NetworkID, error) {
+	h := sha512.New()
+	h.Write([]byte(controller.PublicKeyString()))
+	h.Write([]byte{0})
+	h.Write([]byte(name))
+	digest := h.Sum(nil)
+	return NewNetworkID(controller.Address, binary.BigEndian.Uint32(digest)&ZT_NETWORK_NUMBER_MAX)
+}
+
+// Controller returns the address of the controller issuing the network's configuration
+func (n NetworkID) Controller() ZtAddress {
+	return ZtAddress(uint64(n) >> 24)
+}
+
+// Number returns the network number on the controller
+func (n NetworkID) Number() uint32 {
+	return uint32(uint64(n) & ZT_NETWORK_NUMBER_MAX)
+}
+
+// IsControlledBy reports whether the network belongs to the controller at addr
+func (n NetworkID) IsControlledBy(addr ZtAddress) bool {
+	return n.Controller() == addr
+}
+
+// Validate checks the controller part is a usable address
+func (n NetworkID) Validate() error {
+	if n.Controller().IsReserved() {
+		return ErrInvalidNetworkID
+	}
+	return nil
+}
+
+// Bytes returns the network ID in big-endian byte order
+func (n NetworkID) Bytes() (b [ZT_NETWORK_ID_LENGTH]byte) {
+	binary.BigEndian.PutUint64(b[:], uint64(n))
+	return
+}
+
+// String returns the network ID as a 16-digit hex string
+func (n NetworkID) String() string {
+	return fmt.Sprintf("%.16x", uint64(n))
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (n NetworkID) MarshalText() ([]byte, error) {
+	return []byte(n.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (n *NetworkID) UnmarshalText(text []byte) error {
+	v, err := ParseNetworkID(string(text))
+	if err != nil {
+		return err
+	}
+	*n = v
+	return nil
+}
@@ -0,0 +1,124 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewNetworkID(t *testing.T) {
+	tests := []struct {
+		controller ZtAddress
+		number     uint32
+		want       string
+	}{
+		// network IDs in ztnet's fixtures
+		{0x8056c2e21c, 0x000001, "8056c2e21c000001"},
+		{0xc8b4f1202b, 0x9e9b66, "c8b4f1202b9e9b66"},
+		{0x0123456789, 0, "0123456789000000"},
+		{0x0123456789, ZT_NETWORK_NUMBER_MAX, "0123456789ffffff"},
+	}
+	for _, tt := range tests {
+		n, err := NewNetworkID(tt.controller, tt.number)
+		if err != nil {
+			t.Fatalf("%s/%x: %v", tt.controller, tt.number, err)
+		}
+		if n.String() != tt.want {
+			t.Errorf("%s/%x: got %s, want %s", tt.controller, tt.number, n, tt.want)
+		}
+		// ztnet asks a controller for <address>______ and it fills in the number
+		if prefix := tt.controller.String(); !strings.HasPrefix(n.String(), prefix) || n.Controller() != tt.controller || n.Number() != tt.number {
+			t.Errorf("%s: controller %s number %x, want %s %x", n, n.Controller(), n.Number(), prefix, tt.number)
+		}
+	}
+
+	invalid := []struct {
+		name       string
+		controller ZtAddress
+		number     uint32
+	}{
+		{"null controller", 0, 1},
+		{"reserved controller", 0xff01020304, 1},
+		{"controller over 40 bits", 0x10123456789, 1},
+		{"number over 24 bits", 0x8056c2e21c, ZT_NETWORK_NUMBER_MAX + 1},
+		{"largest number", 0x8056c2e21c, 0xffffffff},
+	}
+	for _, tt := range invalid {
+		if n, err := NewNetworkID(tt.controller, tt.number); !errors.Is(err, ErrInvalidNetworkID) {
+			t.Errorf("%s: got %s, %v, want ErrInvalidNetworkID", tt.name, n, err)
+		}
+	}
+}
+
+func TestParseNetworkID(t *testing.T) {
+	for _, s := range []string{"8056c2e21c000001", "c8b4f1202b9e9b66", "0123456789ffffff"} {
+		n, err := ParseNetworkID(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if n.String() != s {
+			t.Errorf("%s: round trip gave %s", s, n)
+		}
+	}
+	if n, err := ParseNetworkID("C8B4F1202B9E9B66"); err != nil || n != 0xc8b4f1202b9e9b66 {
+		t.Errorf("upper case: %s, %v", n, err)
+	}
+
+	for _, s := range []string{
+		"",
+		"8056c2e21c00001",   // 15 digits
+		"8056c2e21c0000001", // 17 digits
+		"8056c2e21c00000g",
+		"0x56c2e21c000001",
+		"+056c2e21c000001",
+		"0000000000000001", // null controller
+		"ff01020304000001", // reserved controller
+	} {
+		if n, err := ParseNetworkID(s); !errors.Is(err, ErrInvalidNetworkID) {
+			t.Errorf("%q: got %s, %v, want ErrInvalidNetworkID", s, n, err)
+		}
+	}
+
+	var v struct{ ID NetworkID }
+	if err := json.Unmarshal([]byte(`{"ID":"8056c2e21c000001"}`), &v); err != nil || v.ID != 0x8056c2e21c000001 {
+		t.Errorf("UnmarshalText: %s, %v", v.ID, err)
+	}
+	if err := json.Unmarshal([]byte(`{"ID":"8056c2e21c"}`), &v); !errors.Is(err, ErrInvalidNetworkID) {
+		t.Errorf("UnmarshalText of a controller address: %v, want ErrInvalidNetworkID", err)
+	}
+}
+
+func TestDeriveNetworkID(t *testing.T) {
+	controller, err := ParseZtIdentity(earthRootIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first 3 bytes of SHA-512 over the public identity, a NUL and the name, after the
+	// controller address
+	for name, want := range map[string]string{"home-lab": "992fcf1db7da8bb6", "office": "992fcf1db766275b"} {
+		for i := 0; i < 2; i++ {
+			n, err := DeriveNetworkID(controller, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n.String() != want {
+				t.Errorf("%s: got %s, want %s", name, n, want)
+			}
+		}
+	}
+
+	other := GenerateZtIdentity()
+	n, err := DeriveNetworkID(other, "home-lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !n.IsControlledBy(other.Address) {
+		t.Errorf("%s is not controlled by %s", n, other.Address)
+	}
+}
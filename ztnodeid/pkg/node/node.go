@@ -7,9 +7,8 @@ package node
 
 import (
 	"bytes"
-	"encoding/hex"
 	"fmt"
-	"strings"
+	"ztnodeid/pkg/ztcrypto"
 )
 
 const (
@@ -18,57 +17,113 @@ const (
 	ZT_C25519_SIGNATURE_LEN   = 96
 )
 
+// ZtNormalNode is the identity of a world root. The private key is held by value, so every copy
+// owns its key and DestroyPrivateKey only wipes the copy it is called on.
 type ZtNormalNode struct {
 	ZtNodeAddress [5]byte // but only use big-endian high 40 bits
 	PublicKey     [ZT_C25519_PUBLIC_KEY_LEN]byte
-	privateKey    [ZT_C25519_PRIVATE_KEY_LEN]byte
+	// privateKey holds the compound private key in its first privateKeyLen bytes
+	privateKey    [ZT_IDENTITY_P384_COMPOUND_PRIVATE_KEY_LEN]byte
+	privateKeyLen int
+	p384          *ztP384Keys // only set for type 1 identities, never modified
 }
 
-// HasPrivateKey return true if first 4 bytes are all null
+// HasPrivateKey return true if the node holds a private key
 func (ztn ZtNormalNode) HasPrivateKey() bool {
-	return !bytes.Equal(ztn.privateKey[0:4], []byte{0, 0, 0, 0})
+	return ztn.privateKeyLen > 0
 }
 
-// ExposePrivateKey returns internal private key
+// ExposePrivateKey returns a copy of the C25519 private key, or nil if not set. The caller should
+// wipe it with ztcrypto.Wipe after use.
 func (ztn ZtNormalNode) ExposePrivateKey() []byte {
-	return ztn.privateKey[:]
+	if !ztn.HasPrivateKey() {
+		return nil
+	}
+	return bytes.Clone(ztn.privateKey[:ZT_C25519_PRIVATE_KEY_LEN])
+}
+
+// DestroyPrivateKey wipes the private key from memory, the node becomes public only
+func (ztn *ZtNormalNode) DestroyPrivateKey() {
+	ztcrypto.Wipe(ztn.privateKey[:])
+	ztn.privateKeyLen = 0
 }
 
 // FromString import node identity using "identity.public", to use content of "identity.secret" with private key
 // imported at the same time, set hasPrivateKey to true
 func (ztn *ZtNormalNode) FromString(data string, hasPrivateKey bool) (err error) {
-	tmpDt := strings.Split(data, ":")
-	if len(tmpDt) != 3 && hasPrivateKey {
-		return ErrInvalidData
+	id, err := ParseZtIdentity(data)
+	if err != nil {
+		return err
 	}
-	if len(tmpDt) < 2 {
+	if hasPrivateKey && !id.HasPrivateKey() {
 		return ErrInvalidData
 	}
-	if hasPrivateKey {
-		tmpPrivk, err := hex.DecodeString(tmpDt[2])
-		if err != nil {
-			return err
-		}
-		copy(ztn.privateKey[:], tmpPrivk)
+	defer id.DestroyPrivateKey()
+	if !hasPrivateKey {
+		*ztn = id.PublicOnly().ToNormalNode()
+		return nil
 	}
-	tmpAddr, err := hex.DecodeString(tmpDt[0])
-	if err != nil {
-		return err
+	*ztn = id.ToNormalNode()
+	return nil
+}
+
+// ToIdentity converts to the unified ZtIdentity representation, the identity gets its own copy of
+// the private key
+func (ztn ZtNormalNode) ToIdentity() *ZtIdentity {
+	addr, _ := NewZtAddressFromBytes(ztn.ZtNodeAddress[:])
+	id := &ZtIdentity{
+		Address:   addr,
+		Type:      ZT_IDENTITY_TYPE_C25519,
+		PublicKey: ztn.PublicKey,
+		p384:      ztn.p384,
 	}
-	copy(ztn.ZtNodeAddress[:], tmpAddr)
-	tmpPubk, err := hex.DecodeString(tmpDt[2])
-	if err != nil {
-		return err
+	if ztn.p384 != nil {
+		id.Type = ZT_IDENTITY_TYPE_P384
 	}
-	copy(ztn.PublicKey[:], tmpPubk)
-	return nil
+	if ztn.HasPrivateKey() {
+		id.privateKey = ztcrypto.NewPrivateKey(ztn.privateKey[:ztn.privateKeyLen])
+	}
+	return id
+}
+
+// Sign signs msg with the node private key, producing a 96-byte ZeroTier signature
+func (ztn ZtNormalNode) Sign(msg []byte) ([ZT_C25519_SIGNATURE_LEN]byte, error) {
+	id := ztn.ToIdentity()
+	defer id.DestroyPrivateKey()
+	return id.Sign(msg)
+}
+
+// Verify checks a 96-byte ZeroTier signature of msg against the node public key
+func (ztn ZtNormalNode) Verify(msg []byte, sig [ZT_C25519_SIGNATURE_LEN]byte) error {
+	return ztn.publicIdentity().Verify(msg, sig)
+}
+
+// LocallyValidate checks the node address is derived from its public key, see ZtIdentity.LocallyValidate
+func (ztn ZtNormalNode) LocallyValidate() error {
+	id := ztn.ToIdentity()
+	defer id.DestroyPrivateKey()
+	return id.LocallyValidate()
+}
+
+// publicIdentity converts to ZtIdentity without copying the private key
+func (ztn ZtNormalNode) publicIdentity() *ZtIdentity {
+	ztn.privateKeyLen = 0
+	return ztn.ToIdentity()
 }
 
 func (ztn ZtNormalNode) ToString(exportPrivateKey bool) string {
-	// the second field, its value 0 indicates Curve25519/Ed25519 identity type
-	pub := fmt.Sprintf("%02x:0:%02x", ztn.ZtNodeAddress, ztn.PublicKey)
-	if exportPrivateKey && ztn.HasPrivateKey() {
-		return pub + fmt.Sprintf(":%02x", ztn.privateKey)
+	if !exportPrivateKey {
+		return ztn.publicIdentity().PublicKeyString()
 	}
-	return pub
+	id := ztn.ToIdentity()
+	defer id.DestroyPrivateKey()
+	if id.HasPrivateKey() {
+		return id.PrivateKeyString()
+	}
+	return id.PublicKeyString()
+}
+
+// Format implements fmt.Formatter so %v and friends never print the private key
+func (ztn ZtNormalNode) Format(f fmt.State, verb rune) {
+	_, _ = f.Write([]byte(ztn.ToString(false)))
 }
@@ -0,0 +1,66 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package node
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNormalNodeCopiesOwnTheirKey(t *testing.T) {
+	id := GenerateZtIdentity()
+	ztn := id.ToNormalNode()
+	id.DestroyPrivateKey()
+	if !ztn.HasPrivateKey() {
+		t.Fatal("destroying the identity wiped the node converted from it")
+	}
+
+	nodes := []ZtNormalNode{ztn}
+	cp := ztn
+	cp.DestroyPrivateKey()
+	if cp.HasPrivateKey() || cp.ExposePrivateKey() != nil {
+		t.Error("DestroyPrivateKey left the key in place")
+	}
+	for _, n := range nodes {
+		n.DestroyPrivateKey()
+	}
+	msg := []byte("still signing")
+	sig, err := ztn.Sign(msg)
+	if err != nil {
+		t.Fatalf("destroying copies wiped the original: %v", err)
+	}
+	if err := nodes[0].Verify(msg, sig); err != nil {
+		t.Error(err)
+	}
+	if err := ztn.LocallyValidate(); err != nil {
+		t.Error(err)
+	}
+
+	back := ztn.ToIdentity()
+	back.DestroyPrivateKey()
+	if !ztn.HasPrivateKey() {
+		t.Error("destroying the identity from ToIdentity wiped the node")
+	}
+}
+
+func TestNormalNodeNeverPrintsPrivateKey(t *testing.T) {
+	ztn := GenerateZtIdentity().ToNormalNode()
+	secret := ztn.ToString(true)
+	priv := secret[strings.LastIndexByte(secret, ':')+1:]
+	for _, s := range []string{fmt.Sprint(ztn), fmt.Sprintf("%+v", ztn), fmt.Sprintf("%#v", ztn), ztn.ToString(false)} {
+		if strings.Contains(s, priv) {
+			t.Errorf("private key printed: %s", s)
+		}
+	}
+	var parsed ZtNormalNode
+	if err := parsed.FromString(secret, true); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.ToString(true) != secret {
+		t.Errorf("identity.secret round trip gave %s", parsed.ToString(true))
+	}
+}
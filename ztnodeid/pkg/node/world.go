@@ -12,6 +12,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"ztnodeid/pkg/ztcrypto"
 )
 
 // Code reproduced from https://github.com/zerotier/ZeroTierOne/blob/e0a3291235230352148d5d30e51b341bfd9ad458/node/World.hpp
@@ -57,6 +58,10 @@ type ZtWorld struct {
 	Timestamp                       uint64
 	PublicKeyMustBeSignedByNextTime [ZT_C25519_PUBLIC_KEY_LEN]byte
 	Nodes                           []*ZtWorldPlanetNode
+	// Dictionary is attached to moons only, it is covered by the signature
+	Dictionary Dictionary
+	// Signature is only filled in when the world is deserialized, Serialize takes it as an argument
+	Signature [ZT_C25519_SIGNATURE_LEN]byte
 }
 
 type ZtNodeInetAddr struct {
@@ -65,7 +70,7 @@ type ZtNodeInetAddr struct {
 }
 
 func (a *ZtNodeInetAddr) Family() int {
-	if a == nil || len(*a.IP) <= net.IPv4len {
+	if a == nil || a.IP == nil || len(*a.IP) <= net.IPv4len {
 		return syscall.AF_INET
 	}
 	if a.IP.To4() != nil {
@@ -94,8 +99,20 @@ func (a *ZtNodeInetAddr) FromString(ipport string) error {
 	return nil
 }
 
+// String returns the address in the <IPADDR>/<PORT> format FromString accepts
+func (a *ZtNodeInetAddr) String() string {
+	if a == nil || a.IP == nil {
+		return ""
+	}
+	return a.IP.String() + "/" + strconv.FormatUint(uint64(a.Port), 10)
+}
+
 func (ztniaddr *ZtNodeInetAddr) Serialize() ([]byte, error) {
 	var buf = make([]byte, 0)
+	// nil address is written as a single zero type byte
+	if ztniaddr == nil || ztniaddr.IP == nil {
+		return []byte{0}, nil
+	}
 	switch ztniaddr.Family() {
 	case syscall.AF_INET:
 		buf = append(buf, (uint8)(4))
@@ -122,17 +139,12 @@ func (ztniaddr *ZtNodeInetAddr) Serialize() ([]byte, error) {
 type ZtWorldPlanetNodeIdentity = ZtNormalNode
 
 func (ztpnid ZtWorldPlanetNodeIdentity) Serialize(inclPrivKey bool) ([]byte, error) {
-	var buf = make([]byte, 0)
-	buf = append(buf, ztpnid.ZtNodeAddress[:]...)
-	buf = append(buf, 0)
-	buf = append(buf, ztpnid.PublicKey[:]...)
-	if ztpnid.HasPrivateKey() && inclPrivKey {
-		buf = append(buf, ZT_C25519_PRIVATE_KEY_LEN)
-		buf = append(buf, ztpnid.privateKey[:]...)
-	} else {
-		buf = append(buf, 0)
+	if !inclPrivKey {
+		return ztpnid.publicIdentity().Serialize(false)
 	}
-	return buf, nil
+	id := ztpnid.ToIdentity()
+	defer id.DestroyPrivateKey()
+	return id.Serialize(true)
 }
 
 type ZtWorldPlanetNode struct {
@@ -191,8 +203,13 @@ func (ztw ZtWorld) Serialize(forSign bool, c25519sig [ZT_C25519_SIGNATURE_LEN]by
 		buf = append(buf, nBytes...)
 	}
 	if ztw.Type == ZT_WORLD_TYPE_MOON {
-		// official comments: no attached dictionary (for future use)
-		buf = binary.BigEndian.AppendUint16(buf, 0)
+		// official comments: attached dictionary (for future use)
+		dict := ztw.Dictionary.Bytes()
+		if len(dict) > 0xffff {
+			return nil, ErrSerializedDataTooLarge
+		}
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(dict)))
+		buf = append(buf, dict...)
 	}
 	if forSign {
 		buf = binary.BigEndian.AppendUint64(buf, 0xf7f7f7f7f7f7f7f7)
@@ -202,3 +219,144 @@ func (ztw ZtWorld) Serialize(forSign bool, c25519sig [ZT_C25519_SIGNATURE_LEN]by
 	}
 	return buf, nil
 }
+
+// Deserialize reads an address as written by ZtNodeInetAddr.Serialize and returns the bytes consumed,
+// a nil address (type 0) leaves IP unset.
+func (ztniaddr *ZtNodeInetAddr) Deserialize(b []byte) (int, error) {
+	if len(b) < 1 {
+		return 0, ErrInvalidData
+	}
+	var ipLen int
+	switch b[0] {
+	case 0:
+		ztniaddr.IP = nil
+		ztniaddr.Port = 0
+		return 1, nil
+	case 4:
+		ipLen = net.IPv4len
+	case 6:
+		ipLen = net.IPv6len
+	default:
+		return 0, ErrInvalidData
+	}
+	if len(b) < 1+ipLen+2 {
+		return 0, ErrInvalidData
+	}
+	ip := make(net.IP, ipLen)
+	copy(ip, b[1:1+ipLen])
+	ztniaddr.IP = &ip
+	ztniaddr.Port = binary.BigEndian.Uint16(b[1+ipLen:])
+	return 1 + ipLen + 2, nil
+}
+
+// Deserialize reads a root as written by ZtWorldPlanetNode.Serialize and returns the bytes consumed
+func (ztpn *ZtWorldPlanetNode) Deserialize(b []byte) (int, error) {
+	id := &ZtIdentity{}
+	p, err := id.Deserialize(b)
+	if err != nil {
+		return 0, err
+	}
+	if len(b) < p+1 {
+		return 0, ErrInvalidData
+	}
+	numEndpoints := int(b[p])
+	p++
+	if numEndpoints > ZT_WORLD_MAX_STABLE_ENDPOINTS_PER_ROOT {
+		return 0, ErrMaxEndpointsExceeded
+	}
+	eps := make([]*ZtNodeInetAddr, 0, numEndpoints)
+	for i := 0; i < numEndpoints; i++ {
+		ep := &ZtNodeInetAddr{}
+		n, err := ep.Deserialize(b[p:])
+		if err != nil {
+			return 0, err
+		}
+		p += n
+		eps = append(eps, ep)
+	}
+	ztnn := id.ToNormalNode()
+	ztpn.Identity = &ztnn
+	ztpn.Endpoints = eps
+	return p, nil
+}
+
+// Deserialize reads a signed world as written by ZtWorld.Serialize(false, sig) and returns the bytes consumed,
+// the signature is stored in ztw.Signature.
+func (ztw *ZtWorld) Deserialize(b []byte) (int, error) {
+	const headerLen = 1 + 8 + 8 + ZT_C25519_PUBLIC_KEY_LEN + ZT_C25519_SIGNATURE_LEN + 1
+	if len(b) < headerLen {
+		return 0, ErrInvalidData
+	}
+	res := ZtWorld{}
+	p := 0
+	res.Type = b[p]
+	p++
+	if res.Type != ZT_WORLD_TYPE_PLANET && res.Type != ZT_WORLD_TYPE_MOON {
+		return 0, ErrInvalidData
+	}
+	res.ID = binary.BigEndian.Uint64(b[p:])
+	p += 8
+	res.Timestamp = binary.BigEndian.Uint64(b[p:])
+	p += 8
+	copy(res.PublicKeyMustBeSignedByNextTime[:], b[p:])
+	p += ZT_C25519_PUBLIC_KEY_LEN
+	copy(res.Signature[:], b[p:])
+	p += ZT_C25519_SIGNATURE_LEN
+	numRoots := int(b[p])
+	p++
+	if numRoots > ZT_WORLD_MAX_ROOTS {
+		return 0, ErrMaxRootsExceeded
+	}
+	for i := 0; i < numRoots; i++ {
+		n := &ZtWorldPlanetNode{}
+		l, err := n.Deserialize(b[p:])
+		if err != nil {
+			return 0, err
+		}
+		p += l
+		res.Nodes = append(res.Nodes, n)
+	}
+	if res.Type == ZT_WORLD_TYPE_MOON {
+		// attached dictionary (for future use), kept as is so the signature still verifies
+		if len(b) < p+2 {
+			return 0, ErrInvalidData
+		}
+		dictLen := int(binary.BigEndian.Uint16(b[p:]))
+		p += 2
+		if len(b) < p+dictLen {
+			return 0, ErrInvalidData
+		}
+		res.Dictionary = Dictionary{data: bytes.Clone(b[p : p+dictLen])}
+		p += dictLen
+	}
+	*ztw = res
+	return p, nil
+}
+
+// Verify checks the world signature against signedBy, which must be PublicKeyMustBeSignedByNextTime
+// of the world being replaced, or of this world itself if it is the first one.
+func (ztw ZtWorld) Verify(signedBy [ZT_C25519_PUBLIC_KEY_LEN]byte) error {
+	toSign, err := ztw.Serialize(true, [ZT_C25519_SIGNATURE_LEN]byte{})
+	if err != nil {
+		return err
+	}
+	if !ztcrypto.VerifySignature(signedBy, toSign, ztw.Signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, data must hold exactly one signed world
+func (ztw *ZtWorld) UnmarshalBinary(data []byte) error {
+	if len(data) > ZT_WORLD_MAX_SERIALIZED_LENGTH {
+		return ErrSerializedDataTooLarge
+	}
+	n, err := ztw.Deserialize(data)
+	if err != nil {
+		return err
+	}
+	if n != len(data) {
+		return ErrInvalidData
+	}
+	return nil
+}
@@ -0,0 +1,501 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package node
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"ztnodeid/pkg/ztcrypto"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+)
+
+type ZtIdentityType = uint8
+
+const (
+	// ZT_IDENTITY_TYPE_C25519 is the Curve25519/Ed25519 identity type, the second field of identity.public
+	ZT_IDENTITY_TYPE_C25519 ZtIdentityType = 0
+	// ZT_IDENTITY_TYPE_P384 is the NIST P-384 plus Curve25519/Ed25519 identity type of ZeroTier
+	// 2.x. It is supported as data only: parsed, serialized, signed with and verified, but never
+	// generated, locally validated or used for key agreement, see LocallyValidate.
+	ZT_IDENTITY_TYPE_P384 ZtIdentityType = 1
+)
+
+const (
+	ZT_ECC384_PUBLIC_KEY_LEN  = ztcrypto.P384PublicKeyLen
+	ZT_ECC384_PRIVATE_KEY_LEN = ztcrypto.P384PrivateKeyLen
+	ZT_ECC384_SIGNATURE_LEN   = ztcrypto.P384SignatureLen
+	// ZT_IDENTITY_P384_COMPOUND_PUBLIC_KEY_LEN is nonce, C25519 public key and compressed P-384 public key
+	ZT_IDENTITY_P384_COMPOUND_PUBLIC_KEY_LEN = 1 + ZT_C25519_PUBLIC_KEY_LEN + ZT_ECC384_PUBLIC_KEY_LEN
+	// ZT_IDENTITY_P384_COMPOUND_PRIVATE_KEY_LEN is C25519 private key and P-384 private key
+	ZT_IDENTITY_P384_COMPOUND_PRIVATE_KEY_LEN = ZT_C25519_PRIVATE_KEY_LEN + ZT_ECC384_PRIVATE_KEY_LEN
+)
+
+// type 1 identities encode keys in lower case base32 without padding instead of hex
+var ztBase32 = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// ZtIdentity is the single identity representation of this package, ZeroTierIdentity and ZtNormalNode
+// are kept as compatible wrappers and can be converted to and from it.
+type ZtIdentity struct {
+	Address    ZtAddress
+	Type       ZtIdentityType
+	PublicKey  [ZT_C25519_PUBLIC_KEY_LEN]byte
+	privateKey *ztcrypto.PrivateKey // compound private key of the identity type, nil if not set
+	p384       *ztP384Keys          // only set for ZT_IDENTITY_TYPE_P384
+}
+
+// ztP384Keys holds the additional public key material of a type 1 identity
+type ztP384Keys struct {
+	nonce     uint8
+	publicKey [ZT_ECC384_PUBLIC_KEY_LEN]byte
+}
+
+// GenerateZtIdentity creates a new C25519 identity with private key.
+// This can be a little bit time-consuming due to one way proof of work requirements (usually a few hundred milliseconds).
+func GenerateZtIdentity() *ZtIdentity {
+	for {
+		pub, priv := ztcrypto.GenerateDualPair()
+		addr, ok := deriveAddress(pub[:])
+		if ok {
+			id := &ZtIdentity{
+				Address:    addr,
+				Type:       ZT_IDENTITY_TYPE_C25519,
+				PublicKey:  pub,
+				privateKey: ztcrypto.NewPrivateKey(priv[:]),
+			}
+			ztcrypto.Wipe(priv[:])
+			return id
+		}
+		ztcrypto.Wipe(priv[:])
+	}
+}
+
+// GenerateZtIdentityOfType creates a new identity of the given type with private key. Type 1
+// identities are refused: their address is bound to the key by the v1 proof of work of ZeroTier
+// 2.x, which is not implemented here, so zerotier-one would reject what we generate.
+func GenerateZtIdentityOfType(t ZtIdentityType) (*ZtIdentity, error) {
+	switch t {
+	case ZT_IDENTITY_TYPE_C25519:
+		return GenerateZtIdentity(), nil
+	default:
+		return nil, ErrUnsupportedIdentityType
+	}
+}
+
+// deriveAddress runs the memory-hard hash over the public key and returns the address,
+// ok is false if the key does not satisfy the proof of work or yields a reserved address
+func deriveAddress(pub []byte) (addr ZtAddress, ok bool) {
+	dig := ztcrypto.ComputeZeroTierIdentityMemoryHardHash(pub)
+	if dig[0] >= ztIdentityHashCashFirstByteLessThan {
+		return 0, false
+	}
+	addr, _ = NewZtAddressFromBytes(dig[59:64])
+	return addr, !addr.IsReserved()
+}
+
+// ParseZtIdentity parses the contents of identity.public or identity.secret
+func ParseZtIdentity(data string) (*ZtIdentity, error) {
+	fields := strings.Split(strings.TrimSpace(data), ":")
+	if len(fields) != 3 && len(fields) != 4 {
+		return nil, ErrInvalidData
+	}
+	addr, err := ParseZtAddress(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	t, err := strconv.ParseUint(fields[1], 10, 8)
+	if err != nil {
+		return nil, ErrInvalidData
+	}
+	idType := ZtIdentityType(t)
+	pubLen, privLen, err := identityKeyLengths(idType)
+	if err != nil {
+		return nil, err
+	}
+	decode := decodeHexKey
+	if idType == ZT_IDENTITY_TYPE_P384 {
+		decode = decodeBase32Key
+	}
+	pub := make([]byte, pubLen)
+	if err := decode(pub, fields[2]); err != nil {
+		return nil, err
+	}
+	var priv []byte
+	if len(fields) == 4 {
+		priv = make([]byte, privLen)
+		if err := decode(priv, fields[3]); err != nil {
+			return nil, err
+		}
+	}
+	id := &ZtIdentity{Address: addr, Type: idType}
+	id.setCompoundKeys(pub, priv)
+	ztcrypto.Wipe(priv)
+	return id, nil
+}
+
+// identityKeyLengths returns the compound public and private key lengths of an identity type
+func identityKeyLengths(t ZtIdentityType) (pubLen int, privLen int, err error) {
+	switch t {
+	case ZT_IDENTITY_TYPE_C25519:
+		return ZT_C25519_PUBLIC_KEY_LEN, ZT_C25519_PRIVATE_KEY_LEN, nil
+	case ZT_IDENTITY_TYPE_P384:
+		return ZT_IDENTITY_P384_COMPOUND_PUBLIC_KEY_LEN, ZT_IDENTITY_P384_COMPOUND_PRIVATE_KEY_LEN, nil
+	default:
+		return 0, 0, ErrUnsupportedIdentityType
+	}
+}
+
+// decodeHexKey decodes s into dst, s must encode exactly len(dst) bytes
+func decodeHexKey(dst []byte, s string) error {
+	if hex.DecodedLen(len(s)) != len(dst) {
+		return ErrInvalidData
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+// decodeBase32Key decodes s into dst, s must encode exactly len(dst) bytes
+func decodeBase32Key(dst []byte, s string) error {
+	if ztBase32.DecodedLen(len(s)) != len(dst) {
+		return ErrInvalidData
+	}
+	_, err := ztBase32.Decode(dst, []byte(s))
+	return err
+}
+
+// compoundPublicKey returns the public key as it is serialized for the identity type
+func (id *ZtIdentity) compoundPublicKey() []byte {
+	if id.Type != ZT_IDENTITY_TYPE_P384 || id.p384 == nil {
+		return id.PublicKey[:]
+	}
+	buf := make([]byte, 0, ZT_IDENTITY_P384_COMPOUND_PUBLIC_KEY_LEN)
+	buf = append(buf, id.p384.nonce)
+	buf = append(buf, id.PublicKey[:]...)
+	return append(buf, id.p384.publicKey[:]...)
+}
+
+// Fingerprint returns a short digest of the public key as serialized for the identity type
+func (id *ZtIdentity) Fingerprint() string {
+	return ztcrypto.Fingerprint(id.compoundPublicKey())
+}
+
+// PublicKeyHash returns the SHA-384 of the public key as serialized for the identity type,
+// certificates of membership carry part of it
+func (id *ZtIdentity) PublicKeyHash() [sha512.Size384]byte {
+	return sha512.Sum384(id.compoundPublicKey())
+}
+
+// compoundPrivateKey returns a copy of the private key as it is serialized for the identity type,
+// or nil. The caller should wipe it after use.
+func (id *ZtIdentity) compoundPrivateKey() []byte {
+	return id.privateKey.Bytes()
+}
+
+// setCompoundKeys splits compound keys of the lengths given by identityKeyLengths, priv may be nil
+// and is copied into a new container.
+func (id *ZtIdentity) setCompoundKeys(pub []byte, priv []byte) {
+	if id.Type == ZT_IDENTITY_TYPE_P384 && len(pub) == ZT_IDENTITY_P384_COMPOUND_PUBLIC_KEY_LEN {
+		id.p384 = &ztP384Keys{nonce: pub[0]}
+		copy(id.PublicKey[:], pub[1:])
+		copy(id.p384.publicKey[:], pub[1+ZT_C25519_PUBLIC_KEY_LEN:])
+	} else {
+		copy(id.PublicKey[:], pub)
+	}
+	id.privateKey = nil
+	if len(priv) > 0 {
+		id.privateKey = ztcrypto.NewPrivateKey(priv)
+	}
+}
+
+// c25519PrivateKey copies the C25519 part of the private key, the caller should wipe it after use
+func (id *ZtIdentity) c25519PrivateKey() (k [ZT_C25519_PRIVATE_KEY_LEN]byte) {
+	copy(k[:], id.privateKey.Slice(0, ZT_C25519_PRIVATE_KEY_LEN))
+	return
+}
+
+// p384PrivateKey copies the P-384 part of the private key, ok is false if it is not present
+func (id *ZtIdentity) p384PrivateKey() (k [ZT_ECC384_PRIVATE_KEY_LEN]byte, ok bool) {
+	part := id.privateKey.Slice(ZT_C25519_PRIVATE_KEY_LEN, ZT_IDENTITY_P384_COMPOUND_PRIVATE_KEY_LEN)
+	if part == nil {
+		return k, false
+	}
+	copy(k[:], part)
+	ztcrypto.Wipe(part)
+	return k, true
+}
+
+// encodeKey encodes key material for the string form of the identity type
+func (id *ZtIdentity) encodeKey(k []byte) string {
+	if id.Type == ZT_IDENTITY_TYPE_P384 {
+		return ztBase32.EncodeToString(k)
+	}
+	return hex.EncodeToString(k)
+}
+
+// HasPrivateKey returns true if the identity holds a private key
+func (id *ZtIdentity) HasPrivateKey() bool {
+	return id.privateKey.IsSet()
+}
+
+// PrivateKey returns a copy of the C25519 private key (or nil if not set)
+func (id *ZtIdentity) PrivateKey() *[ZT_C25519_PRIVATE_KEY_LEN]byte {
+	if !id.HasPrivateKey() {
+		return nil
+	}
+	k := id.c25519PrivateKey()
+	return &k
+}
+
+// DestroyPrivateKey wipes the private key from memory, the identity becomes public only
+func (id *ZtIdentity) DestroyPrivateKey() {
+	id.privateKey.Zero()
+	id.privateKey = nil
+}
+
+// P384PublicKey returns the compressed P-384 public key, ok is false for type 0 identities
+func (id *ZtIdentity) P384PublicKey() (pub [ZT_ECC384_PUBLIC_KEY_LEN]byte, ok bool) {
+	if id.p384 == nil {
+		return pub, false
+	}
+	return id.p384.publicKey, true
+}
+
+// PublicKeyString returns identity.public contents
+func (id *ZtIdentity) PublicKeyString() string {
+	return fmt.Sprintf("%s:%d:%s", id.Address, id.Type, id.encodeKey(id.compoundPublicKey()))
+}
+
+// PrivateKeyString returns identity.secret contents, or an empty string if no private key is set
+func (id *ZtIdentity) PrivateKeyString() string {
+	if !id.HasPrivateKey() {
+		return ""
+	}
+	priv := id.compoundPrivateKey()
+	defer ztcrypto.Wipe(priv)
+	return fmt.Sprintf("%s:%s", id.PublicKeyString(), id.encodeKey(priv))
+}
+
+// String returns the public form of the identity, the private key is never included
+func (id *ZtIdentity) String() string {
+	return id.PublicKeyString()
+}
+
+// Format implements fmt.Formatter so %v and friends never print the private key
+func (id ZtIdentity) Format(f fmt.State, verb rune) {
+	_, _ = f.Write([]byte(id.PublicKeyString()))
+}
+
+// Serialize writes the binary form used in worlds and HELLO packets: a 5-byte address, the type,
+// the public key, then a private key length and the private key if requested and available.
+func (id *ZtIdentity) Serialize(inclPrivKey bool) ([]byte, error) {
+	if _, _, err := identityKeyLengths(id.Type); err != nil {
+		return nil, err
+	}
+	addr := id.Address.Bytes()
+	pub := id.compoundPublicKey()
+	buf := make([]byte, 0, ZT_ADDRESS_LENGTH+2+len(pub)+ZT_IDENTITY_P384_COMPOUND_PRIVATE_KEY_LEN)
+	buf = append(buf, addr[:]...)
+	buf = append(buf, id.Type)
+	buf = append(buf, pub...)
+	if inclPrivKey && id.HasPrivateKey() {
+		priv := id.compoundPrivateKey()
+		buf = append(buf, uint8(len(priv)))
+		buf = append(buf, priv...)
+		ztcrypto.Wipe(priv)
+	} else {
+		buf = append(buf, 0)
+	}
+	return buf, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, the private key is never included
+func (id *ZtIdentity) MarshalBinary() ([]byte, error) {
+	return id.Serialize(false)
+}
+
+// Deserialize reads a binary identity as written by Serialize from the start of b and returns the
+// number of bytes consumed, so identities embedded in worlds or packets can be decoded in place.
+func (id *ZtIdentity) Deserialize(b []byte) (int, error) {
+	p := 0
+	if len(b) < ZT_ADDRESS_LENGTH+1 {
+		return 0, ErrInvalidData
+	}
+	addr, _ := NewZtAddressFromBytes(b[p:])
+	p += ZT_ADDRESS_LENGTH
+	idType := b[p]
+	p++
+	pubLen, privLen, err := identityKeyLengths(idType)
+	if err != nil {
+		return 0, err
+	}
+	if len(b) < p+pubLen+1 {
+		return 0, ErrInvalidData
+	}
+	pub := b[p : p+pubLen]
+	p += pubLen
+	var priv []byte
+	switch int(b[p]) {
+	case 0:
+		p++
+	case privLen:
+		p++
+		if len(b) < p+privLen {
+			return 0, ErrInvalidData
+		}
+		priv = b[p : p+privLen]
+		p += privLen
+	default:
+		return 0, ErrInvalidData
+	}
+	res := ZtIdentity{Address: addr, Type: idType}
+	res.setCompoundKeys(pub, priv)
+	*id = res
+	return p, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, data must hold exactly one identity
+func (id *ZtIdentity) UnmarshalBinary(data []byte) error {
+	n, err := id.Deserialize(data)
+	if err != nil {
+		return err
+	}
+	if n != len(data) {
+		return ErrInvalidData
+	}
+	return nil
+}
+
+// LocallyValidate checks the address is derived from the public key through the memory-hard hash
+// and, if a private key is present, that it matches the public key. Type 1 identities fail with
+// ErrUnsupportedIdentityType: the v1 proof of work binding their address to the key is not
+// implemented, so nothing stops a type 1 identity from claiming any address. Registries and
+// decoders that only trust validated identities therefore reject them.
+func (id *ZtIdentity) LocallyValidate() error {
+	if id.Address.IsReserved() {
+		return ErrInvalidIdentity
+	}
+	switch id.Type {
+	case ZT_IDENTITY_TYPE_C25519:
+		addr, ok := deriveAddress(id.PublicKey[:])
+		if !ok || addr != id.Address {
+			return ErrInvalidIdentity
+		}
+	default:
+		return ErrUnsupportedIdentityType
+	}
+	if !id.HasPrivateKey() {
+		return nil
+	}
+	priv := id.c25519PrivateKey()
+	defer ztcrypto.Wipe(priv[:])
+	dhPub, err := curve25519.X25519(priv[:32], curve25519.Basepoint)
+	if err != nil {
+		return err
+	}
+	edPriv := ed25519.NewKeyFromSeed(priv[32:])
+	defer ztcrypto.Wipe(edPriv)
+	if !bytes.Equal(dhPub, id.PublicKey[:32]) || !bytes.Equal(edPriv[32:], id.PublicKey[32:]) {
+		return ErrInvalidIdentity
+	}
+	return nil
+}
+
+// p384Digest is the message digest signed by type 1 identities, the compound public key is
+// hashed along with the message to bind the signature to the identity.
+func (id *ZtIdentity) p384Digest(msg []byte) []byte {
+	h := sha512.New384()
+	h.Write(msg)
+	h.Write(id.compoundPublicKey())
+	return h.Sum(nil)
+}
+
+// Sign signs msg with the private key, producing a 96-byte signature: a ZeroTier C25519 signature
+// for type 0, an ECDSA P-384 signature for type 1.
+func (id *ZtIdentity) Sign(msg []byte) ([ZT_C25519_SIGNATURE_LEN]byte, error) {
+	if !id.HasPrivateKey() {
+		return [ZT_C25519_SIGNATURE_LEN]byte{}, ErrNoPrivateKey
+	}
+	if id.Type == ZT_IDENTITY_TYPE_P384 {
+		p384Priv, ok := id.p384PrivateKey()
+		if id.p384 == nil || !ok {
+			return [ZT_C25519_SIGNATURE_LEN]byte{}, ErrNoPrivateKey
+		}
+		defer ztcrypto.Wipe(p384Priv[:])
+		return ztcrypto.SignP384Digest(id.p384.publicKey, p384Priv, id.p384Digest(msg))
+	}
+	priv := id.c25519PrivateKey()
+	defer ztcrypto.Wipe(priv[:])
+	return ztcrypto.SignMessage(id.PublicKey, priv, msg)
+}
+
+// Verify checks a 96-byte signature of msg against the public key, see Sign
+func (id *ZtIdentity) Verify(msg []byte, sig [ZT_C25519_SIGNATURE_LEN]byte) error {
+	var ok bool
+	if id.Type == ZT_IDENTITY_TYPE_P384 {
+		ok = id.p384 != nil && ztcrypto.VerifyP384Digest(id.p384.publicKey, id.p384Digest(msg), sig)
+	} else {
+		ok = ztcrypto.VerifySignature(id.PublicKey, msg, sig)
+	}
+	if !ok {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Agree returns the symmetric key this identity shares with peer, used to armor packets between
+// them. Type 1 identities only exist in ZeroTier 2.x, which agrees over both the C25519 and the
+// P-384 keys, so they are refused.
+func (id *ZtIdentity) Agree(peer *ZtIdentity) ([ztcrypto.SymmetricKeyLen]byte, error) {
+	if id.Type != ZT_IDENTITY_TYPE_C25519 || peer.Type != ZT_IDENTITY_TYPE_C25519 {
+		return [ztcrypto.SymmetricKeyLen]byte{}, ErrUnsupportedIdentityType
+	}
+	if !id.HasPrivateKey() {
+		return [ztcrypto.SymmetricKeyLen]byte{}, ErrNoPrivateKey
+	}
+	priv := id.c25519PrivateKey()
+	defer ztcrypto.Wipe(priv[:])
+	return ztcrypto.AgreeC25519(priv, peer.PublicKey)
+}
+
+// PublicOnly returns a copy of the identity without the private key
+func (id *ZtIdentity) PublicOnly() *ZtIdentity {
+	c := &ZtIdentity{Address: id.Address, Type: id.Type, PublicKey: id.PublicKey}
+	if id.p384 != nil {
+		c.p384 = &ztP384Keys{nonce: id.p384.nonce, publicKey: id.p384.publicKey}
+	}
+	return c
+}
+
+// clone returns a deep copy of the identity, including the private key
+func (id *ZtIdentity) clone() *ZtIdentity {
+	c := id.PublicOnly()
+	c.privateKey = id.privateKey.Clone()
+	return c
+}
+
+// ToZeroTierIdentity converts to the legacy ZeroTierIdentity representation
+func (id *ZtIdentity) ToZeroTierIdentity() ZeroTierIdentity {
+	return ZeroTierIdentity{id: *id.clone()}
+}
+
+// ToNormalNode converts to the ZtNormalNode representation used in worlds
+func (id *ZtIdentity) ToNormalNode() ZtNormalNode {
+	ztn := ZtNormalNode{
+		ZtNodeAddress: id.Address.Bytes(),
+		PublicKey:     id.PublicKey,
+		p384:          id.PublicOnly().p384,
+	}
+	priv := id.compoundPrivateKey()
+	ztn.privateKeyLen = copy(ztn.privateKey[:], priv)
+	ztcrypto.Wipe(priv)
+	return ztn
+}
@@ -0,0 +1,127 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package node
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"ztnodeid/pkg/ztcrypto"
+)
+
+// a root of the ZeroTier Earth planet, as listed in zerotier-one's world/mkworld.cpp
+const earthRootIdentity = "992fcf1db7:0:206ed59350b31916f749a1f85dffb3a8787dcbf83b8c6e9448d4e3ea0e3369301be716c3609344a9d1533850fb4460c50af43322bcfc8e13d3301a1f1003ceb6"
+
+func TestLocallyValidateKnownIdentity(t *testing.T) {
+	id, err := ParseZtIdentity(earthRootIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := id.LocallyValidate(); err != nil {
+		t.Fatalf("zerotier-one identity does not validate: %v", err)
+	}
+	if id.PublicKeyString() != earthRootIdentity {
+		t.Errorf("round trip gave %s", id.PublicKeyString())
+	}
+	otherAddr, err := ParseZtIdentity("992fcf1db8" + earthRootIdentity[10:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := otherAddr.LocallyValidate(); !errors.Is(err, ErrInvalidIdentity) {
+		t.Errorf("claimed address: %v, want ErrInvalidIdentity", err)
+	}
+	otherKey, err := ParseZtIdentity(earthRootIdentity[:len(earthRootIdentity)-1] + "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := otherKey.LocallyValidate(); !errors.Is(err, ErrInvalidIdentity) {
+		t.Errorf("changed key: %v, want ErrInvalidIdentity", err)
+	}
+}
+
+func TestGeneratedIdentityValidates(t *testing.T) {
+	id := GenerateZtIdentity()
+	if err := id.LocallyValidate(); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseZtIdentity(id.PrivateKeyString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parsed.LocallyValidate(); err != nil {
+		t.Errorf("identity.secret round trip: %v", err)
+	}
+	other := GenerateZtIdentity()
+	mixed, err := ParseZtIdentity(id.PublicKeyString() + ":" + strings.Split(other.PrivateKeyString(), ":")[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mixed.LocallyValidate(); !errors.Is(err, ErrInvalidIdentity) {
+		t.Errorf("private key of another identity: %v, want ErrInvalidIdentity", err)
+	}
+}
+
+// p384TestIdentity builds a type 1 identity.secret from fresh keys, the address is not derived
+func p384TestIdentity(t *testing.T) string {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cPub, cPriv := ztcrypto.GenerateDualPair()
+	pub := append([]byte{7}, cPub[:]...)
+	pub = append(pub, elliptic.MarshalCompressed(elliptic.P384(), k.X, k.Y)...)
+	priv := append(cPriv[:], k.D.FillBytes(make([]byte, ZT_ECC384_PRIVATE_KEY_LEN))...)
+	return fmt.Sprintf("0123456789:1:%s:%s", ztBase32.EncodeToString(pub), ztBase32.EncodeToString(priv))
+}
+
+func TestP384IdentityIsDataOnly(t *testing.T) {
+	secret := p384TestIdentity(t)
+	id, err := ParseZtIdentity(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Type != ZT_IDENTITY_TYPE_P384 || id.PrivateKeyString() != secret {
+		t.Fatalf("identity.secret round trip gave %s", id.PrivateKeyString())
+	}
+	data, err := id.Serialize(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &ZtIdentity{}
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.PrivateKeyString() != secret {
+		t.Errorf("binary round trip gave %s", decoded.PrivateKeyString())
+	}
+
+	msg := []byte("signed by a type 1 identity")
+	sig, err := id.Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := id.PublicOnly().Verify(msg, sig); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if err := id.Verify(append(msg, '.'), sig); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify of another message: %v, want ErrInvalidSignature", err)
+	}
+
+	if err := id.LocallyValidate(); !errors.Is(err, ErrUnsupportedIdentityType) {
+		t.Errorf("LocallyValidate: %v, want ErrUnsupportedIdentityType", err)
+	}
+	if _, err := GenerateZtIdentityOfType(ZT_IDENTITY_TYPE_P384); !errors.Is(err, ErrUnsupportedIdentityType) {
+		t.Errorf("GenerateZtIdentityOfType: %v, want ErrUnsupportedIdentityType", err)
+	}
+	if _, err := id.Agree(GenerateZtIdentity()); !errors.Is(err, ErrUnsupportedIdentityType) {
+		t.Errorf("Agree: %v, want ErrUnsupportedIdentityType", err)
+	}
+}
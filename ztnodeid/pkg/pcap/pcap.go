@@ -0,0 +1,131 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+// Package pcap reads captures in the classic pcap and the pcapng format without libpcap and
+// extracts UDP datagrams from them.
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	ErrUnknownFormat = errors.New("not a pcap or pcapng capture")
+	ErrTruncated     = errors.New("capture is truncated")
+	ErrInvalidBlock  = errors.New("invalid pcapng block")
+)
+
+// LinkType is the link-layer header type of captured frames, see https://www.tcpdump.org/linktypes.html
+type LinkType uint16
+
+const (
+	LINKTYPE_NULL       LinkType = 0
+	LINKTYPE_ETHERNET   LinkType = 1
+	LINKTYPE_RAW        LinkType = 101
+	LINKTYPE_LOOP       LinkType = 108
+	LINKTYPE_LINUX_SLL  LinkType = 113
+	LINKTYPE_IPV4       LinkType = 228
+	LINKTYPE_IPV6       LinkType = 229
+	LINKTYPE_LINUX_SLL2 LinkType = 276
+)
+
+const (
+	pcapMagicMicro = 0xa1b2c3d4
+	pcapMagicNano  = 0xa1b23c4d
+	pcapngMagic    = 0x0a0d0d0a
+	// maxFrameLen bounds allocations for corrupt captures
+	maxFrameLen = 1 << 18
+)
+
+// Frame is one captured frame
+type Frame struct {
+	Timestamp time.Time
+	LinkType  LinkType
+	// Data is the captured part of the frame, OrigLen the length on the wire
+	Data    []byte
+	OrigLen int
+}
+
+// Reader returns the frames of a capture in order
+type Reader interface {
+	// Next returns the next frame or io.EOF
+	Next() (*Frame, error)
+}
+
+// NewReader detects the capture format from its first bytes
+func NewReader(r io.Reader) (Reader, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(4)
+	if err != nil {
+		return nil, ErrUnknownFormat
+	}
+	switch {
+	case binary.BigEndian.Uint32(head) == pcapngMagic:
+		return newNgReader(br)
+	case binary.LittleEndian.Uint32(head) == pcapMagicMicro, binary.LittleEndian.Uint32(head) == pcapMagicNano,
+		binary.BigEndian.Uint32(head) == pcapMagicMicro, binary.BigEndian.Uint32(head) == pcapMagicNano:
+		return newPcapReader(br)
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+// pcapReader reads the classic format: a 24-byte file header then records with a 16-byte header
+type pcapReader struct {
+	r        io.Reader
+	order    binary.ByteOrder
+	nano     bool
+	linkType LinkType
+}
+
+func newPcapReader(r io.Reader) (*pcapReader, error) {
+	var hdr [24]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, ErrTruncated
+	}
+	p := &pcapReader{r: r, order: binary.LittleEndian}
+	magic := binary.LittleEndian.Uint32(hdr[:])
+	if magic != pcapMagicMicro && magic != pcapMagicNano {
+		p.order = binary.BigEndian
+		magic = binary.BigEndian.Uint32(hdr[:])
+	}
+	p.nano = magic == pcapMagicNano
+	// the upper bits of the link type field carry the FCS length
+	p.linkType = LinkType(p.order.Uint32(hdr[20:]) & 0x0fffffff)
+	return p, nil
+}
+
+func (p *pcapReader) Next() (*Frame, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(p.r, hdr[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, ErrTruncated
+	}
+	sec := int64(p.order.Uint32(hdr[0:]))
+	frac := int64(p.order.Uint32(hdr[4:]))
+	capLen := p.order.Uint32(hdr[8:])
+	if capLen > maxFrameLen {
+		return nil, ErrTruncated
+	}
+	data := make([]byte, capLen)
+	if _, err := io.ReadFull(p.r, data); err != nil {
+		return nil, ErrTruncated
+	}
+	if !p.nano {
+		frac *= int64(time.Microsecond)
+	}
+	return &Frame{
+		Timestamp: time.Unix(sec, frac).UTC(),
+		LinkType:  p.linkType,
+		Data:      data,
+		OrigLen:   int(p.order.Uint32(hdr[12:])),
+	}, nil
+}
@@ -0,0 +1,172 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package pcap
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Captures in testdata, written outside this package:
+//   - le_micro.pcap: little endian, microseconds, Ethernet. A UDP datagram from 10.0.0.1:9993 to
+//     10.0.0.2:40000 and a reply cut to 30 of its 48 bytes.
+//   - be_nano.pcap: big endian, nanoseconds, raw IP with an FCS length in the link type field. A
+//     UDP datagram from [fd00::1]:9993 to [fd00::2]:9994.
+//   - two_sections.pcapng: a little endian section with an Ethernet interface at if_tsresol 9,
+//     then a big endian one with a raw IP interface at if_tsresol 2^-20 and if_tsoffset 10,
+//     holding an enhanced and a simple packet block.
+
+// wantFrame describes a frame, udp is the payload DecodeUDP should find, empty if none
+type wantFrame struct {
+	ts       time.Time
+	linkType LinkType
+	capLen   int
+	origLen  int
+	src, dst string
+	udp      string
+}
+
+func readFixture(t testing.TB, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// readAll returns the frames of a capture up to the first error, nil at the end of it
+func readAll(data []byte) ([]*Frame, error) {
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var frames []*Frame
+	for {
+		f, err := r.Next()
+		if err == io.EOF {
+			return frames, nil
+		}
+		if err != nil {
+			return frames, err
+		}
+		frames = append(frames, f)
+	}
+}
+
+func checkFrames(t *testing.T, name string, frames []*Frame, want []wantFrame) {
+	t.Helper()
+	if len(frames) != len(want) {
+		t.Fatalf("%s: got %d frames, want %d", name, len(frames), len(want))
+	}
+	for i, w := range want {
+		f := frames[i]
+		if !f.Timestamp.Equal(w.ts) || f.LinkType != w.linkType || len(f.Data) != w.capLen || f.OrigLen != w.origLen {
+			t.Errorf("%s frame %d: %v link type %d %d/%d bytes, want %v %d %d/%d", name, i, f.Timestamp, f.LinkType, len(f.Data), f.OrigLen, w.ts, w.linkType, w.capLen, w.origLen)
+		}
+		u, ok := DecodeUDP(f)
+		if ok != (w.udp != "") {
+			t.Errorf("%s frame %d: DecodeUDP ok %v", name, i, ok)
+			continue
+		}
+		if ok && (u.Src.String() != w.src || u.Dst.String() != w.dst || string(u.Payload) != w.udp) {
+			t.Errorf("%s frame %d: %s -> %s %q, want %s -> %s %q", name, i, u.Src, u.Dst, u.Payload, w.src, w.dst, w.udp)
+		}
+	}
+}
+
+func TestClassicPcap(t *testing.T) {
+	tests := []struct {
+		file   string
+		frames []wantFrame
+	}{
+		{"le_micro.pcap", []wantFrame{
+			{time.Unix(1700000000, 123456000), LINKTYPE_ETHERNET, 47, 47, "10.0.0.1:9993", "10.0.0.2:40000", "first"},
+			{time.Unix(1700000001, 999999000), LINKTYPE_ETHERNET, 30, 48, "", "", ""},
+		}},
+		{"be_nano.pcap", []wantFrame{
+			{time.Unix(1700000000, 123456789), LINKTYPE_RAW, 53, 53, "[fd00::1]:9993", "[fd00::2]:9994", "sixth"},
+		}},
+	}
+	for _, tt := range tests {
+		frames, err := readAll(readFixture(t, tt.file))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.file, err)
+		}
+		checkFrames(t, tt.file, frames, tt.frames)
+	}
+}
+
+func TestTruncatedCaptures(t *testing.T) {
+	// offsets at which a capture may end, after the file header or a record for pcap, after a
+	// block for pcapng, and the frames read by then
+	tests := []struct {
+		file   string
+		bounds map[int]int
+	}{
+		{"le_micro.pcap", map[int]int{24: 0, 87: 1}},
+		{"be_nano.pcap", map[int]int{24: 0}},
+		{"two_sections.pcapng", map[int]int{44: 0, 84: 0, 164: 1, 192: 1, 232: 1, 320: 2}},
+	}
+	for _, tt := range tests {
+		data := readFixture(t, tt.file)
+		for cut := 0; cut < len(data); cut++ {
+			frames, err := readAll(data[:cut])
+			n, ok := tt.bounds[cut]
+			switch {
+			case cut < 4:
+				if !errors.Is(err, ErrUnknownFormat) {
+					t.Errorf("%s cut at %d: %v, want ErrUnknownFormat", tt.file, cut, err)
+				}
+			case ok:
+				if err != nil || len(frames) != n {
+					t.Errorf("%s cut at %d: %d frames, %v, want %d frames", tt.file, cut, len(frames), err, n)
+				}
+			case !errors.Is(err, ErrTruncated):
+				t.Errorf("%s cut at %d: %v, want ErrTruncated", tt.file, cut, err)
+			}
+		}
+	}
+}
+
+func TestNewReaderUnknownFormat(t *testing.T) {
+	for _, head := range [][]byte{nil, []byte("GET / HTTP/1.1\r\n"), {0xd4, 0xc3, 0xb2}} {
+		if _, err := NewReader(bytes.NewReader(head)); !errors.Is(err, ErrUnknownFormat) {
+			t.Errorf("%q: %v, want ErrUnknownFormat", head, err)
+		}
+	}
+}
+
+func FuzzNewReader(f *testing.F) {
+	for _, name := range []string{"le_micro.pcap", "be_nano.pcap", "two_sections.pcapng"} {
+		f.Add(readFixture(f, name))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r, err := NewReader(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		for {
+			frame, err := r.Next()
+			if err != nil {
+				if err != io.EOF && !slices.Contains([]error{ErrTruncated, ErrInvalidBlock}, err) {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			if len(frame.Data) > maxFrameLen {
+				t.Fatalf("frame of %d bytes", len(frame.Data))
+			}
+			DecodeUDP(frame)
+		}
+	})
+}
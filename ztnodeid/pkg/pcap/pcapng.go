@@ -0,0 +1,227 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package pcap
+
+import (
+	"encoding/binary"
+	"io"
+	"math/bits"
+	"time"
+)
+
+// pcapng block types, see https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html
+const (
+	ngBlockSectionHeader   = 0x0a0d0d0a
+	ngBlockInterface       = 0x00000001
+	ngBlockPacketObsolete  = 0x00000002
+	ngBlockSimplePacket    = 0x00000003
+	ngBlockEnhancedPacket  = 0x00000006
+	ngByteOrderMagic       = 0x1a2b3c4d
+	ngOptionEnd            = 0
+	ngOptionIfTsresol      = 9
+	ngOptionIfTsoffset     = 14
+	ngDefaultTsresol       = 6
+	ngMinBlockLen          = 12
+	ngSectionHeaderBodyLen = 16
+)
+
+type ngInterface struct {
+	linkType LinkType
+	// unitsPerSecond is derived from if_tsresol, offset from if_tsoffset in seconds
+	unitsPerSecond uint64
+	offset         int64
+}
+
+// ngReader reads pcapng sections, each starting with a section header block that sets the byte
+// order and resets the interfaces
+type ngReader struct {
+	r          io.Reader
+	order      binary.ByteOrder
+	interfaces []ngInterface
+}
+
+func newNgReader(r io.Reader) (*ngReader, error) {
+	return &ngReader{r: r, order: binary.LittleEndian}, nil
+}
+
+func (n *ngReader) Next() (*Frame, error) {
+	for {
+		blockType, body, err := n.readBlock()
+		if err != nil {
+			return nil, err
+		}
+		switch blockType {
+		case ngBlockInterface:
+			if err := n.addInterface(body); err != nil {
+				return nil, err
+			}
+		case ngBlockEnhancedPacket:
+			return n.enhancedPacket(body)
+		case ngBlockPacketObsolete:
+			return n.obsoletePacket(body)
+		case ngBlockSimplePacket:
+			return n.simplePacket(body)
+		}
+	}
+}
+
+// readBlock returns the body of the next block, between the two length fields
+func (n *ngReader) readBlock() (uint32, []byte, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(n.r, hdr[:]); err != nil {
+		if err == io.EOF {
+			return 0, nil, io.EOF
+		}
+		return 0, nil, ErrTruncated
+	}
+	// the section header type reads the same in both byte orders, its body tells the order
+	blockType := n.order.Uint32(hdr[0:])
+	if binary.BigEndian.Uint32(hdr[0:]) == ngBlockSectionHeader {
+		blockType = ngBlockSectionHeader
+		var bom [4]byte
+		if _, err := io.ReadFull(n.r, bom[:]); err != nil {
+			return 0, nil, ErrTruncated
+		}
+		switch {
+		case binary.LittleEndian.Uint32(bom[:]) == ngByteOrderMagic:
+			n.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(bom[:]) == ngByteOrderMagic:
+			n.order = binary.BigEndian
+		default:
+			return 0, nil, ErrInvalidBlock
+		}
+		n.interfaces = n.interfaces[:0]
+		total := n.order.Uint32(hdr[4:])
+		if total < ngMinBlockLen+ngSectionHeaderBodyLen || total%4 != 0 || total > maxFrameLen {
+			return 0, nil, ErrInvalidBlock
+		}
+		// the rest of the body after the byte order magic, then the trailing length
+		rest := make([]byte, total-ngMinBlockLen)
+		if _, err := io.ReadFull(n.r, rest); err != nil {
+			return 0, nil, ErrTruncated
+		}
+		return blockType, append(bom[:], rest[:len(rest)-4]...), nil
+	}
+	total := n.order.Uint32(hdr[4:])
+	if total < ngMinBlockLen || total%4 != 0 || total > maxFrameLen {
+		return 0, nil, ErrInvalidBlock
+	}
+	rest := make([]byte, total-8)
+	if _, err := io.ReadFull(n.r, rest); err != nil {
+		return 0, nil, ErrTruncated
+	}
+	return blockType, rest[:len(rest)-4], nil
+}
+
+func (n *ngReader) addInterface(body []byte) error {
+	if len(body) < 8 {
+		return ErrInvalidBlock
+	}
+	iface := ngInterface{linkType: LinkType(n.order.Uint16(body[0:])), unitsPerSecond: 1_000_000}
+	for opts := body[8:]; len(opts) >= 4; {
+		code, length := n.order.Uint16(opts[0:]), int(n.order.Uint16(opts[2:]))
+		if code == ngOptionEnd || len(opts) < 4+length {
+			break
+		}
+		value := opts[4 : 4+length]
+		switch {
+		case code == ngOptionIfTsresol && length == 1:
+			iface.unitsPerSecond = tsresolUnits(value[0])
+		case code == ngOptionIfTsoffset && length == 8:
+			iface.offset = int64(n.order.Uint64(value))
+		}
+		opts = opts[4+(length+3)&^3:]
+	}
+	n.interfaces = append(n.interfaces, iface)
+	return nil
+}
+
+// tsresolUnits converts if_tsresol, a negative power of 10, or of 2 if the high bit is set
+func tsresolUnits(v byte) uint64 {
+	if v&0x80 != 0 {
+		return 1 << min(v&0x7f, 63)
+	}
+	units := uint64(1)
+	for i := byte(0); i < min(v, 19); i++ {
+		units *= 10
+	}
+	return units
+}
+
+func (n *ngReader) iface(id uint32) (*ngInterface, error) {
+	if int(id) >= len(n.interfaces) {
+		return nil, ErrInvalidBlock
+	}
+	return &n.interfaces[id], nil
+}
+
+// timestamp converts a timestamp in interface units
+func (iface *ngInterface) timestamp(ts uint64) time.Time {
+	sec := ts / iface.unitsPerSecond
+	frac := ts % iface.unitsPerSecond
+	hi, lo := bits.Mul64(frac, uint64(time.Second))
+	nsec, _ := bits.Div64(hi, lo, iface.unitsPerSecond)
+	return time.Unix(int64(sec)+iface.offset, int64(nsec)).UTC()
+}
+
+func (n *ngReader) enhancedPacket(body []byte) (*Frame, error) {
+	if len(body) < 20 {
+		return nil, ErrInvalidBlock
+	}
+	iface, err := n.iface(n.order.Uint32(body[0:]))
+	if err != nil {
+		return nil, err
+	}
+	ts := uint64(n.order.Uint32(body[4:]))<<32 | uint64(n.order.Uint32(body[8:]))
+	capLen := n.order.Uint32(body[12:])
+	if uint64(capLen) > uint64(len(body)-20) {
+		return nil, ErrInvalidBlock
+	}
+	return &Frame{
+		Timestamp: iface.timestamp(ts),
+		LinkType:  iface.linkType,
+		Data:      body[20 : 20+capLen],
+		OrigLen:   int(n.order.Uint32(body[16:])),
+	}, nil
+}
+
+func (n *ngReader) obsoletePacket(body []byte) (*Frame, error) {
+	if len(body) < 20 {
+		return nil, ErrInvalidBlock
+	}
+	iface, err := n.iface(uint32(n.order.Uint16(body[0:])))
+	if err != nil {
+		return nil, err
+	}
+	ts := uint64(n.order.Uint32(body[4:]))<<32 | uint64(n.order.Uint32(body[8:]))
+	capLen := n.order.Uint32(body[12:])
+	if uint64(capLen) > uint64(len(body)-20) {
+		return nil, ErrInvalidBlock
+	}
+	return &Frame{
+		Timestamp: iface.timestamp(ts),
+		LinkType:  iface.linkType,
+		Data:      body[20 : 20+capLen],
+		OrigLen:   int(n.order.Uint32(body[16:])),
+	}, nil
+}
+
+// simplePacket has no timestamp and belongs to the first interface
+func (n *ngReader) simplePacket(body []byte) (*Frame, error) {
+	if len(body) < 4 {
+		return nil, ErrInvalidBlock
+	}
+	iface, err := n.iface(0)
+	if err != nil {
+		return nil, err
+	}
+	origLen := int(n.order.Uint32(body[0:]))
+	data := body[4:]
+	if origLen < len(data) {
+		data = data[:origLen]
+	}
+	return &Frame{LinkType: iface.linkType, Data: data, OrigLen: origLen}, nil
+}
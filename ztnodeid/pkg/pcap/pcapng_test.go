@@ -0,0 +1,60 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package pcap
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPcapngSections(t *testing.T) {
+	frames, err := readAll(readFixture(t, "two_sections.pcapng"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFrames(t, "two_sections.pcapng", frames, []wantFrame{
+		{time.Unix(1700000000, 123456789), LINKTYPE_ETHERNET, 47, 47, "10.0.0.1:9993", "10.0.0.2:40000", "first"},
+		// 2^19 units of 2^-20 s, plus if_tsoffset
+		{time.Unix(1700000010, 500000000), LINKTYPE_RAW, 53, 53, "[fd00::1]:9993", "[fd00::2]:9994", "sixth"},
+		// simple packet blocks carry no timestamp
+		{time.Time{}, LINKTYPE_RAW, 53, 53, "[fd00::1]:9993", "[fd00::2]:9994", "sixth"},
+	})
+}
+
+func TestPcapngSectionResetsInterfaces(t *testing.T) {
+	data := readFixture(t, "two_sections.pcapng")
+	// first section with its interface, second section header, then a packet of the second
+	// section for interface 0, which that section never described
+	spliced := slices.Concat(data[:84], data[164:192], data[232:320])
+	if _, err := readAll(spliced); !errors.Is(err, ErrInvalidBlock) {
+		t.Errorf("packet for an interface of the previous section: %v, want ErrInvalidBlock", err)
+	}
+}
+
+func TestTsresolUnits(t *testing.T) {
+	tests := []struct {
+		tsresol byte
+		units   uint64
+	}{
+		{0, 1},
+		{3, 1_000},
+		{ngDefaultTsresol, 1_000_000},
+		{9, 1_000_000_000},
+		{19, 10_000_000_000_000_000_000},
+		{30, 10_000_000_000_000_000_000},
+		{0x80, 1},
+		{0x80 | 20, 1 << 20},
+		{0x80 | 63, 1 << 63},
+		{0xff, 1 << 63},
+	}
+	for _, tt := range tests {
+		if got := tsresolUnits(tt.tsresol); got != tt.units {
+			t.Errorf("tsresolUnits(%#x) = %d, want %d", tt.tsresol, got, tt.units)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package pcap
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+const (
+	etherTypeIPv4  = 0x0800
+	etherTypeIPv6  = 0x86dd
+	etherTypeVLAN  = 0x8100
+	etherTypeQinQ  = 0x88a8
+	ipProtoUDP     = 17
+	ipv6HopByHop   = 0
+	ipv6Routing    = 43
+	ipv6DestOpts   = 60
+	udpHeaderLen   = 8
+	ipv6HeaderLen  = 40
+	ethernetHdrLen = 14
+)
+
+// UDP is a datagram found in a frame
+type UDP struct {
+	Src     netip.AddrPort
+	Dst     netip.AddrPort
+	Payload []byte
+}
+
+// DecodeUDP extracts the UDP datagram carried by a frame. Frames that are not UDP over IPv4 or
+// IPv6, and IP fragments, are reported as not ok.
+func DecodeUDP(f *Frame) (*UDP, bool) {
+	ip, ok := network(f.LinkType, f.Data)
+	if !ok || len(ip) < 1 {
+		return nil, false
+	}
+	switch ip[0] >> 4 {
+	case 4:
+		return decodeIPv4(ip)
+	case 6:
+		return decodeIPv6(ip)
+	}
+	return nil, false
+}
+
+// network strips the link-layer header
+func network(lt LinkType, b []byte) ([]byte, bool) {
+	switch lt {
+	case LINKTYPE_ETHERNET:
+		if len(b) < ethernetHdrLen {
+			return nil, false
+		}
+		etherType := binary.BigEndian.Uint16(b[12:])
+		b = b[ethernetHdrLen:]
+		for etherType == etherTypeVLAN || etherType == etherTypeQinQ {
+			if len(b) < 4 {
+				return nil, false
+			}
+			etherType = binary.BigEndian.Uint16(b[2:])
+			b = b[4:]
+		}
+		return b, etherType == etherTypeIPv4 || etherType == etherTypeIPv6
+	case LINKTYPE_NULL, LINKTYPE_LOOP:
+		// the 4-byte address family is in host or network order, the IP version tells enough
+		if len(b) < 4 {
+			return nil, false
+		}
+		return b[4:], true
+	case LINKTYPE_RAW, LINKTYPE_IPV4, LINKTYPE_IPV6:
+		return b, true
+	case LINKTYPE_LINUX_SLL:
+		if len(b) < 16 {
+			return nil, false
+		}
+		return b[16:], true
+	case LINKTYPE_LINUX_SLL2:
+		if len(b) < 20 {
+			return nil, false
+		}
+		return b[20:], true
+	}
+	return nil, false
+}
+
+func decodeIPv4(b []byte) (*UDP, bool) {
+	if len(b) < 20 {
+		return nil, false
+	}
+	ihl := int(b[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(b[2:]))
+	// more fragments set or a fragment offset
+	fragmented := binary.BigEndian.Uint16(b[6:])&0x3fff != 0
+	if ihl < 20 || total < ihl || len(b) < total || fragmented || b[9] != ipProtoUDP {
+		return nil, false
+	}
+	src := netip.AddrFrom4([4]byte(b[12:16]))
+	dst := netip.AddrFrom4([4]byte(b[16:20]))
+	return decodeUDP(src, dst, b[ihl:total])
+}
+
+func decodeIPv6(b []byte) (*UDP, bool) {
+	if len(b) < ipv6HeaderLen {
+		return nil, false
+	}
+	end := ipv6HeaderLen + int(binary.BigEndian.Uint16(b[4:]))
+	if len(b) < end {
+		return nil, false
+	}
+	src := netip.AddrFrom16([16]byte(b[8:24]))
+	dst := netip.AddrFrom16([16]byte(b[24:40]))
+	next, p := b[6], ipv6HeaderLen
+	for {
+		switch next {
+		case ipProtoUDP:
+			return decodeUDP(src, dst, b[p:end])
+		case ipv6HopByHop, ipv6Routing, ipv6DestOpts:
+			if end < p+8 {
+				return nil, false
+			}
+			next = b[p]
+			p += (int(b[p+1]) + 1) * 8
+			if p > end {
+				return nil, false
+			}
+		default:
+			// fragments and everything else
+			return nil, false
+		}
+	}
+}
+
+func decodeUDP(src netip.Addr, dst netip.Addr, b []byte) (*UDP, bool) {
+	if len(b) < udpHeaderLen {
+		return nil, false
+	}
+	length := int(binary.BigEndian.Uint16(b[4:]))
+	if length < udpHeaderLen || length > len(b) {
+		return nil, false
+	}
+	return &UDP{
+		Src:     netip.AddrPortFrom(src, binary.BigEndian.Uint16(b[0:])),
+		Dst:     netip.AddrPortFrom(dst, binary.BigEndian.Uint16(b[2:])),
+		Payload: b[udpHeaderLen:length],
+	}, true
+}
@@ -0,0 +1,257 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+// Package probe checks the roots of a world answer HELLO on their stable endpoints.
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+	"ztnodeid/pkg/node"
+	"ztnodeid/pkg/protocol"
+	"ztnodeid/pkg/ztcrypto"
+)
+
+// DefaultTimeout is how long an endpoint has to answer
+const DefaultTimeout = 3 * time.Second
+
+var (
+	ErrNoAnswer        = errors.New("no answer before the timeout")
+	ErrWrongIdentity   = errors.New("answer is not authenticated by the root identity")
+	ErrErrorReply      = errors.New("root replied with ERROR")
+	ErrEndpointsFailed = errors.New("some endpoints did not answer as expected")
+)
+
+// Options tune a probe, the zero value probes with a fresh identity and DefaultTimeout
+type Options struct {
+	// Identity says HELLO, it must hold a private key. A new one is generated when nil.
+	Identity *node.ZtIdentity
+	Timeout  time.Duration
+}
+
+// Result is the outcome of probing one endpoint of one root
+type Result struct {
+	Root     string `json:"root"`
+	Endpoint string `json:"endpoint"`
+	Answered bool   `json:"answered"`
+	// IdentityMatch is set when the answer came from the root address and was authenticated with
+	// the key agreed with the root identity in the world
+	IdentityMatch bool          `json:"identityMatch"`
+	RTT           time.Duration `json:"rtt"`
+	Version       string        `json:"version,omitempty"`
+	ProtoVersion  uint8         `json:"protoVersion,omitempty"`
+	// WorldID and WorldTimestamp are the world the root serves. Roots only send a world whose ID
+	// matches the probed one, both are zero otherwise.
+	WorldID        node.ZtWorldID `json:"worldId"`
+	WorldTimestamp uint64         `json:"worldTimestamp"`
+	// WorldMatch is set when the root serves exactly the probed world
+	WorldMatch bool   `json:"worldMatch"`
+	Error      string `json:"error,omitempty"`
+}
+
+// OK reports whether the endpoint answered as the root and serves the probed world
+func (r *Result) OK() bool {
+	return r.Answered && r.IdentityMatch && r.WorldMatch
+}
+
+// World sends HELLO to every stable endpoint of every root of w at once and returns the results
+// in the order of the world. HELLO announces a planet with timestamp 1 and a moon with timestamp
+// 0, so roots of the same planet or moon answer with the copy they serve.
+func World(ctx context.Context, w *node.ZtWorld, opts Options) ([]Result, error) {
+	self := opts.Identity
+	if self == nil {
+		self = node.GenerateZtIdentity()
+		defer self.DestroyPrivateKey()
+	}
+	if !self.HasPrivateKey() {
+		return nil, node.ErrNoPrivateKey
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	var results []Result
+	type target struct {
+		root *node.ZtIdentity
+		ep   *node.ZtNodeInetAddr
+	}
+	var targets []target
+	for _, n := range w.Nodes {
+		for _, ep := range n.Endpoints {
+			id := n.Identity.ToIdentity()
+			targets = append(targets, target{id, ep})
+			results = append(results, Result{Root: id.Address.String(), Endpoint: ep.String()})
+		}
+	}
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probeEndpoint(ctx, w, self, t.root, t.ep, opts.Timeout, &results[i])
+		}()
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// Err returns ErrEndpointsFailed if any result is not OK
+func Err(results []Result) error {
+	failed := 0
+	for i := range results {
+		if !results[i].OK() {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d", ErrEndpointsFailed, failed, len(results))
+	}
+	return nil
+}
+
+func probeEndpoint(ctx context.Context, w *node.ZtWorld, self *node.ZtIdentity, root *node.ZtIdentity, ep *node.ZtNodeInetAddr, timeout time.Duration, r *Result) {
+	if err := hello(ctx, w, self, root, ep, timeout, r); err != nil {
+		r.Error = err.Error()
+	}
+}
+
+func hello(ctx context.Context, w *node.ZtWorld, self *node.ZtIdentity, root *node.ZtIdentity, ep *node.ZtNodeInetAddr, timeout time.Duration, r *Result) error {
+	key, err := self.Agree(root)
+	if err != nil {
+		return err
+	}
+	defer ztcrypto.Wipe(key[:])
+	packetID, err := protocol.NewPacketID()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	// roots only attach their planet when the announced timestamp is set and older, 1 is older
+	// than any planet
+	h := protocol.NewHello(self, ep, w.ID, 1, uint64(start.UnixMilli()))
+	if w.Type == node.ZT_WORLD_TYPE_MOON {
+		h.PlanetID = 0
+		h.Moons = []protocol.HelloMoon{{Type: w.Type, ID: w.ID}}
+	}
+	out, err := protocol.ArmorHello(h, packetID, root.Address, &key)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", net.JoinHostPort(ep.IP.String(), strconv.Itoa(int(ep.Port))))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+	if _, err := conn.Write(out); err != nil {
+		return err
+	}
+	asm := protocol.NewAssembler()
+	buf := make([]byte, protocol.ZT_PROTO_MAX_PACKET_LENGTH)
+	var wrongIdentity bool
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				if wrongIdentity {
+					return ErrWrongIdentity
+				}
+				return ErrNoAnswer
+			}
+			return err
+		}
+		packet, complete, err := asm.Add(append([]byte(nil), buf[:n]...), time.Now())
+		if err != nil || !complete {
+			continue
+		}
+		rtt := time.Since(start)
+		var hdr protocol.Header
+		if _, err := hdr.Deserialize(packet); err != nil || hdr.Destination != self.Address {
+			continue
+		}
+		r.Answered = true
+		if hdr.Source != root.Address {
+			wrongIdentity = true
+			continue
+		}
+		reply, err := protocol.Dearmor(packet, &key)
+		if err != nil {
+			wrongIdentity = true
+			continue
+		}
+		r.IdentityMatch = true
+		r.RTT = rtt
+		switch reply.Verb {
+		case protocol.ZT_PROTO_VERB_OK:
+			ok := &protocol.OkHello{}
+			if _, err := ok.Deserialize(reply.Payload); err != nil || ok.PacketID != packetID {
+				continue
+			}
+			r.ProtoVersion = ok.ProtoVersion
+			r.Version = fmt.Sprintf("%d.%d.%d", ok.Major, ok.Minor, ok.Revision)
+			for _, adv := range ok.Worlds {
+				if adv.ID == w.ID && adv.Timestamp >= r.WorldTimestamp {
+					r.WorldID, r.WorldTimestamp = adv.ID, adv.Timestamp
+				}
+			}
+			r.WorldMatch = r.WorldID == w.ID && r.WorldTimestamp == w.Timestamp
+			return nil
+		case protocol.ZT_PROTO_VERB_ERROR:
+			e := &protocol.ErrorReply{}
+			if _, err := e.Deserialize(reply.Payload); err != nil || e.PacketID != packetID {
+				continue
+			}
+			return fmt.Errorf("%w code %d", ErrErrorReply, e.Code)
+		}
+	}
+}
+
+// WriteText prints the results for humans
+func WriteText(out io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROOT\tENDPOINT\tRTT\tIDENTITY\tVERSION\tWORLD\tSTATUS")
+	for _, r := range results {
+		rtt, identity, world, status := "-", "-", "-", "ok"
+		if r.Answered {
+			identity = "mismatch"
+		}
+		if r.IdentityMatch {
+			rtt = r.RTT.Round(time.Microsecond).String()
+			identity = "match"
+		}
+		if r.WorldID != 0 {
+			world = fmt.Sprintf("%d@%d", r.WorldID, r.WorldTimestamp)
+		}
+		switch {
+		case r.Error != "":
+			status = r.Error
+		case !r.WorldMatch && r.WorldID == 0:
+			status = "root does not serve this world"
+		case !r.WorldMatch:
+			status = "root serves another version of this world"
+		}
+		version := r.Version
+		if version == "" {
+			version = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Root, r.Endpoint, rtt, identity, version, world, status)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,134 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package probe
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+	"ztnodeid/pkg/mkworld"
+	"ztnodeid/pkg/node"
+	"ztnodeid/pkg/rootserver"
+)
+
+// startRoot serves the planet listing root on a free 127.0.0.1 port with timestamp served, and
+// returns the same planet with timestamp probedTimestamp for probing
+func startRoot(t *testing.T, root *node.ZtIdentity, served uint64, probedTimestamp uint64) (*node.ZtWorld, *rootserver.Server) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep := fmt.Sprintf("127.0.0.1/%d", conn.LocalAddr().(*net.UDPAddr).Port)
+	key := mkworld.GenerateSigningKey()
+	t.Cleanup(key.Zero)
+	// a custom planet, never one of the reserved Earth or Mars IDs
+	worldID, err := mkworld.RandomWorldID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	world := func(ts uint64) (*node.ZtWorld, []byte) {
+		conf := &mkworld.MkWorldConfig{RootNodes: []mkworld.MkWorldNode{{IdentityStr: root.PublicKeyString(), Endpoints: []string{ep}}}}
+		nodes, err := conf.BuildRootNodes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := &node.ZtWorld{Type: node.ZT_WORLD_TYPE_PLANET, ID: worldID, Timestamp: ts, Nodes: nodes}
+		data, err := mkworld.SignWorld(w, key, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w, data
+	}
+	_, data := world(served)
+	id := root.ToNormalNode()
+	s, err := rootserver.New(&id, data, rootserver.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx, conn) }()
+	t.Cleanup(func() {
+		cancel()
+		conn.Close()
+		<-done
+	})
+	probed, _ := world(probedTimestamp)
+	return probed, s
+}
+
+func TestWorldAgainstRootServer(t *testing.T) {
+	root := node.GenerateZtIdentity()
+	w, s := startRoot(t, root, 1700000000000, 1700000000000)
+	self := node.GenerateZtIdentity()
+	results, err := World(context.Background(), w, Options{Identity: self, Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	r := results[0]
+	if !r.Answered || !r.IdentityMatch {
+		t.Fatalf("answered %v, identity match %v, error %q", r.Answered, r.IdentityMatch, r.Error)
+	}
+	if r.Root != s.Address().String() {
+		t.Errorf("root %s, want %s", r.Root, s.Address())
+	}
+	if r.RTT <= 0 || r.RTT > 2*time.Second {
+		t.Errorf("rtt %v out of range", r.RTT)
+	}
+	if r.WorldID != w.ID || r.WorldTimestamp != w.Timestamp || !r.WorldMatch {
+		t.Errorf("world %d@%d match %v, want %d@%d", r.WorldID, r.WorldTimestamp, r.WorldMatch, w.ID, w.Timestamp)
+	}
+	if err := Err(results); err != nil {
+		t.Error(err)
+	}
+	if _, ok := s.Peer(self.Address); !ok {
+		t.Error("root did not record the probing peer")
+	}
+}
+
+func TestWorldReportsNewerServedWorld(t *testing.T) {
+	root := node.GenerateZtIdentity()
+	w, _ := startRoot(t, root, 1700000000001, 1700000000000)
+	results, err := World(context.Background(), w, Options{Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := results[0]
+	if !r.IdentityMatch {
+		t.Fatalf("identity match false, error %q", r.Error)
+	}
+	if r.WorldMatch || r.WorldTimestamp != 1700000000001 {
+		t.Errorf("world timestamp %d match %v, want the newer served world", r.WorldTimestamp, r.WorldMatch)
+	}
+	if Err(results) == nil {
+		t.Error("Err accepted a root serving another world")
+	}
+}
+
+func TestWorldWrongIdentity(t *testing.T) {
+	root := node.GenerateZtIdentity()
+	w, _ := startRoot(t, root, 1700000000000, 1700000000000)
+	// same endpoint, but the world names another root
+	other := node.GenerateZtIdentity()
+	n := *w.Nodes[0]
+	if err := n.Identity.FromString(other.PublicKeyString(), false); err != nil {
+		t.Fatal(err)
+	}
+	w.Nodes = []*node.ZtWorldPlanetNode{&n}
+	results, err := World(context.Background(), w, Options{Timeout: 300 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := results[0]; r.IdentityMatch || r.OK() {
+		t.Errorf("probe accepted an answer from %s as %s", root.Address, other.Address)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package protocol
+
+import (
+	"ztnodeid/pkg/node"
+	"ztnodeid/pkg/ztcrypto"
+)
+
+// Armor serializes the packet, encrypting it with Salsa20/12 if encrypt is set, and
+// authenticates it with the key shared by source and destination, see ZtIdentity.Agree. Set
+// Fragmented before armoring a packet that exceeds the MTU.
+func (p *Packet) Armor(key *[ztcrypto.SymmetricKeyLen]byte, encrypt bool) ([]byte, error) {
+	b, err := p.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	if err := ztcrypto.ArmorPacket(b, key, encrypt); err != nil {
+		return nil, err
+	}
+	if _, err := p.Header.Deserialize(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Dearmor authenticates and decrypts a whole packet received from a peer in place and decodes
+// it, the payload aliases b
+func Dearmor(b []byte, key *[ztcrypto.SymmetricKeyLen]byte) (*Packet, error) {
+	if len(b) < ZT_PROTO_MIN_PACKET_LENGTH {
+		return nil, ErrPacketTooShort
+	}
+	if err := ztcrypto.DearmorPacket(b, key); err != nil {
+		return nil, err
+	}
+	p := &Packet{}
+	if _, err := p.Deserialize(b); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ArmorHello wraps and armors a HELLO for dest, encrypting the moon list as ZeroTier does
+func ArmorHello(h *Hello, packetID uint64, dest node.ZtAddress, key *[ztcrypto.SymmetricKeyLen]byte) ([]byte, error) {
+	p, err := h.Packet(packetID, dest)
+	if err != nil {
+		return nil, err
+	}
+	b, err := p.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	var plain Hello
+	n, err := plain.Deserialize(p.Payload)
+	if err != nil {
+		return nil, err
+	}
+	if start := ZT_PROTO_MIN_PACKET_LENGTH + n; start < len(b) {
+		if err := ztcrypto.CryptPacketField(b, start, len(b)-start, key); err != nil {
+			return nil, err
+		}
+	}
+	if err := ztcrypto.ArmorPacket(b, key, false); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// DearmorHello authenticates a HELLO in place and decodes it including the moon list. The key
+// comes from the identity in the clear part, which can be read beforehand with Hello.Deserialize.
+func DearmorHello(b []byte, key *[ztcrypto.SymmetricKeyLen]byte) (*Packet, *Hello, error) {
+	p, err := Dearmor(b, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.Verb != ZT_PROTO_VERB_HELLO {
+		return nil, nil, ErrUnexpectedVerb
+	}
+	h := &Hello{}
+	n, err := h.Deserialize(p.Payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	if start := ZT_PROTO_MIN_PACKET_LENGTH + n; start < len(b) {
+		if err := ztcrypto.CryptPacketField(b, start, len(b)-start, key); err != nil {
+			return nil, nil, err
+		}
+		if _, err := h.DeserializeMoons(p.Payload[n:]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return p, h, nil
+}
@@ -0,0 +1,20 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package protocol
+
+import "errors"
+
+var (
+	ErrPacketTooShort          = errors.New("packet shorter than its header")
+	ErrPacketTooLong           = errors.New("packet longer than the protocol allows")
+	ErrNotFragment             = errors.New("datagram is not a packet fragment")
+	ErrInvalidFragment         = errors.New("fragment number or count out of range")
+	ErrNotMarkedFragmented     = errors.New("packet exceeds the MTU but is not marked fragmented")
+	ErrPayloadTooShort         = errors.New("payload shorter than its verb requires")
+	ErrUnexpectedVerb          = errors.New("packet verb does not match")
+	ErrMissingIdentity         = errors.New("hello has no identity")
+	ErrUnsupportedProtoVersion = errors.New("peer protocol version too old")
+)
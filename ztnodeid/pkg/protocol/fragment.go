@@ -0,0 +1,228 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package protocol
+
+import (
+	"encoding/binary"
+	"time"
+	"ztnodeid/pkg/node"
+)
+
+// fragment layout, fragment 0 is the head of the packet itself so fragments are numbered from 1
+const (
+	ZT_PACKET_FRAGMENT_IDX_PACKET_ID          = 0
+	ZT_PACKET_FRAGMENT_IDX_DEST               = 8
+	ZT_PACKET_FRAGMENT_IDX_FRAGMENT_INDICATOR = 13
+	ZT_PACKET_FRAGMENT_IDX_FRAGMENT_NO        = 14
+	ZT_PACKET_FRAGMENT_IDX_HOPS               = 15
+	ZT_PACKET_FRAGMENT_IDX_PAYLOAD            = 16
+
+	// ZT_PACKET_FRAGMENT_INDICATOR takes the place of the first source address byte, no address
+	// starts with it
+	ZT_PACKET_FRAGMENT_INDICATOR = node.ZT_ADDRESS_RESERVED_PREFIX
+	// ZT_PROTO_MIN_FRAGMENT_LENGTH is a fragment header with an empty payload
+	ZT_PROTO_MIN_FRAGMENT_LENGTH = ZT_PACKET_FRAGMENT_IDX_PAYLOAD
+	// ZT_MAX_PACKET_FRAGMENTS is the most fragments of a packet, head included. The 4-bit count
+	// could describe 15 but ZeroTier drops anything above this.
+	ZT_MAX_PACKET_FRAGMENTS = 11
+	// ZT_RX_QUEUE_SIZE is how many incomplete packets are kept, a new one replaces the oldest
+	ZT_RX_QUEUE_SIZE = 32
+
+	// ZT_FRAGMENTED_PACKET_RECEIVE_TIMEOUT is how long an incomplete packet is kept
+	ZT_FRAGMENTED_PACKET_RECEIVE_TIMEOUT = 500 * time.Millisecond
+)
+
+// Fragment is a continuation of a packet that did not fit the MTU
+type Fragment struct {
+	PacketID    uint64
+	Destination node.ZtAddress
+	// Number is 1 for the first fragment after the head, Total counts the head too
+	Number  uint8
+	Total   uint8
+	Hops    uint8
+	Payload []byte
+}
+
+// IsFragment tells fragments from packets by the indicator in place of the source address
+func IsFragment(b []byte) bool {
+	return len(b) >= ZT_PROTO_MIN_FRAGMENT_LENGTH && b[ZT_PACKET_FRAGMENT_IDX_FRAGMENT_INDICATOR] == ZT_PACKET_FRAGMENT_INDICATOR
+}
+
+// Deserialize decodes a fragment, the payload aliases b
+func (f *Fragment) Deserialize(b []byte) (int, error) {
+	if !IsFragment(b) {
+		return 0, ErrNotFragment
+	}
+	f.PacketID = binary.BigEndian.Uint64(b[ZT_PACKET_FRAGMENT_IDX_PACKET_ID:])
+	f.Destination, _ = node.NewZtAddressFromBytes(b[ZT_PACKET_FRAGMENT_IDX_DEST:])
+	f.Total = b[ZT_PACKET_FRAGMENT_IDX_FRAGMENT_NO] >> 4
+	f.Number = b[ZT_PACKET_FRAGMENT_IDX_FRAGMENT_NO] & 0x0f
+	f.Hops = b[ZT_PACKET_FRAGMENT_IDX_HOPS] & ZT_PROTO_FLAGS_HOPS_MASK
+	if f.Number == 0 || f.Number >= f.Total || f.Total > ZT_MAX_PACKET_FRAGMENTS {
+		return 0, ErrInvalidFragment
+	}
+	f.Payload = b[ZT_PACKET_FRAGMENT_IDX_PAYLOAD:]
+	return len(b), nil
+}
+
+// Serialize encodes the fragment header followed by the payload
+func (f *Fragment) Serialize() ([]byte, error) {
+	if f.Number == 0 || f.Number >= f.Total || f.Total > ZT_MAX_PACKET_FRAGMENTS {
+		return nil, ErrInvalidFragment
+	}
+	buf := make([]byte, 0, ZT_PROTO_MIN_FRAGMENT_LENGTH+len(f.Payload))
+	buf = binary.BigEndian.AppendUint64(buf, f.PacketID)
+	dest := f.Destination.Bytes()
+	buf = append(buf, dest[:]...)
+	buf = append(buf, ZT_PACKET_FRAGMENT_INDICATOR, f.Total<<4|f.Number, f.Hops&ZT_PROTO_FLAGS_HOPS_MASK)
+	return append(buf, f.Payload...), nil
+}
+
+// Fragmentize splits a serialized, usually armored, packet into datagrams of at most mtu bytes:
+// the head followed by fragments. The packet must already carry ZT_PROTO_FLAG_FRAGMENTED if it
+// does not fit, the flag is covered by the MAC.
+func Fragmentize(packet []byte, mtu int) ([][]byte, error) {
+	if len(packet) < ZT_PROTO_MIN_PACKET_LENGTH {
+		return nil, ErrPacketTooShort
+	}
+	if len(packet) <= mtu {
+		return [][]byte{packet}, nil
+	}
+	if packet[ZT_PACKET_IDX_FLAGS]&ZT_PROTO_FLAG_FRAGMENTED == 0 {
+		return nil, ErrNotMarkedFragmented
+	}
+	chunk := mtu - ZT_PROTO_MIN_FRAGMENT_LENGTH
+	if mtu < ZT_PROTO_MIN_PACKET_LENGTH || chunk <= 0 {
+		return nil, ErrInvalidFragment
+	}
+	rest := packet[mtu:]
+	total := 1 + (len(rest)+chunk-1)/chunk
+	if total > ZT_MAX_PACKET_FRAGMENTS {
+		return nil, ErrPacketTooLong
+	}
+	h := &Header{}
+	if _, err := h.Deserialize(packet); err != nil {
+		return nil, err
+	}
+	out := [][]byte{packet[:mtu]}
+	for no := 1; len(rest) > 0; no++ {
+		n := min(chunk, len(rest))
+		f := &Fragment{PacketID: h.PacketID, Destination: h.Destination, Number: uint8(no), Total: uint8(total), Payload: rest[:n]}
+		b, err := f.Serialize()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+		rest = rest[n:]
+	}
+	return out, nil
+}
+
+type pendingPacket struct {
+	head      []byte
+	fragments [ZT_MAX_PACKET_FRAGMENTS][]byte
+	total     uint8
+	have      int
+	created   time.Time
+}
+
+// Assembler reassembles fragmented packets, it is not safe for concurrent use. At most
+// ZT_RX_QUEUE_SIZE packets are in flight, so untrusted datagrams cannot grow it without bound.
+type Assembler struct {
+	// Timeout defaults to ZT_FRAGMENTED_PACKET_RECEIVE_TIMEOUT
+	Timeout time.Duration
+	pending map[uint64]*pendingPacket
+}
+
+func NewAssembler() *Assembler {
+	return &Assembler{Timeout: ZT_FRAGMENTED_PACKET_RECEIVE_TIMEOUT, pending: make(map[uint64]*pendingPacket)}
+}
+
+// Add takes a received datagram and returns the whole packet once every part arrived. Packets
+// that are not fragmented are returned as is. Incomplete packets older than Timeout are dropped.
+func (a *Assembler) Add(datagram []byte, now time.Time) ([]byte, bool, error) {
+	a.expire(now)
+	if IsFragment(datagram) {
+		f := &Fragment{}
+		if _, err := f.Deserialize(datagram); err != nil {
+			return nil, false, err
+		}
+		p := a.get(f.PacketID, now)
+		if p.total != 0 && p.total != f.Total {
+			delete(a.pending, f.PacketID)
+			return nil, false, ErrInvalidFragment
+		}
+		p.total = f.Total
+		if p.fragments[f.Number] == nil {
+			p.fragments[f.Number] = append([]byte(nil), f.Payload...)
+			p.have++
+		}
+		return a.complete(f.PacketID, p)
+	}
+	h := &Header{}
+	if _, err := h.Deserialize(datagram); err != nil {
+		return nil, false, err
+	}
+	if !h.Fragmented {
+		return datagram, true, nil
+	}
+	p := a.get(h.PacketID, now)
+	if p.head == nil {
+		p.head = append([]byte(nil), datagram...)
+	}
+	return a.complete(h.PacketID, p)
+}
+
+// Pending returns the number of incomplete packets
+func (a *Assembler) Pending() int {
+	return len(a.pending)
+}
+
+func (a *Assembler) get(id uint64, now time.Time) *pendingPacket {
+	p, ok := a.pending[id]
+	if !ok {
+		if len(a.pending) >= ZT_RX_QUEUE_SIZE {
+			a.evictOldest()
+		}
+		p = &pendingPacket{created: now}
+		a.pending[id] = p
+	}
+	return p
+}
+
+func (a *Assembler) complete(id uint64, p *pendingPacket) ([]byte, bool, error) {
+	if p.head == nil || p.total == 0 || p.have != int(p.total)-1 {
+		return nil, false, nil
+	}
+	delete(a.pending, id)
+	packet := p.head
+	for _, f := range p.fragments[1:p.total] {
+		packet = append(packet, f...)
+	}
+	if len(packet) > ZT_PROTO_MAX_PACKET_LENGTH {
+		return nil, false, ErrPacketTooLong
+	}
+	return packet, true, nil
+}
+
+func (a *Assembler) evictOldest() {
+	var oldest uint64
+	var created time.Time
+	for id, p := range a.pending {
+		if created.IsZero() || p.created.Before(created) {
+			oldest, created = id, p.created
+		}
+	}
+	delete(a.pending, oldest)
+}
+
+func (a *Assembler) expire(now time.Time) {
+	for id, p := range a.pending {
+		if now.Sub(p.created) > a.Timeout {
+			delete(a.pending, id)
+		}
+	}
+}
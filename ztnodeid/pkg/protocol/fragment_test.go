@@ -0,0 +1,224 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+	"ztnodeid/pkg/node"
+)
+
+const testMTU = 100
+
+var testEpoch = time.UnixMilli(1700000000000)
+
+// fragmentedPacket returns a packet of size bytes marked fragmented and its datagrams at testMTU
+func fragmentedPacket(t *testing.T, id uint64, size int) ([]byte, [][]byte) {
+	t.Helper()
+	p := &Packet{
+		Header: Header{
+			PacketID:    id,
+			Destination: node.ZtAddress(0x0102030405),
+			Source:      node.ZtAddress(0x0a0b0c0d0e),
+			Fragmented:  true,
+			Verb:        ZT_PROTO_VERB_NOP,
+		},
+		Payload: make([]byte, size-ZT_PROTO_MIN_PACKET_LENGTH),
+	}
+	for i := range p.Payload {
+		p.Payload[i] = byte(i)
+	}
+	packet, err := p.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	datagrams, err := Fragmentize(packet, testMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet, datagrams
+}
+
+func TestAssemblerReassembles(t *testing.T) {
+	tests := []struct {
+		name  string
+		order []int
+	}{
+		{"in order", []int{0, 1, 2, 3}},
+		{"head last", []int{1, 2, 3, 0}},
+		{"reversed", []int{3, 2, 1, 0}},
+		{"shuffled", []int{2, 0, 3, 1}},
+		{"duplicate fragment", []int{1, 1, 0, 2, 2, 3}},
+		{"duplicate head", []int{0, 0, 3, 1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packet, datagrams := fragmentedPacket(t, 42, 3*testMTU)
+			if len(datagrams) != 4 {
+				t.Fatalf("got %d datagrams, want 4", len(datagrams))
+			}
+			a := NewAssembler()
+			for i, idx := range tt.order {
+				got, ok, err := a.Add(datagrams[idx], testEpoch)
+				if err != nil {
+					t.Fatalf("datagram %d: %v", idx, err)
+				}
+				if last := i == len(tt.order)-1; ok != last {
+					t.Fatalf("datagram %d: complete %v, want %v", idx, ok, last)
+				}
+				if ok && !bytes.Equal(got, packet) {
+					t.Fatal("reassembled packet differs")
+				}
+			}
+			if a.Pending() != 0 {
+				t.Errorf("%d packets still pending", a.Pending())
+			}
+		})
+	}
+}
+
+func TestAssemblerPassesWholePackets(t *testing.T) {
+	p := &Packet{Header: Header{PacketID: 1, Destination: 0x0102030405, Source: 0x0a0b0c0d0e, Verb: ZT_PROTO_VERB_NOP}}
+	packet, err := p.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok, err := NewAssembler().Add(packet, testEpoch)
+	if err != nil || !ok || !bytes.Equal(got, packet) {
+		t.Errorf("got %x %v %v, want the packet as is", got, ok, err)
+	}
+}
+
+func TestAssemblerRejectsBadFragments(t *testing.T) {
+	_, datagrams := fragmentedPacket(t, 42, 3*testMTU)
+	withCount := func(total uint8, no uint8) []byte {
+		b := bytes.Clone(datagrams[1])
+		b[ZT_PACKET_FRAGMENT_IDX_FRAGMENT_NO] = total<<4 | no
+		return b
+	}
+	tests := []struct {
+		name     string
+		datagram []byte
+	}{
+		{"more than ZT_MAX_PACKET_FRAGMENTS", withCount(ZT_MAX_PACKET_FRAGMENTS+1, 1)},
+		{"largest 4-bit count", withCount(15, 14)},
+		{"number zero", withCount(4, 0)},
+		{"number not below total", withCount(4, 4)},
+	}
+	for _, tt := range tests {
+		a := NewAssembler()
+		if _, _, err := a.Add(tt.datagram, testEpoch); !errors.Is(err, ErrInvalidFragment) {
+			t.Errorf("%s: %v, want ErrInvalidFragment", tt.name, err)
+		}
+		if a.Pending() != 0 {
+			t.Errorf("%s: rejected fragment left %d packets pending", tt.name, a.Pending())
+		}
+	}
+
+	// fragments of one packet disagreeing on the count drop it
+	a := NewAssembler()
+	if _, _, err := a.Add(datagrams[1], testEpoch); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.Add(withCount(3, 2), testEpoch); !errors.Is(err, ErrInvalidFragment) {
+		t.Errorf("changed count: %v, want ErrInvalidFragment", err)
+	}
+	if a.Pending() != 0 {
+		t.Errorf("changed count left %d packets pending", a.Pending())
+	}
+
+	f := &Fragment{PacketID: 1, Number: 1, Total: ZT_MAX_PACKET_FRAGMENTS + 1}
+	if _, err := f.Serialize(); !errors.Is(err, ErrInvalidFragment) {
+		t.Errorf("Serialize of %d fragments: %v, want ErrInvalidFragment", f.Total, err)
+	}
+}
+
+func TestFragmentizeLimit(t *testing.T) {
+	chunk := testMTU - ZT_PROTO_MIN_FRAGMENT_LENGTH
+	_, datagrams := fragmentedPacket(t, 1, testMTU+(ZT_MAX_PACKET_FRAGMENTS-1)*chunk)
+	if len(datagrams) != ZT_MAX_PACKET_FRAGMENTS {
+		t.Fatalf("got %d datagrams, want %d", len(datagrams), ZT_MAX_PACKET_FRAGMENTS)
+	}
+	p := &Packet{Header: Header{PacketID: 2, Destination: 0x0102030405, Source: 0x0a0b0c0d0e, Fragmented: true}, Payload: make([]byte, testMTU+(ZT_MAX_PACKET_FRAGMENTS-1)*chunk+1-ZT_PROTO_MIN_PACKET_LENGTH)}
+	packet, err := p.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Fragmentize(packet, testMTU); !errors.Is(err, ErrPacketTooLong) {
+		t.Errorf("Fragmentize into %d datagrams: %v, want ErrPacketTooLong", ZT_MAX_PACKET_FRAGMENTS+1, err)
+	}
+	if ZT_PROTO_MAX_PACKET_LENGTH != ZT_MAX_PACKET_FRAGMENTS*ZT_DEFAULT_PHYSMTU {
+		t.Errorf("ZT_PROTO_MAX_PACKET_LENGTH is %d", ZT_PROTO_MAX_PACKET_LENGTH)
+	}
+}
+
+func TestAssemblerQueueBound(t *testing.T) {
+	a := NewAssembler()
+	const sent = ZT_RX_QUEUE_SIZE + 8
+	all := make([][][]byte, sent)
+	for i := range all {
+		_, all[i] = fragmentedPacket(t, uint64(i+1), 3*testMTU)
+		if _, _, err := a.Add(all[i][0], testEpoch.Add(time.Duration(i)*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+		if a.Pending() > ZT_RX_QUEUE_SIZE {
+			t.Fatalf("%d packets pending after %d heads", a.Pending(), i+1)
+		}
+	}
+	if a.Pending() != ZT_RX_QUEUE_SIZE {
+		t.Fatalf("%d packets pending, want %d", a.Pending(), ZT_RX_QUEUE_SIZE)
+	}
+	now := testEpoch.Add(sent * time.Millisecond)
+	// the oldest head was evicted, its fragments cannot complete it
+	for _, d := range all[0][1:] {
+		if _, ok, _ := a.Add(d, now); ok {
+			t.Fatal("evicted packet completed")
+		}
+	}
+	// the newest is still there
+	var ok bool
+	for _, d := range all[sent-1][1:] {
+		if _, ok, _ = a.Add(d, now); ok {
+			break
+		}
+	}
+	if !ok {
+		t.Error("newest packet did not complete")
+	}
+}
+
+func TestAssemblerExpiry(t *testing.T) {
+	tests := []struct {
+		name     string
+		delay    time.Duration
+		complete bool
+	}{
+		{"within timeout", ZT_FRAGMENTED_PACKET_RECEIVE_TIMEOUT, true},
+		{"after timeout", ZT_FRAGMENTED_PACKET_RECEIVE_TIMEOUT + time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		_, datagrams := fragmentedPacket(t, 7, 3*testMTU)
+		a := NewAssembler()
+		if _, _, err := a.Add(datagrams[0], testEpoch); err != nil {
+			t.Fatal(err)
+		}
+		var ok bool
+		for _, d := range datagrams[1:] {
+			var err error
+			if _, ok, err = a.Add(d, testEpoch.Add(tt.delay)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if ok != tt.complete {
+			t.Errorf("%s: complete %v, want %v", tt.name, ok, tt.complete)
+		}
+		if !tt.complete && a.Pending() != 1 {
+			t.Errorf("%s: %d packets pending, want only the late fragments", tt.name, a.Pending())
+		}
+	}
+}
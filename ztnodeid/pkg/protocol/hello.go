@@ -0,0 +1,312 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package protocol
+
+import (
+	secrand "crypto/rand"
+	"encoding/binary"
+	"ztnodeid/pkg/node"
+)
+
+// software version announced in HELLO and OK(HELLO), the release the packet format follows
+const (
+	ZEROTIER_ONE_VERSION_MAJOR    = 1
+	ZEROTIER_ONE_VERSION_MINOR    = 12
+	ZEROTIER_ONE_VERSION_REVISION = 2
+)
+
+// Hello is the payload of HELLO. The moons are encrypted with the shared key on the wire, see
+// ArmorHello and DearmorHello.
+type Hello struct {
+	ProtoVersion uint8
+	Major        uint8
+	Minor        uint8
+	Revision     uint16
+	// Timestamp is echoed back in OK(HELLO) to measure latency
+	Timestamp uint64
+	Identity  *node.ZtIdentity
+	// Destination is the physical address the packet is sent to, nil when unknown
+	Destination     *node.ZtNodeInetAddr
+	PlanetID        node.ZtWorldID
+	PlanetTimestamp uint64
+	// Moons the sender has or wants, a wanted moon has a zero timestamp
+	Moons []HelloMoon
+}
+
+// HelloMoon announces a moon in HELLO
+type HelloMoon struct {
+	Type      node.ZtWorldType
+	ID        node.ZtWorldID
+	Timestamp uint64
+}
+
+// helloMoonLen is type, ID and timestamp
+const helloMoonLen = 1 + 8 + 8
+
+// OkHello is the payload of OK in reply to HELLO
+type OkHello struct {
+	InRe
+	// Timestamp echoes Hello.Timestamp
+	Timestamp    uint64
+	ProtoVersion uint8
+	Major        uint8
+	Minor        uint8
+	Revision     uint16
+	// Destination is the physical address the HELLO was received from
+	Destination *node.ZtNodeInetAddr
+	// Worlds are updates to the planet or moons the sender announced, newer than what it has
+	Worlds []*node.ZtWorld
+}
+
+// NewPacketID returns a random packet ID
+func NewPacketID() (uint64, error) {
+	var buf [8]byte
+	if _, err := secrand.Read(buf[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+// NewHello fills in the version this package speaks
+func NewHello(id *node.ZtIdentity, dest *node.ZtNodeInetAddr, planetID node.ZtWorldID, planetTimestamp uint64, now uint64) *Hello {
+	return &Hello{
+		ProtoVersion:    ZT_PROTO_VERSION,
+		Major:           ZEROTIER_ONE_VERSION_MAJOR,
+		Minor:           ZEROTIER_ONE_VERSION_MINOR,
+		Revision:        ZEROTIER_ONE_VERSION_REVISION,
+		Timestamp:       now,
+		Identity:        id,
+		Destination:     dest,
+		PlanetID:        planetID,
+		PlanetTimestamp: planetTimestamp,
+	}
+}
+
+// Serialize encodes a HELLO payload, only the public key of the identity is included. The moon
+// list is written in clear, ArmorHello encrypts it.
+func (h *Hello) Serialize() ([]byte, error) {
+	if h.Identity == nil {
+		return nil, ErrMissingIdentity
+	}
+	buf := []byte{h.ProtoVersion, h.Major, h.Minor}
+	buf = binary.BigEndian.AppendUint16(buf, h.Revision)
+	buf = binary.BigEndian.AppendUint64(buf, h.Timestamp)
+	idData, err := h.Identity.Serialize(false)
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, idData...)
+	addr, err := h.Destination.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, addr...)
+	buf = binary.BigEndian.AppendUint64(buf, h.PlanetID)
+	buf = binary.BigEndian.AppendUint64(buf, h.PlanetTimestamp)
+	if len(h.Moons) > 0 {
+		if len(h.Moons) > 0xffff {
+			return nil, node.ErrSerializedDataTooLarge
+		}
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(h.Moons)))
+		for _, m := range h.Moons {
+			buf = append(buf, m.Type)
+			buf = binary.BigEndian.AppendUint64(buf, m.ID)
+			buf = binary.BigEndian.AppendUint64(buf, m.Timestamp)
+		}
+	}
+	return buf, nil
+}
+
+// Deserialize decodes the clear fields of a HELLO payload and returns their length, the encrypted
+// moon list follows, see DeserializeMoons. Peers older than 1.2 stop after the identity, the
+// remaining fields are then left zero.
+func (h *Hello) Deserialize(b []byte) (int, error) {
+	const versionLen = 1 + 1 + 1 + 2 + 8
+	if len(b) < versionLen {
+		return 0, ErrPayloadTooShort
+	}
+	res := Hello{
+		ProtoVersion: b[0],
+		Major:        b[1],
+		Minor:        b[2],
+		Revision:     binary.BigEndian.Uint16(b[3:]),
+		Timestamp:    binary.BigEndian.Uint64(b[5:]),
+		Identity:     &node.ZtIdentity{},
+	}
+	if res.ProtoVersion < ZT_PROTO_VERSION_MIN {
+		return 0, ErrUnsupportedProtoVersion
+	}
+	p := versionLen
+	n, err := res.Identity.Deserialize(b[p:])
+	if err != nil {
+		return 0, err
+	}
+	p += n
+	if p < len(b) {
+		addr := &node.ZtNodeInetAddr{}
+		n, err := addr.Deserialize(b[p:])
+		if err != nil {
+			return 0, err
+		}
+		p += n
+		if addr.IP != nil {
+			res.Destination = addr
+		}
+	}
+	if p+16 <= len(b) {
+		res.PlanetID = binary.BigEndian.Uint64(b[p:])
+		res.PlanetTimestamp = binary.BigEndian.Uint64(b[p+8:])
+		p += 16
+	}
+	*h = res
+	return p, nil
+}
+
+// DeserializeMoons decodes the moon list following the clear fields once it is decrypted
+func (h *Hello) DeserializeMoons(b []byte) (int, error) {
+	if len(b) == 0 {
+		h.Moons = nil
+		return 0, nil
+	}
+	if len(b) < 2 {
+		return 0, ErrPayloadTooShort
+	}
+	count := int(binary.BigEndian.Uint16(b))
+	p := 2
+	if len(b) < p+count*helloMoonLen {
+		return 0, ErrPayloadTooShort
+	}
+	moons := make([]HelloMoon, 0, count)
+	for i := 0; i < count; i++ {
+		moons = append(moons, HelloMoon{
+			Type:      b[p],
+			ID:        binary.BigEndian.Uint64(b[p+1:]),
+			Timestamp: binary.BigEndian.Uint64(b[p+9:]),
+		})
+		p += helloMoonLen
+	}
+	h.Moons = moons
+	return p, nil
+}
+
+// Packet wraps the HELLO for dest. ZeroTier sends HELLO authenticated but unencrypted since the
+// receiver needs the identity inside to derive the key, so armor it with encrypt unset. Use
+// ArmorHello instead when announcing moons.
+func (h *Hello) Packet(packetID uint64, dest node.ZtAddress) (*Packet, error) {
+	payload, err := h.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	return &Packet{
+		Header: Header{
+			PacketID:    packetID,
+			Destination: dest,
+			Source:      h.Identity.Address,
+			Cipher:      ZT_PROTO_CIPHER_SUITE__C25519_POLY1305_NONE,
+			Verb:        ZT_PROTO_VERB_HELLO,
+		},
+		Payload: payload,
+	}, nil
+}
+
+// Serialize encodes an OK(HELLO) payload, InRe.Verb is set to HELLO
+func (o *OkHello) Serialize() ([]byte, error) {
+	buf := InRe{Verb: ZT_PROTO_VERB_HELLO, PacketID: o.PacketID}.Serialize()
+	buf = binary.BigEndian.AppendUint64(buf, o.Timestamp)
+	buf = append(buf, o.ProtoVersion, o.Major, o.Minor)
+	buf = binary.BigEndian.AppendUint16(buf, o.Revision)
+	addr, err := o.Destination.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, addr...)
+	var worlds []byte
+	for _, w := range o.Worlds {
+		wData, err := w.Serialize(false, w.Signature)
+		if err != nil {
+			return nil, err
+		}
+		worlds = append(worlds, wData...)
+	}
+	if len(worlds) > 0xffff {
+		return nil, node.ErrSerializedDataTooLarge
+	}
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(worlds)))
+	return append(buf, worlds...), nil
+}
+
+// Deserialize decodes an OK(HELLO) payload including the InRe fields. World signatures are not
+// checked, see ZtWorld.Verify.
+func (o *OkHello) Deserialize(b []byte) (int, error) {
+	res := OkHello{}
+	p, err := res.InRe.Deserialize(b)
+	if err != nil {
+		return 0, err
+	}
+	if res.Verb != ZT_PROTO_VERB_HELLO {
+		return 0, ErrUnexpectedVerb
+	}
+	const versionLen = 8 + 1 + 1 + 1 + 2
+	if len(b) < p+versionLen {
+		return 0, ErrPayloadTooShort
+	}
+	res.Timestamp = binary.BigEndian.Uint64(b[p:])
+	res.ProtoVersion = b[p+8]
+	res.Major = b[p+9]
+	res.Minor = b[p+10]
+	res.Revision = binary.BigEndian.Uint16(b[p+11:])
+	p += versionLen
+	if p < len(b) {
+		addr := &node.ZtNodeInetAddr{}
+		n, err := addr.Deserialize(b[p:])
+		if err != nil {
+			return 0, err
+		}
+		p += n
+		if addr.IP != nil {
+			res.Destination = addr
+		}
+	}
+	if p+2 <= len(b) {
+		worldsLen := int(binary.BigEndian.Uint16(b[p:]))
+		p += 2
+		if len(b) < p+worldsLen {
+			return 0, ErrPayloadTooShort
+		}
+		worlds := b[p : p+worldsLen]
+		for len(worlds) > 0 {
+			w := &node.ZtWorld{}
+			n, err := w.Deserialize(worlds)
+			if err != nil {
+				return 0, err
+			}
+			worlds = worlds[n:]
+			res.Worlds = append(res.Worlds, w)
+		}
+		p += worldsLen
+	}
+	*o = res
+	return p, nil
+}
+
+// Packet wraps the OK(HELLO) replying to hello, sent from self. It is armored with encrypt set.
+func (o *OkHello) Packet(packetID uint64, hello *Packet, self node.ZtAddress) (*Packet, error) {
+	o.InRe = InRe{Verb: ZT_PROTO_VERB_HELLO, PacketID: hello.PacketID}
+	payload, err := o.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	return &Packet{
+		Header: Header{
+			PacketID:    packetID,
+			Destination: hello.Source,
+			Source:      self,
+			Cipher:      ZT_PROTO_CIPHER_SUITE__C25519_POLY1305_SALSA2012,
+			Verb:        ZT_PROTO_VERB_OK,
+		},
+		Payload: payload,
+	}, nil
+}
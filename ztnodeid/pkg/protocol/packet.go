@@ -0,0 +1,166 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+// Package protocol encodes and decodes ZeroTier VL1 packets as sent over UDP.
+//
+// Code reproduced from https://github.com/zerotier/ZeroTierOne/blob/e0a3291235230352148d5d30e51b341bfd9ad458/node/Packet.hpp
+package protocol
+
+import (
+	"encoding/binary"
+	"ztnodeid/pkg/node"
+)
+
+// packet header layout, everything from ZT_PACKET_IDX_VERB on is encrypted when the cipher
+// suite says so
+const (
+	ZT_PACKET_IDX_IV      = 0
+	ZT_PACKET_IDX_DEST    = 8
+	ZT_PACKET_IDX_SOURCE  = 13
+	ZT_PACKET_IDX_FLAGS   = 18
+	ZT_PACKET_IDX_MAC     = 19
+	ZT_PACKET_IDX_VERB    = 27
+	ZT_PACKET_IDX_PAYLOAD = 28
+
+	// ZT_PROTO_MIN_PACKET_LENGTH is the header including the verb
+	ZT_PROTO_MIN_PACKET_LENGTH = ZT_PACKET_IDX_PAYLOAD
+	// ZT_PROTO_MAX_PACKET_LENGTH is the largest packet ZeroTier sends or reassembles
+	ZT_PROTO_MAX_PACKET_LENGTH = ZT_MAX_PACKET_FRAGMENTS * ZT_DEFAULT_PHYSMTU
+	// ZT_DEFAULT_PHYSMTU is the UDP payload size ZeroTier splits packets at
+	ZT_DEFAULT_PHYSMTU = 1432
+)
+
+// flags byte, laid out as FFCCCHHH
+const (
+	// ZT_PROTO_FLAG_ENCRYPTED is deprecated, still set along with the Salsa20/12 cipher suite
+	// for pre-1.0.3 peers
+	ZT_PROTO_FLAG_ENCRYPTED = 0x80
+	// ZT_PROTO_FLAG_FRAGMENTED tells the receiver to expect fragments
+	ZT_PROTO_FLAG_FRAGMENTED = 0x40
+
+	ZT_PROTO_FLAGS_CIPHER_MASK = 0x38
+	ZT_PROTO_FLAGS_HOPS_MASK   = 0x07
+	// ZT_PROTO_MAX_HOPS is the largest hop count a packet is relayed with
+	ZT_PROTO_MAX_HOPS = 7
+)
+
+// cipher suites
+const (
+	ZT_PROTO_CIPHER_SUITE__C25519_POLY1305_NONE      = 0
+	ZT_PROTO_CIPHER_SUITE__C25519_POLY1305_SALSA2012 = 1
+	// ZT_PROTO_CIPHER_SUITE__NONE is only used over trusted paths
+	ZT_PROTO_CIPHER_SUITE__NONE         = 2
+	ZT_PROTO_CIPHER_SUITE__AES_GMAC_SIV = 3
+)
+
+// verb byte
+const (
+	// ZT_PROTO_VERB_FLAG_COMPRESSED marks an LZ4 compressed payload
+	ZT_PROTO_VERB_FLAG_COMPRESSED = 0x80
+	ZT_PROTO_VERB_MASK            = 0x1f
+)
+
+// Header is the plain part of a packet header plus the verb, which is only readable once the
+// packet is dearmored
+type Header struct {
+	// PacketID doubles as the IV of the packet cipher
+	PacketID    uint64
+	Destination node.ZtAddress
+	Source      node.ZtAddress
+	Fragmented  bool
+	Cipher      uint8
+	Hops        uint8
+	MAC         [8]byte
+	Verb        uint8
+	Compressed  bool
+}
+
+// Packet is a whole, possibly reassembled, packet
+type Packet struct {
+	Header
+	Payload []byte
+}
+
+// Flags returns the flags byte, setting the deprecated encrypted flag for Salsa20/12
+func (h *Header) Flags() uint8 {
+	f := (h.Cipher << 3) & ZT_PROTO_FLAGS_CIPHER_MASK
+	f |= h.Hops & ZT_PROTO_FLAGS_HOPS_MASK
+	if h.Fragmented {
+		f |= ZT_PROTO_FLAG_FRAGMENTED
+	}
+	if h.Cipher == ZT_PROTO_CIPHER_SUITE__C25519_POLY1305_SALSA2012 {
+		f |= ZT_PROTO_FLAG_ENCRYPTED
+	}
+	return f
+}
+
+// Deserialize reads the header from the first ZT_PROTO_MIN_PACKET_LENGTH bytes of b
+func (h *Header) Deserialize(b []byte) (int, error) {
+	if len(b) < ZT_PROTO_MIN_PACKET_LENGTH {
+		return 0, ErrPacketTooShort
+	}
+	h.PacketID = binary.BigEndian.Uint64(b[ZT_PACKET_IDX_IV:])
+	h.Destination, _ = node.NewZtAddressFromBytes(b[ZT_PACKET_IDX_DEST:])
+	h.Source, _ = node.NewZtAddressFromBytes(b[ZT_PACKET_IDX_SOURCE:])
+	flags := b[ZT_PACKET_IDX_FLAGS]
+	h.Fragmented = flags&ZT_PROTO_FLAG_FRAGMENTED != 0
+	h.Cipher = (flags & ZT_PROTO_FLAGS_CIPHER_MASK) >> 3
+	h.Hops = flags & ZT_PROTO_FLAGS_HOPS_MASK
+	copy(h.MAC[:], b[ZT_PACKET_IDX_MAC:ZT_PACKET_IDX_VERB])
+	h.Verb = b[ZT_PACKET_IDX_VERB] & ZT_PROTO_VERB_MASK
+	h.Compressed = b[ZT_PACKET_IDX_VERB]&ZT_PROTO_VERB_FLAG_COMPRESSED != 0
+	return ZT_PROTO_MIN_PACKET_LENGTH, nil
+}
+
+// Serialize returns the ZT_PROTO_MIN_PACKET_LENGTH header bytes
+func (h *Header) Serialize() []byte {
+	buf := make([]byte, 0, ZT_PROTO_MIN_PACKET_LENGTH)
+	buf = binary.BigEndian.AppendUint64(buf, h.PacketID)
+	dest, src := h.Destination.Bytes(), h.Source.Bytes()
+	buf = append(buf, dest[:]...)
+	buf = append(buf, src[:]...)
+	buf = append(buf, h.Flags())
+	buf = append(buf, h.MAC[:]...)
+	verb := h.Verb & ZT_PROTO_VERB_MASK
+	if h.Compressed {
+		verb |= ZT_PROTO_VERB_FLAG_COMPRESSED
+	}
+	return append(buf, verb)
+}
+
+// Deserialize decodes a whole packet, the payload aliases b
+func (p *Packet) Deserialize(b []byte) (int, error) {
+	if len(b) > ZT_PROTO_MAX_PACKET_LENGTH {
+		return 0, ErrPacketTooLong
+	}
+	n, err := p.Header.Deserialize(b)
+	if err != nil {
+		return 0, err
+	}
+	p.Payload = b[n:]
+	return len(b), nil
+}
+
+// Serialize encodes the header followed by the payload
+func (p *Packet) Serialize() ([]byte, error) {
+	if ZT_PROTO_MIN_PACKET_LENGTH+len(p.Payload) > ZT_PROTO_MAX_PACKET_LENGTH {
+		return nil, ErrPacketTooLong
+	}
+	return append(p.Header.Serialize(), p.Payload...), nil
+}
+
+// IncrementHops bumps the hop count of a serialized packet or fragment in place the way relays
+// do, it returns false once ZT_PROTO_MAX_HOPS is reached
+func IncrementHops(b []byte) bool {
+	idx := ZT_PACKET_IDX_FLAGS
+	if IsFragment(b) {
+		idx = ZT_PACKET_FRAGMENT_IDX_HOPS
+	}
+	if len(b) <= idx || b[idx]&ZT_PROTO_FLAGS_HOPS_MASK >= ZT_PROTO_MAX_HOPS {
+		return false
+	}
+	b[idx] = (b[idx] & ^uint8(ZT_PROTO_FLAGS_HOPS_MASK)) | ((b[idx] + 1) & ZT_PROTO_FLAGS_HOPS_MASK)
+	return true
+}
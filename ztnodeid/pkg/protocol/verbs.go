@@ -0,0 +1,158 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package protocol
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// protocol version spoken, peers older than ZT_PROTO_VERSION_MIN are ignored
+const (
+	ZT_PROTO_VERSION     = 12
+	ZT_PROTO_VERSION_MIN = 4
+)
+
+// verbs, the low 5 bits of the verb byte
+const (
+	ZT_PROTO_VERB_NOP                    = 0x00
+	ZT_PROTO_VERB_HELLO                  = 0x01
+	ZT_PROTO_VERB_ERROR                  = 0x02
+	ZT_PROTO_VERB_OK                     = 0x03
+	ZT_PROTO_VERB_WHOIS                  = 0x04
+	ZT_PROTO_VERB_RENDEZVOUS             = 0x05
+	ZT_PROTO_VERB_FRAME                  = 0x06
+	ZT_PROTO_VERB_EXT_FRAME              = 0x07
+	ZT_PROTO_VERB_ECHO                   = 0x08
+	ZT_PROTO_VERB_MULTICAST_LIKE         = 0x09
+	ZT_PROTO_VERB_NETWORK_CREDENTIALS    = 0x0a
+	ZT_PROTO_VERB_NETWORK_CONFIG_REQUEST = 0x0b
+	ZT_PROTO_VERB_NETWORK_CONFIG         = 0x0c
+	ZT_PROTO_VERB_MULTICAST_GATHER       = 0x0d
+	ZT_PROTO_VERB_MULTICAST_FRAME        = 0x0e
+	ZT_PROTO_VERB_PUSH_DIRECT_PATHS      = 0x10
+	ZT_PROTO_VERB_ACK                    = 0x12
+	ZT_PROTO_VERB_QOS_MEASUREMENT        = 0x13
+	ZT_PROTO_VERB_USER_MESSAGE           = 0x14
+	ZT_PROTO_VERB_REMOTE_TRACE           = 0x15
+)
+
+// error codes carried by ERROR
+const (
+	ZT_PROTO_ERROR_NONE                            = 0x00
+	ZT_PROTO_ERROR_INVALID_REQUEST                 = 0x01
+	ZT_PROTO_ERROR_BAD_PROTOCOL_VERSION            = 0x02
+	ZT_PROTO_ERROR_OBJ_NOT_FOUND                   = 0x03
+	ZT_PROTO_ERROR_IDENTITY_COLLISION              = 0x04
+	ZT_PROTO_ERROR_UNSUPPORTED_OPERATION           = 0x05
+	ZT_PROTO_ERROR_NEED_MEMBERSHIP_CERTIFICATE     = 0x06
+	ZT_PROTO_ERROR_NETWORK_ACCESS_DENIED_          = 0x07
+	ZT_PROTO_ERROR_UNWANTED_MULTICAST              = 0x08
+	ZT_PROTO_ERROR_NETWORK_AUTHENTICATION_REQUIRED = 0x09
+)
+
+var verbNames = map[uint8]string{
+	ZT_PROTO_VERB_NOP:                    "NOP",
+	ZT_PROTO_VERB_HELLO:                  "HELLO",
+	ZT_PROTO_VERB_ERROR:                  "ERROR",
+	ZT_PROTO_VERB_OK:                     "OK",
+	ZT_PROTO_VERB_WHOIS:                  "WHOIS",
+	ZT_PROTO_VERB_RENDEZVOUS:             "RENDEZVOUS",
+	ZT_PROTO_VERB_FRAME:                  "FRAME",
+	ZT_PROTO_VERB_EXT_FRAME:              "EXT_FRAME",
+	ZT_PROTO_VERB_ECHO:                   "ECHO",
+	ZT_PROTO_VERB_MULTICAST_LIKE:         "MULTICAST_LIKE",
+	ZT_PROTO_VERB_NETWORK_CREDENTIALS:    "NETWORK_CREDENTIALS",
+	ZT_PROTO_VERB_NETWORK_CONFIG_REQUEST: "NETWORK_CONFIG_REQUEST",
+	ZT_PROTO_VERB_NETWORK_CONFIG:         "NETWORK_CONFIG",
+	ZT_PROTO_VERB_MULTICAST_GATHER:       "MULTICAST_GATHER",
+	ZT_PROTO_VERB_MULTICAST_FRAME:        "MULTICAST_FRAME",
+	ZT_PROTO_VERB_PUSH_DIRECT_PATHS:      "PUSH_DIRECT_PATHS",
+	ZT_PROTO_VERB_ACK:                    "ACK",
+	ZT_PROTO_VERB_QOS_MEASUREMENT:        "QOS_MEASUREMENT",
+	ZT_PROTO_VERB_USER_MESSAGE:           "USER_MESSAGE",
+	ZT_PROTO_VERB_REMOTE_TRACE:           "REMOTE_TRACE",
+}
+
+var errorNames = map[uint8]string{
+	ZT_PROTO_ERROR_NONE:                            "NONE",
+	ZT_PROTO_ERROR_INVALID_REQUEST:                 "INVALID_REQUEST",
+	ZT_PROTO_ERROR_BAD_PROTOCOL_VERSION:            "BAD_PROTOCOL_VERSION",
+	ZT_PROTO_ERROR_OBJ_NOT_FOUND:                   "OBJ_NOT_FOUND",
+	ZT_PROTO_ERROR_IDENTITY_COLLISION:              "IDENTITY_COLLISION",
+	ZT_PROTO_ERROR_UNSUPPORTED_OPERATION:           "UNSUPPORTED_OPERATION",
+	ZT_PROTO_ERROR_NEED_MEMBERSHIP_CERTIFICATE:     "NEED_MEMBERSHIP_CERTIFICATE",
+	ZT_PROTO_ERROR_NETWORK_ACCESS_DENIED_:          "NETWORK_ACCESS_DENIED",
+	ZT_PROTO_ERROR_UNWANTED_MULTICAST:              "UNWANTED_MULTICAST",
+	ZT_PROTO_ERROR_NETWORK_AUTHENTICATION_REQUIRED: "NETWORK_AUTHENTICATION_REQUIRED",
+}
+
+// ErrorName returns the name of an ERROR code for logging, unknown codes are printed in hex
+func ErrorName(code uint8) string {
+	if name, ok := errorNames[code]; ok {
+		return name
+	}
+	return "0x" + strconv.FormatUint(uint64(code), 16)
+}
+
+// VerbName returns the name of a verb for logging, unknown verbs are printed in hex
+func VerbName(verb uint8) string {
+	if name, ok := verbNames[verb&ZT_PROTO_VERB_MASK]; ok {
+		return name
+	}
+	return "0x" + strconv.FormatUint(uint64(verb), 16)
+}
+
+// InRe starts the payload of OK and ERROR, naming the packet replied to
+type InRe struct {
+	Verb     uint8
+	PacketID uint64
+}
+
+// inReLen is the verb and the packet ID
+const inReLen = 1 + 8
+
+// Deserialize reads the verb and packet ID replied to
+func (r *InRe) Deserialize(b []byte) (int, error) {
+	if len(b) < inReLen {
+		return 0, ErrPayloadTooShort
+	}
+	r.Verb = b[0] & ZT_PROTO_VERB_MASK
+	r.PacketID = binary.BigEndian.Uint64(b[1:])
+	return inReLen, nil
+}
+
+// Serialize returns the verb and packet ID replied to
+func (r InRe) Serialize() []byte {
+	return binary.BigEndian.AppendUint64([]byte{r.Verb & ZT_PROTO_VERB_MASK}, r.PacketID)
+}
+
+// ErrorReply is the payload of ERROR, Payload depends on the code, e.g. the network ID for
+// ZT_PROTO_ERROR_NEED_MEMBERSHIP_CERTIFICATE
+type ErrorReply struct {
+	InRe
+	Code    uint8
+	Payload []byte
+}
+
+// Deserialize decodes an ERROR payload, Payload aliases b
+func (e *ErrorReply) Deserialize(b []byte) (int, error) {
+	n, err := e.InRe.Deserialize(b)
+	if err != nil {
+		return 0, err
+	}
+	if len(b) < n+1 {
+		return 0, ErrPayloadTooShort
+	}
+	e.Code = b[n]
+	e.Payload = b[n+1:]
+	return len(b), nil
+}
+
+// Serialize encodes an ERROR payload
+func (e *ErrorReply) Serialize() []byte {
+	return append(append(e.InRe.Serialize(), e.Code), e.Payload...)
+}
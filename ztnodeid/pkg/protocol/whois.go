@@ -0,0 +1,117 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package protocol
+
+import "ztnodeid/pkg/node"
+
+// Whois is the payload of WHOIS, the addresses whose identities are wanted
+type Whois struct {
+	Addresses []node.ZtAddress
+}
+
+// OkWhois is the payload of OK in reply to WHOIS. Unknown addresses are left out, there is no
+// ERROR for them.
+type OkWhois struct {
+	InRe
+	Identities []*node.ZtIdentity
+}
+
+// Serialize encodes a WHOIS payload
+func (w *Whois) Serialize() []byte {
+	buf := make([]byte, 0, len(w.Addresses)*node.ZT_ADDRESS_LENGTH)
+	for _, a := range w.Addresses {
+		b := a.Bytes()
+		buf = append(buf, b[:]...)
+	}
+	return buf
+}
+
+// Deserialize decodes a WHOIS payload, a trailing partial address is ignored
+func (w *Whois) Deserialize(b []byte) (int, error) {
+	if len(b) < node.ZT_ADDRESS_LENGTH {
+		return 0, ErrPayloadTooShort
+	}
+	w.Addresses = w.Addresses[:0]
+	p := 0
+	for ; p+node.ZT_ADDRESS_LENGTH <= len(b); p += node.ZT_ADDRESS_LENGTH {
+		a, err := node.NewZtAddressFromBytes(b[p:])
+		if err != nil {
+			return 0, err
+		}
+		w.Addresses = append(w.Addresses, a)
+	}
+	return p, nil
+}
+
+// Packet wraps the WHOIS from self for dest, armor it with encrypt set
+func (w *Whois) Packet(packetID uint64, dest node.ZtAddress, self node.ZtAddress) *Packet {
+	return &Packet{
+		Header: Header{
+			PacketID:    packetID,
+			Destination: dest,
+			Source:      self,
+			Cipher:      ZT_PROTO_CIPHER_SUITE__C25519_POLY1305_SALSA2012,
+			Verb:        ZT_PROTO_VERB_WHOIS,
+		},
+		Payload: w.Serialize(),
+	}
+}
+
+// Serialize encodes an OK(WHOIS) payload with the public identities, InRe.Verb is set to WHOIS
+func (o *OkWhois) Serialize() ([]byte, error) {
+	buf := InRe{Verb: ZT_PROTO_VERB_WHOIS, PacketID: o.PacketID}.Serialize()
+	for _, id := range o.Identities {
+		idData, err := id.Serialize(false)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, idData...)
+	}
+	return buf, nil
+}
+
+// Deserialize decodes an OK(WHOIS) payload including the InRe fields. Identities are not
+// validated, see ZtIdentity.LocallyValidate.
+func (o *OkWhois) Deserialize(b []byte) (int, error) {
+	res := OkWhois{}
+	p, err := res.InRe.Deserialize(b)
+	if err != nil {
+		return 0, err
+	}
+	if res.Verb != ZT_PROTO_VERB_WHOIS {
+		return 0, ErrUnexpectedVerb
+	}
+	for p < len(b) {
+		id := &node.ZtIdentity{}
+		n, err := id.Deserialize(b[p:])
+		if err != nil {
+			return 0, err
+		}
+		p += n
+		res.Identities = append(res.Identities, id)
+	}
+	*o = res
+	return p, nil
+}
+
+// Packet wraps the OK(WHOIS) replying to whois, sent from self. It is armored with encrypt set.
+func (o *OkWhois) Packet(packetID uint64, whois *Packet, self node.ZtAddress) (*Packet, error) {
+	o.InRe = InRe{Verb: ZT_PROTO_VERB_WHOIS, PacketID: whois.PacketID}
+	payload, err := o.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	return &Packet{
+		Header: Header{
+			PacketID:    packetID,
+			Destination: whois.Source,
+			Source:      self,
+			Cipher:      ZT_PROTO_CIPHER_SUITE__C25519_POLY1305_SALSA2012,
+			Verb:        ZT_PROTO_VERB_OK,
+		},
+		Payload: payload,
+	}, nil
+}
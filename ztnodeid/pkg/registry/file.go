@@ -0,0 +1,93 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package registry
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"ztnodeid/pkg/home"
+	"ztnodeid/pkg/node"
+)
+
+// File is a Registry backed by a text file holding one public identity per line, as in
+// identity.public. Blank lines and lines starting with # are skipped. The file is rewritten
+// atomically on every change.
+type File struct {
+	path string
+	// wmu serializes writes of the file
+	wmu sync.Mutex
+	mem *Memory
+}
+
+// OpenFile loads the registry at path, a missing file yields an empty registry. Every identity is
+// validated, which takes a while for large files.
+func OpenFile(path string) (*File, error) {
+	f := &File{path: path, mem: NewMemory()}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		id, err := node.ParseZtIdentity(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if err := f.mem.Put(id); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) Get(addr node.ZtAddress) (*node.ZtIdentity, error) {
+	return f.mem.Get(addr)
+}
+
+func (f *File) Put(id *node.ZtIdentity) error {
+	changed, err := f.mem.put(id)
+	if err != nil || !changed {
+		return err
+	}
+	return f.save()
+}
+
+func (f *File) Delete(addr node.ZtAddress) error {
+	if !f.mem.delete(addr) {
+		return nil
+	}
+	return f.save()
+}
+
+func (f *File) List() ([]*node.ZtIdentity, error) {
+	return f.mem.List()
+}
+
+func (f *File) save() error {
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	ids, _ := f.mem.List()
+	var buf bytes.Buffer
+	for _, id := range ids {
+		buf.WriteString(id.String())
+		buf.WriteByte('\n')
+	}
+	return home.WriteFileAtomic(f.path, buf.Bytes(), home.PublicFilePerm)
+}
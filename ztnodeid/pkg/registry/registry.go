@@ -0,0 +1,113 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+// Package registry maps ZeroTier addresses to the public identities they belong to, as roots do
+// to answer WHOIS.
+package registry
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"ztnodeid/pkg/node"
+)
+
+var (
+	ErrNotFound          = errors.New("no identity known for address")
+	ErrIdentityCollision = errors.New("another identity is known for this address")
+)
+
+// Registry stores public identities by address. Implementations validate identities before storing
+// them and are safe for concurrent use. Type 1 identities cannot be validated and are refused with
+// node.ErrUnsupportedIdentityType.
+type Registry interface {
+	// Get returns the identity of addr or ErrNotFound
+	Get(addr node.ZtAddress) (*node.ZtIdentity, error)
+	// Put stores the public part of id. Storing a known identity again is a no-op, a different
+	// identity for a known address fails with ErrIdentityCollision.
+	Put(id *node.ZtIdentity) error
+	// Delete forgets addr, unknown addresses are ignored
+	Delete(addr node.ZtAddress) error
+	// List returns every identity ordered by address
+	List() ([]*node.ZtIdentity, error)
+}
+
+// Memory is a Registry kept in memory
+type Memory struct {
+	mu  sync.RWMutex
+	ids map[node.ZtAddress]*node.ZtIdentity
+}
+
+func NewMemory() *Memory {
+	return &Memory{ids: make(map[node.ZtAddress]*node.ZtIdentity)}
+}
+
+func (m *Memory) Get(addr node.ZtAddress) (*node.ZtIdentity, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	id, ok := m.ids[addr]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return id, nil
+}
+
+func (m *Memory) Put(id *node.ZtIdentity) error {
+	_, err := m.put(id)
+	return err
+}
+
+// put reports whether the registry changed
+func (m *Memory) put(id *node.ZtIdentity) (bool, error) {
+	m.mu.RLock()
+	known, ok := m.ids[id.Address]
+	m.mu.RUnlock()
+	if ok {
+		return false, checkSame(known, id)
+	}
+	// validate outside the lock, the memory-hard hash takes a while
+	if err := id.LocallyValidate(); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if known, ok := m.ids[id.Address]; ok {
+		return false, checkSame(known, id)
+	}
+	m.ids[id.Address] = id.PublicOnly()
+	return true, nil
+}
+
+func (m *Memory) Delete(addr node.ZtAddress) error {
+	m.delete(addr)
+	return nil
+}
+
+// delete reports whether the registry changed
+func (m *Memory) delete(addr node.ZtAddress) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.ids[addr]
+	delete(m.ids, addr)
+	return ok
+}
+
+func (m *Memory) List() ([]*node.ZtIdentity, error) {
+	m.mu.RLock()
+	ids := make([]*node.ZtIdentity, 0, len(m.ids))
+	for _, id := range m.ids {
+		ids = append(ids, id)
+	}
+	m.mu.RUnlock()
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Address < ids[j].Address })
+	return ids, nil
+}
+
+func checkSame(known *node.ZtIdentity, id *node.ZtIdentity) error {
+	if known.PublicKeyString() != id.PublicKeyString() {
+		return ErrIdentityCollision
+	}
+	return nil
+}
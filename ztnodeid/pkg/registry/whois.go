@@ -0,0 +1,40 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package registry
+
+import (
+	"errors"
+	"ztnodeid/pkg/node"
+	"ztnodeid/pkg/protocol"
+)
+
+// Whois answers a dearmored WHOIS packet with the OK(WHOIS) self sends back, armor it with the key
+// of the asking peer. It returns nil when none of the addresses is known, ZeroTier stays silent
+// then.
+func Whois(r Registry, in *protocol.Packet, self node.ZtAddress) (*protocol.Packet, error) {
+	if in.Verb != protocol.ZT_PROTO_VERB_WHOIS {
+		return nil, protocol.ErrUnexpectedVerb
+	}
+	req := &protocol.Whois{}
+	if _, err := req.Deserialize(in.Payload); err != nil {
+		return nil, err
+	}
+	ok := &protocol.OkWhois{}
+	for _, addr := range req.Addresses {
+		id, err := r.Get(addr)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ok.Identities = append(ok.Identities, id)
+	}
+	if len(ok.Identities) == 0 {
+		return nil, nil
+	}
+	return ok.Packet(0, in, self)
+}
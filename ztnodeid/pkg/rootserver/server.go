@@ -0,0 +1,366 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+// Package rootserver is a minimal stand-in ZeroTier root for integration tests. It answers HELLO
+// with the world it serves, answers WHOIS from its identity registry and relays packets between
+// peers that said HELLO. It does not talk to upstream roots, join networks or speak AES-GMAC-SIV.
+package rootserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+	"ztnodeid/pkg/node"
+	"ztnodeid/pkg/protocol"
+	"ztnodeid/pkg/registry"
+	"ztnodeid/pkg/ztcrypto"
+)
+
+var (
+	ErrNotWorld = errors.New("data is not a planet or moon")
+)
+
+type Options struct {
+	// MTU splits replies larger than it, defaults to ZT_DEFAULT_PHYSMTU
+	MTU int
+	// Logger defaults to discarding
+	Logger *log.Logger
+	// Registry answers WHOIS, peers saying HELLO are added to it. Defaults to an empty in-memory
+	// registry.
+	Registry registry.Registry
+}
+
+type peer struct {
+	key      [ztcrypto.SymmetricKeyLen]byte
+	path     net.Addr
+	lastSeen time.Time
+}
+
+type Server struct {
+	self *node.ZtIdentity
+	opts Options
+
+	mu    sync.Mutex
+	world *node.ZtWorld
+	peers map[node.ZtAddress]*peer
+}
+
+// New returns a root speaking as id, which must hold its private key, and serving world, a signed
+// planet or moon as written by mkworld
+func New(id *node.ZtNormalNode, world []byte, opts Options) (*Server, error) {
+	if !id.HasPrivateKey() {
+		return nil, node.ErrNoPrivateKey
+	}
+	if opts.MTU <= 0 {
+		opts.MTU = protocol.ZT_DEFAULT_PHYSMTU
+	}
+	if opts.Logger == nil {
+		opts.Logger = log.New(io.Discard, "", 0)
+	}
+	if opts.Registry == nil {
+		opts.Registry = registry.NewMemory()
+	}
+	s := &Server{self: id.ToIdentity(), opts: opts, peers: make(map[node.ZtAddress]*peer)}
+	if err := s.SetWorld(world); err != nil {
+		return nil, err
+	}
+	if err := opts.Registry.Put(s.self); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetWorld replaces the world served from now on. It is not checked against the previous one so
+// tests can serve updates clients must reject.
+func (s *Server) SetWorld(data []byte) error {
+	w := &node.ZtWorld{}
+	if err := w.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	if w.Type != node.ZT_WORLD_TYPE_PLANET && w.Type != node.ZT_WORLD_TYPE_MOON {
+		return ErrNotWorld
+	}
+	s.mu.Lock()
+	s.world = w
+	s.mu.Unlock()
+	return nil
+}
+
+// World returns the world currently served
+func (s *Server) World() *node.ZtWorld {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.world
+}
+
+// Address returns the address of the root
+func (s *Server) Address() node.ZtAddress {
+	return s.self.Address
+}
+
+// Peer returns the identity of a peer that said HELLO
+func (s *Server) Peer(addr node.ZtAddress) (*node.ZtIdentity, bool) {
+	s.mu.Lock()
+	_, ok := s.peers[addr]
+	s.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	id, err := s.opts.Registry.Get(addr)
+	return id, err == nil
+}
+
+// ListenAndServe listens on the UDP address, e.g. 127.0.0.1:9993, until ctx is done
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, conn)
+}
+
+// Serve answers packets read from conn until ctx is done, conn is closed on return
+func (s *Server) Serve(ctx context.Context, conn net.PacketConn) error {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	s.opts.Logger.Println("root", s.self.Address, "listening on", conn.LocalAddr())
+	asm := protocol.NewAssembler()
+	buf := make([]byte, protocol.ZT_PROTO_MAX_PACKET_LENGTH)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		s.handle(conn, append([]byte(nil), buf[:n]...), from, asm)
+	}
+}
+
+func (s *Server) handle(conn net.PacketConn, datagram []byte, from net.Addr, asm *protocol.Assembler) {
+	if len(datagram) < protocol.ZT_PROTO_MIN_FRAGMENT_LENGTH {
+		return
+	}
+	dest, _ := node.NewZtAddressFromBytes(datagram[protocol.ZT_PACKET_IDX_DEST:])
+	if dest != s.self.Address {
+		s.relay(conn, datagram, dest)
+		return
+	}
+	now := time.Now()
+	packet, complete, err := asm.Add(datagram, now)
+	if err != nil || !complete {
+		return
+	}
+	hdr := &protocol.Header{}
+	if _, err := hdr.Deserialize(packet); err != nil {
+		return
+	}
+	// HELLO is never encrypted so its verb can be read before the key is known
+	if hdr.Cipher == protocol.ZT_PROTO_CIPHER_SUITE__C25519_POLY1305_NONE && hdr.Verb == protocol.ZT_PROTO_VERB_HELLO {
+		s.hello(conn, packet, hdr, from, now)
+		return
+	}
+	s.mu.Lock()
+	p, ok := s.peers[hdr.Source]
+	s.mu.Unlock()
+	if !ok {
+		s.opts.Logger.Println("dropping packet from unknown peer", hdr.Source)
+		return
+	}
+	in, err := protocol.Dearmor(packet, &p.key)
+	if err != nil {
+		s.opts.Logger.Println("dropping packet from", hdr.Source, err)
+		return
+	}
+	s.mu.Lock()
+	p.path, p.lastSeen = from, now
+	s.mu.Unlock()
+	switch in.Verb {
+	case protocol.ZT_PROTO_VERB_WHOIS:
+		out, err := registry.Whois(s.opts.Registry, in, s.self.Address)
+		if err != nil {
+			s.opts.Logger.Println("WHOIS from", in.Source, err)
+		}
+		if out != nil {
+			s.send(conn, out, &p.key, from)
+		}
+	case protocol.ZT_PROTO_VERB_ECHO:
+		out := &protocol.Packet{
+			Header: protocol.Header{
+				Destination: in.Source,
+				Source:      s.self.Address,
+				Cipher:      protocol.ZT_PROTO_CIPHER_SUITE__C25519_POLY1305_SALSA2012,
+				Verb:        protocol.ZT_PROTO_VERB_OK,
+			},
+			Payload: append(protocol.InRe{Verb: protocol.ZT_PROTO_VERB_ECHO, PacketID: in.PacketID}.Serialize(), in.Payload...),
+		}
+		s.send(conn, out, &p.key, from)
+	}
+}
+
+// hello learns the peer and answers with OK(HELLO), carrying the world if the peer has an older
+// copy of it
+func (s *Server) hello(conn net.PacketConn, packet []byte, hdr *protocol.Header, from net.Addr, now time.Time) {
+	h := &protocol.Hello{}
+	if _, err := h.Deserialize(packet[protocol.ZT_PROTO_MIN_PACKET_LENGTH:]); err != nil || h.Identity.Address != hdr.Source {
+		return
+	}
+	s.mu.Lock()
+	known, found := s.peers[hdr.Source]
+	s.mu.Unlock()
+	registered, err := s.opts.Registry.Get(hdr.Source)
+	if err != nil && !errors.Is(err, registry.ErrNotFound) {
+		s.opts.Logger.Println("looking up", hdr.Source, err)
+		return
+	}
+	collision := registered != nil && registered.PublicKeyString() != h.Identity.PublicKeyString()
+	var key [ztcrypto.SymmetricKeyLen]byte
+	if found && !collision {
+		key = known.key
+	} else {
+		// the memory-hard check keeps peers from claiming addresses they did not derive, new
+		// identities are checked when they are registered below
+		if collision {
+			if err := h.Identity.LocallyValidate(); err != nil {
+				s.opts.Logger.Println("dropping HELLO from", hdr.Source, err)
+				return
+			}
+		}
+		if key, err = s.self.Agree(h.Identity); err != nil {
+			return
+		}
+	}
+	if _, h, err = protocol.DearmorHello(packet, &key); err != nil {
+		s.opts.Logger.Println("dropping HELLO from", hdr.Source, err)
+		return
+	}
+	if collision {
+		s.opts.Logger.Println("identity collision for", hdr.Source)
+		e := &protocol.ErrorReply{
+			InRe: protocol.InRe{Verb: protocol.ZT_PROTO_VERB_HELLO, PacketID: hdr.PacketID},
+			Code: protocol.ZT_PROTO_ERROR_IDENTITY_COLLISION,
+		}
+		out := &protocol.Packet{
+			Header: protocol.Header{
+				Destination: hdr.Source,
+				Source:      s.self.Address,
+				Cipher:      protocol.ZT_PROTO_CIPHER_SUITE__C25519_POLY1305_SALSA2012,
+				Verb:        protocol.ZT_PROTO_VERB_ERROR,
+			},
+			Payload: e.Serialize(),
+		}
+		s.send(conn, out, &key, from)
+		return
+	}
+	if registered == nil {
+		if err := s.opts.Registry.Put(h.Identity); err != nil {
+			s.opts.Logger.Println("registering", hdr.Source, err)
+			return
+		}
+	}
+	s.mu.Lock()
+	if !found {
+		known = &peer{key: key}
+		s.peers[hdr.Source] = known
+		s.opts.Logger.Println("new peer", hdr.Source, "at", from)
+	}
+	known.path, known.lastSeen = from, now
+	w := s.world
+	s.mu.Unlock()
+
+	reply := &protocol.OkHello{
+		Timestamp:    h.Timestamp,
+		ProtoVersion: protocol.ZT_PROTO_VERSION,
+		Major:        protocol.ZEROTIER_ONE_VERSION_MAJOR,
+		Minor:        protocol.ZEROTIER_ONE_VERSION_MINOR,
+		Revision:     protocol.ZEROTIER_ONE_VERSION_REVISION,
+		Destination:  inetAddr(from),
+	}
+	if wantsWorld(h, w) {
+		reply.Worlds = append(reply.Worlds, w)
+	}
+	out, err := reply.Packet(0, &protocol.Packet{Header: *hdr}, s.self.Address)
+	if err != nil {
+		s.opts.Logger.Println("OK(HELLO) for", hdr.Source, err)
+		return
+	}
+	s.send(conn, out, &key, from)
+}
+
+// relay forwards a packet or fragment for another peer over the path it last said HELLO from
+func (s *Server) relay(conn net.PacketConn, datagram []byte, dest node.ZtAddress) {
+	s.mu.Lock()
+	p, ok := s.peers[dest]
+	var path net.Addr
+	if ok {
+		path = p.path
+	}
+	s.mu.Unlock()
+	if !ok || !protocol.IncrementHops(datagram) {
+		return
+	}
+	if _, err := conn.WriteTo(datagram, path); err != nil {
+		s.opts.Logger.Println("relaying to", dest, err)
+	}
+}
+
+// send armors p with a fresh packet ID and writes it to addr, fragmented if needed
+func (s *Server) send(conn net.PacketConn, p *protocol.Packet, key *[ztcrypto.SymmetricKeyLen]byte, addr net.Addr) {
+	id, err := protocol.NewPacketID()
+	if err != nil {
+		return
+	}
+	p.PacketID = id
+	p.Fragmented = protocol.ZT_PROTO_MIN_PACKET_LENGTH+len(p.Payload) > s.opts.MTU
+	b, err := p.Armor(key, p.Cipher == protocol.ZT_PROTO_CIPHER_SUITE__C25519_POLY1305_SALSA2012)
+	if err != nil {
+		s.opts.Logger.Println("armoring", protocol.VerbName(p.Verb), "for", p.Destination, err)
+		return
+	}
+	datagrams, err := protocol.Fragmentize(b, s.opts.MTU)
+	if err != nil {
+		s.opts.Logger.Println("fragmenting", protocol.VerbName(p.Verb), "for", p.Destination, err)
+		return
+	}
+	for _, d := range datagrams {
+		if _, err := conn.WriteTo(d, addr); err != nil {
+			s.opts.Logger.Println("sending to", p.Destination, err)
+			return
+		}
+	}
+}
+
+// wantsWorld reports whether the HELLO announces an older copy of w, or wants the moon w. Like
+// zerotier-one a planet is only sent when the announced timestamp is set.
+func wantsWorld(h *protocol.Hello, w *node.ZtWorld) bool {
+	if w.Type == node.ZT_WORLD_TYPE_PLANET {
+		return h.PlanetID == w.ID && h.PlanetTimestamp != 0 && h.PlanetTimestamp < w.Timestamp
+	}
+	for _, m := range h.Moons {
+		if m.ID == w.ID && m.Timestamp < w.Timestamp {
+			return true
+		}
+	}
+	return false
+}
+
+// inetAddr converts the UDP address a packet came from
+func inetAddr(addr net.Addr) *node.ZtNodeInetAddr {
+	udp, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+	ip := udp.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return &node.ZtNodeInetAddr{IP: &ip, Port: uint16(udp.Port)}
+}
@@ -0,0 +1,40 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package rootserver
+
+import (
+	"testing"
+	"ztnodeid/pkg/mkworld"
+	"ztnodeid/pkg/node"
+	"ztnodeid/pkg/protocol"
+)
+
+func TestWantsWorld(t *testing.T) {
+	planetID, err := mkworld.RandomWorldID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	planet := &node.ZtWorld{Type: node.ZT_WORLD_TYPE_PLANET, ID: planetID, Timestamp: 1700000000000}
+	moon := &node.ZtWorld{Type: node.ZT_WORLD_TYPE_MOON, ID: 0x1234567890, Timestamp: 1700000000000}
+	tests := []struct {
+		name string
+		h    *protocol.Hello
+		w    *node.ZtWorld
+		want bool
+	}{
+		{"planet older", &protocol.Hello{PlanetID: planet.ID, PlanetTimestamp: 1}, planet, true},
+		{"planet unset timestamp", &protocol.Hello{PlanetID: planet.ID}, planet, false},
+		{"planet current", &protocol.Hello{PlanetID: planet.ID, PlanetTimestamp: planet.Timestamp}, planet, false},
+		{"planet other id", &protocol.Hello{PlanetID: planet.ID + 1, PlanetTimestamp: 1}, planet, false},
+		{"moon wanted", &protocol.Hello{Moons: []protocol.HelloMoon{{Type: node.ZT_WORLD_TYPE_MOON, ID: moon.ID}}}, moon, true},
+		{"moon current", &protocol.Hello{Moons: []protocol.HelloMoon{{Type: node.ZT_WORLD_TYPE_MOON, ID: moon.ID, Timestamp: moon.Timestamp}}}, moon, false},
+	}
+	for _, tt := range tests {
+		if got := wantsWorld(tt.h, tt.w); got != tt.want {
+			t.Errorf("%s: wantsWorld = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package ztcrypto
+
+import (
+	"crypto/sha512"
+	"crypto/subtle"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/poly1305"
+)
+
+// Code reproduced from https://github.com/zerotier/ZeroTierOne/blob/e0a3291235230352148d5d30e51b341bfd9ad458/node/Packet.cpp
+// Packet layout is repeated here since the protocol package builds on this one.
+
+const (
+	// SymmetricKeyLen is the length of the key two peers agree on
+	SymmetricKeyLen = 32
+	// PacketMACLen is the length of the truncated Poly1305 MAC in the packet header
+	PacketMACLen = 8
+
+	packetIdxFlags   = 18
+	packetIdxMAC     = 19
+	packetIdxVerb    = 27
+	packetMinLen     = 28
+	packetFlagsMask  = 0xf8 // flags without the hop count, which relays change
+	packetCipherMask = 0x38
+	packetFlagCrypt  = 0x80 // deprecated encrypted flag, set along with Salsa20/12
+
+	cipherPoly1305None      = 0
+	cipherPoly1305Salsa2012 = 1
+)
+
+// AgreeC25519 returns the key shared by the owner of priv and the owner of pub, both in
+// ZeroTier's 64-byte C25519 layout: the first 32 bytes of SHA-512 over the X25519 secret
+func AgreeC25519(priv [64]byte, pub [64]byte) ([SymmetricKeyLen]byte, error) {
+	var key [SymmetricKeyLen]byte
+	raw, err := curve25519.X25519(priv[:32], pub[:32])
+	if err != nil {
+		return key, err
+	}
+	digest := sha512.Sum512(raw)
+	copy(key[:], digest[:SymmetricKeyLen])
+	Wipe(raw)
+	Wipe(digest[:])
+	return key, nil
+}
+
+// ArmorPacket sets the cipher suite of a serialized packet, encrypts everything from the verb on
+// if encrypt is set and writes the MAC into the header, in place. Flags, e.g. fragmented, must be
+// final, they are covered by the MAC.
+func ArmorPacket(packet []byte, key *[SymmetricKeyLen]byte, encrypt bool) error {
+	if len(packet) < packetMinLen {
+		return ErrInvalidPacket
+	}
+	flags := packet[packetIdxFlags] &^ (packetCipherMask | packetFlagCrypt)
+	if encrypt {
+		flags |= cipherPoly1305Salsa2012<<3 | packetFlagCrypt
+	}
+	packet[packetIdxFlags] = flags
+	s20, macKey := packetCipher(packet, key)
+	defer Wipe(macKey[:])
+	payload := packet[packetIdxVerb:]
+	if encrypt {
+		s20.xor(payload, payload)
+	}
+	var mac [16]byte
+	poly1305.Sum(&mac, payload, &macKey)
+	copy(packet[packetIdxMAC:packetIdxVerb], mac[:PacketMACLen])
+	return nil
+}
+
+// DearmorPacket checks the MAC of a serialized packet and decrypts it in place. Only Poly1305 with
+// or without Salsa20/12 is supported, AES-GMAC-SIV is not.
+func DearmorPacket(packet []byte, key *[SymmetricKeyLen]byte) error {
+	if len(packet) < packetMinLen {
+		return ErrInvalidPacket
+	}
+	cipher := (packet[packetIdxFlags] & packetCipherMask) >> 3
+	if cipher != cipherPoly1305None && cipher != cipherPoly1305Salsa2012 {
+		return ErrUnsupportedCipher
+	}
+	s20, macKey := packetCipher(packet, key)
+	defer Wipe(macKey[:])
+	payload := packet[packetIdxVerb:]
+	var mac [16]byte
+	poly1305.Sum(&mac, payload, &macKey)
+	if subtle.ConstantTimeCompare(mac[:PacketMACLen], packet[packetIdxMAC:packetIdxVerb]) != 1 {
+		return ErrInvalidPacket
+	}
+	if cipher == cipherPoly1305Salsa2012 {
+		s20.xor(payload, payload)
+	}
+	return nil
+}
+
+// CryptPacketField encrypts or decrypts packet[start:start+length] in place with Salsa20/12 under
+// the unmangled key, as HELLO does for the moons it announces. The nonce is the packet ID without
+// its low 3 bits. Fields must be crypted before ArmorPacket and after DearmorPacket.
+func CryptPacketField(packet []byte, start int, length int, key *[SymmetricKeyLen]byte) error {
+	if len(packet) < packetMinLen || start < packetMinLen || length < 0 || start+length > len(packet) {
+		return ErrInvalidPacket
+	}
+	var iv [8]byte
+	copy(iv[:], packet[:8])
+	iv[7] &= 0xf8
+	field := packet[start : start+length]
+	newSalsa20(key, iv[:], 12).xor(field, field)
+	return nil
+}
+
+// packetCipher keys Salsa20/12 with the per-packet key and the packet ID as nonce, the first
+// keystream block gives the Poly1305 key
+func packetCipher(packet []byte, key *[SymmetricKeyLen]byte) (*salsa20, [32]byte) {
+	mangled := mangleKey(packet, key)
+	s20 := newSalsa20(&mangled, packet[:8], 12)
+	Wipe(mangled[:])
+	var macKey [32]byte
+	s20.xor(macKey[:], macKey[:])
+	return s20, macKey
+}
+
+// mangleKey derives the per-packet key from IV, addresses, flags without hops and packet size
+func mangleKey(packet []byte, key *[SymmetricKeyLen]byte) (out [SymmetricKeyLen]byte) {
+	for i := 0; i < 18; i++ {
+		out[i] = key[i] ^ packet[i]
+	}
+	out[18] = key[18] ^ (packet[packetIdxFlags] & packetFlagsMask)
+	out[19] = key[19] ^ byte(len(packet))
+	out[20] = key[20] ^ byte(len(packet)>>8)
+	copy(out[21:], key[21:])
+	return
+}
@@ -0,0 +1,136 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package ztcrypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// The vectors below come from a separate implementation of Packet.cpp's _salsa20MangleKey and
+// armor(), not from this package: key 0x40..0x5f, packet ID a1b2c3d4e5f60717, 0102030405 to
+// 0a0b0c0d0e, 3 hops, verb HELLO and 60 bytes of payload.
+const (
+	armorTestPlain = "a1b2c3d4e5f6071701020304050a0b0c0d0e8b00000000000000000100070e151c232a31383f464d545b626970777e858c939aa1a8afb6bdc4cbd2d9e0e7eef5fc030a11181f262d343b424950575e656c737a81888f969d"
+	// Poly1305 with Salsa20/12
+	armorTestEncrypted = "a1b2c3d4e5f6071701020304050a0b0c0d0e8b5ca9b950b892bdfc8e345224ea55b35862b597f28277c913e72fbb1f69f79f4b53baeeacbe66ac1b6f596903a287bef7343ce30f2b13665de566454b3eaaddd3c713efab24"
+	// Poly1305 only
+	armorTestClear = "a1b2c3d4e5f6071701020304050a0b0c0d0e033c63c21dc33e71360100070e151c232a31383f464d545b626970777e858c939aa1a8afb6bdc4cbd2d9e0e7eef5fc030a11181f262d343b424950575e656c737a81888f969d"
+	// plain bytes 40 to 55 under the unmangled key and the packet ID without its low 3 bits
+	armorTestField      = "e4828e88a6445c5ac061ab0ee0e485c6"
+	armorTestFieldStart = 40
+)
+
+func armorTestKey() *[SymmetricKeyLen]byte {
+	var key [SymmetricKeyLen]byte
+	for i := range key {
+		key[i] = byte(0x40 + i)
+	}
+	return &key
+}
+
+func TestArmorKnownAnswer(t *testing.T) {
+	tests := []struct {
+		name    string
+		encrypt bool
+		armored string
+	}{
+		{"Salsa20/12", true, armorTestEncrypted},
+		{"MAC only", false, armorTestClear},
+	}
+	for _, tt := range tests {
+		plain := mustHex(t, armorTestPlain)
+		want := mustHex(t, tt.armored)
+
+		packet := bytes.Clone(plain)
+		if err := ArmorPacket(packet, armorTestKey(), tt.encrypt); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(packet, want) {
+			t.Errorf("%s: armored\n %x\nwant %x", tt.name, packet, want)
+		}
+
+		packet = bytes.Clone(want)
+		if err := DearmorPacket(packet, armorTestKey()); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !bytes.Equal(packet[packetIdxVerb:], plain[packetIdxVerb:]) {
+			t.Errorf("%s: dearmored payload %x", tt.name, packet[packetIdxVerb:])
+		}
+	}
+}
+
+func TestDearmorRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(p []byte, key *[SymmetricKeyLen]byte) []byte
+		err    error
+	}{
+		{"payload bit", func(p []byte, _ *[SymmetricKeyLen]byte) []byte { p[len(p)-1] ^= 1; return p }, ErrInvalidPacket},
+		{"source address", func(p []byte, _ *[SymmetricKeyLen]byte) []byte { p[17] ^= 1; return p }, ErrInvalidPacket},
+		{"fragmented flag", func(p []byte, _ *[SymmetricKeyLen]byte) []byte { p[packetIdxFlags] ^= 0x40; return p }, ErrInvalidPacket},
+		{"truncated", func(p []byte, _ *[SymmetricKeyLen]byte) []byte { return p[:len(p)-1] }, ErrInvalidPacket},
+		{"shorter than a header", func(p []byte, _ *[SymmetricKeyLen]byte) []byte { return p[:packetIdxVerb] }, ErrInvalidPacket},
+		{"other key", func(p []byte, key *[SymmetricKeyLen]byte) []byte { key[31] ^= 1; return p }, ErrInvalidPacket},
+		{"AES-GMAC-SIV", func(p []byte, _ *[SymmetricKeyLen]byte) []byte { p[packetIdxFlags] |= 3 << 3; return p }, ErrUnsupportedCipher},
+	}
+	for _, tt := range tests {
+		key := armorTestKey()
+		packet := tt.change(mustHex(t, armorTestEncrypted), key)
+		if err := DearmorPacket(packet, key); !errors.Is(err, tt.err) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	// relays count hops, the MAC does not cover them
+	packet := mustHex(t, armorTestEncrypted)
+	packet[packetIdxFlags] = packet[packetIdxFlags]&^7 | 5
+	if err := DearmorPacket(packet, armorTestKey()); err != nil {
+		t.Errorf("changed hop count: %v", err)
+	}
+}
+
+func TestCryptPacketFieldKnownAnswer(t *testing.T) {
+	plain := mustHex(t, armorTestPlain)
+	want := mustHex(t, armorTestField)
+	end := armorTestFieldStart + len(want)
+
+	packet := bytes.Clone(plain)
+	if err := CryptPacketField(packet, armorTestFieldStart, len(want), armorTestKey()); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(packet[armorTestFieldStart:end], want) {
+		t.Errorf("crypted field %x, want %x", packet[armorTestFieldStart:end], want)
+	}
+	if !bytes.Equal(packet[:armorTestFieldStart], plain[:armorTestFieldStart]) || !bytes.Equal(packet[end:], plain[end:]) {
+		t.Error("CryptPacketField changed bytes outside the field")
+	}
+
+	// the low 3 bits of the packet ID do not change the nonce
+	packet = bytes.Clone(plain)
+	packet[7] ^= 7
+	if err := CryptPacketField(packet, armorTestFieldStart, len(want), armorTestKey()); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(packet[armorTestFieldStart:end], want) {
+		t.Errorf("crypted field %x with other low ID bits, want %x", packet[armorTestFieldStart:end], want)
+	}
+
+	// crypting again restores the field
+	if err := CryptPacketField(packet, armorTestFieldStart, len(want), armorTestKey()); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(packet[armorTestFieldStart:end], plain[armorTestFieldStart:end]) {
+		t.Error("crypting twice did not restore the field")
+	}
+
+	for _, r := range [][2]int{{packetIdxVerb, 1}, {armorTestFieldStart, -1}, {len(plain) - 1, 2}} {
+		if err := CryptPacketField(bytes.Clone(plain), r[0], r[1], armorTestKey()); !errors.Is(err, ErrInvalidPacket) {
+			t.Errorf("field at %d length %d: %v, want ErrInvalidPacket", r[0], r[1], err)
+		}
+	}
+}
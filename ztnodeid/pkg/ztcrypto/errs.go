@@ -0,0 +1,14 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package ztcrypto
+
+import "errors"
+
+var (
+	ErrInvalidKey        = errors.New("key material invalid")
+	ErrInvalidPacket     = errors.New("packet too short or MAC check failed")
+	ErrUnsupportedCipher = errors.New("packet cipher suite not supported")
+)
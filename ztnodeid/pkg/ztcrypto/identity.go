@@ -7,8 +7,11 @@ package ztcrypto
 
 import (
 	secrand "crypto/rand"
+	"crypto/sha256"
 	"crypto/sha512"
+	"crypto/subtle"
 	"encoding/binary"
+	"encoding/hex"
 	"golang.org/x/crypto/curve25519"
 	"golang.org/x/crypto/ed25519"
 	"golang.org/x/crypto/salsa20/salsa"
@@ -98,6 +101,7 @@ func SignMessage(pub [64]byte, priv [64]byte, msg []byte) ([96]byte, error) {
 	// Signature = (R,S,Sha512-Of-First32-Msg)
 	//
 	goPrivK := make([]byte, 64)
+	defer Wipe(goPrivK)
 	copy(goPrivK[:32], priv[32:64])
 	copy(goPrivK[32:], pub[32:64])
 	sigData := ed25519.Sign(goPrivK, s512[:32])
@@ -106,3 +110,20 @@ func SignMessage(pub [64]byte, priv [64]byte, msg []byte) ([96]byte, error) {
 	copy(finalSig[:], sigBuf)
 	return finalSig, nil
 }
+
+// VerifySignature checks a 96-byte ZeroTier signature produced by SignMessage against the
+// combined public key. Both the ed25519 signature and the appended digest must match.
+func VerifySignature(pub [64]byte, msg []byte, sig [96]byte) bool {
+	s512 := sha512.Sum512(msg)
+	if subtle.ConstantTimeCompare(sig[64:], s512[:32]) != 1 {
+		return false
+	}
+	return ed25519.Verify(pub[32:64], s512[:32], sig[:64])
+}
+
+// Fingerprint returns a short hex digest of a public key for humans to compare, the first 16 bytes
+// of its SHA-256
+func Fingerprint(pub []byte) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:16])
+}
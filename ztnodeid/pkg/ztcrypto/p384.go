@@ -0,0 +1,55 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package ztcrypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	secrand "crypto/rand"
+	"math/big"
+)
+
+const (
+	// P384PublicKeyLen is the length of a compressed NIST P-384 point
+	P384PublicKeyLen = 49
+	// P384PrivateKeyLen is the length of a NIST P-384 scalar
+	P384PrivateKeyLen = 48
+	// P384SignatureLen is the length of an ECDSA signature, r and s padded to 48 bytes each
+	P384SignatureLen = 96
+)
+
+// SignP384Digest produces an ECDSA signature of digest, encoded as r||s.
+func SignP384Digest(pub [P384PublicKeyLen]byte, priv [P384PrivateKeyLen]byte, digest []byte) ([P384SignatureLen]byte, error) {
+	var sig [P384SignatureLen]byte
+	curve := elliptic.P384()
+	x, y := elliptic.UnmarshalCompressed(curve, pub[:])
+	if x == nil {
+		return sig, ErrInvalidKey
+	}
+	k := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y},
+		D:         new(big.Int).SetBytes(priv[:]),
+	}
+	r, s, err := ecdsa.Sign(secrand.Reader, k, digest)
+	if err != nil {
+		return sig, err
+	}
+	r.FillBytes(sig[:P384PrivateKeyLen])
+	s.FillBytes(sig[P384PrivateKeyLen:])
+	return sig, nil
+}
+
+// VerifyP384Digest checks an r||s encoded ECDSA signature of digest.
+func VerifyP384Digest(pub [P384PublicKeyLen]byte, digest []byte, sig [P384SignatureLen]byte) bool {
+	curve := elliptic.P384()
+	x, y := elliptic.UnmarshalCompressed(curve, pub[:])
+	if x == nil {
+		return false
+	}
+	r := new(big.Int).SetBytes(sig[:P384PrivateKeyLen])
+	s := new(big.Int).SetBytes(sig[P384PrivateKeyLen:])
+	return ecdsa.Verify(&ecdsa.PublicKey{Curve: curve, X: x, Y: y}, digest, r, s)
+}
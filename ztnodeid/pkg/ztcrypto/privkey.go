@@ -0,0 +1,109 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package ztcrypto
+
+import (
+	"fmt"
+	"runtime"
+)
+
+const redactedPrivateKey = "[REDACTED]"
+
+// PrivateKey is a container for private key material. Presence is tracked explicitly, the content
+// can be wiped with Zero, and it is never printed by fmt or encoded by encoding/json.
+// A nil *PrivateKey is valid and holds no key.
+type PrivateKey struct {
+	key []byte
+}
+
+// NewPrivateKey copies b into a new container, the caller remains responsible for wiping b.
+func NewPrivateKey(b []byte) *PrivateKey {
+	k := &PrivateKey{key: make([]byte, len(b))}
+	copy(k.key, b)
+	return k
+}
+
+// IsSet returns true if the container holds key material.
+func (k *PrivateKey) IsSet() bool {
+	return k != nil && len(k.key) > 0
+}
+
+// Len returns the length of the key material in bytes.
+func (k *PrivateKey) Len() int {
+	if k == nil {
+		return 0
+	}
+	return len(k.key)
+}
+
+// Bytes returns a copy of the key material, or nil if no key is set.
+func (k *PrivateKey) Bytes() []byte {
+	if !k.IsSet() {
+		return nil
+	}
+	b := make([]byte, len(k.key))
+	copy(b, k.key)
+	return b
+}
+
+// Slice returns a copy of key material [from:to], or nil if out of range.
+func (k *PrivateKey) Slice(from, to int) []byte {
+	if !k.IsSet() || from < 0 || to > len(k.key) || from > to {
+		return nil
+	}
+	b := make([]byte, to-from)
+	copy(b, k.key[from:to])
+	return b
+}
+
+// Clone returns an independent copy of the container.
+func (k *PrivateKey) Clone() *PrivateKey {
+	if !k.IsSet() {
+		return nil
+	}
+	return NewPrivateKey(k.key)
+}
+
+// Zero overwrites the key material and marks the container as empty.
+func (k *PrivateKey) Zero() {
+	if k == nil {
+		return
+	}
+	Wipe(k.key)
+	k.key = nil
+}
+
+// Wipe overwrites b with zeroes.
+func Wipe(b []byte) {
+	clear(b)
+	// keep the writes from being optimized away
+	runtime.KeepAlive(b)
+}
+
+// String implements fmt.Stringer without revealing the key.
+func (k *PrivateKey) String() string {
+	return redactedPrivateKey
+}
+
+// GoString implements fmt.GoStringer without revealing the key.
+func (k *PrivateKey) GoString() string {
+	return redactedPrivateKey
+}
+
+// Format implements fmt.Formatter, every verb prints the redacted placeholder.
+func (k *PrivateKey) Format(f fmt.State, verb rune) {
+	_, _ = f.Write([]byte(redactedPrivateKey))
+}
+
+// MarshalJSON implements json.Marshaler without revealing the key.
+func (k *PrivateKey) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + redactedPrivateKey + `"`), nil
+}
+
+// MarshalText implements encoding.TextMarshaler without revealing the key.
+func (k *PrivateKey) MarshalText() ([]byte, error) {
+	return []byte(redactedPrivateKey), nil
+}
@@ -0,0 +1,101 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package ztcrypto
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Code reproduced from https://github.com/zerotier/ZeroTierOne/blob/e0a3291235230352148d5d30e51b341bfd9ad458/node/Salsa20.cpp
+// x/crypto/salsa20 only implements 20 rounds, ZeroTier packets use 12.
+
+const salsaBlockLen = 64
+
+// salsa20 is a Salsa20 keystream with a 256-bit key and 64-bit nonce. Like ZeroTier's, every call
+// to xor starts at a new block, the rest of a partially used block is discarded.
+type salsa20 struct {
+	state  [16]uint32
+	rounds int
+}
+
+func newSalsa20(key *[32]byte, nonce []byte, rounds int) *salsa20 {
+	s := &salsa20{rounds: rounds}
+	// "expand 32-byte k"
+	s.state[0] = 0x61707865
+	s.state[5] = 0x3320646e
+	s.state[10] = 0x79622d32
+	s.state[15] = 0x6b206574
+	for i := 0; i < 4; i++ {
+		s.state[1+i] = binary.LittleEndian.Uint32(key[i*4:])
+		s.state[11+i] = binary.LittleEndian.Uint32(key[16+i*4:])
+	}
+	s.state[6] = binary.LittleEndian.Uint32(nonce[0:])
+	s.state[7] = binary.LittleEndian.Uint32(nonce[4:])
+	return s
+}
+
+// xor encrypts or decrypts src into dst, which may overlap exactly
+func (s *salsa20) xor(dst []byte, src []byte) {
+	var block [salsaBlockLen]byte
+	for len(src) > 0 {
+		s.core(&block)
+		n := min(len(src), salsaBlockLen)
+		for i := 0; i < n; i++ {
+			dst[i] = src[i] ^ block[i]
+		}
+		dst, src = dst[n:], src[n:]
+		// 64-bit block counter in words 8 and 9
+		s.state[8]++
+		if s.state[8] == 0 {
+			s.state[9]++
+		}
+	}
+	clear(block[:])
+}
+
+func (s *salsa20) core(out *[salsaBlockLen]byte) {
+	x := s.state
+	for i := 0; i < s.rounds; i += 2 {
+		// column round
+		x[4] ^= bits.RotateLeft32(x[0]+x[12], 7)
+		x[8] ^= bits.RotateLeft32(x[4]+x[0], 9)
+		x[12] ^= bits.RotateLeft32(x[8]+x[4], 13)
+		x[0] ^= bits.RotateLeft32(x[12]+x[8], 18)
+		x[9] ^= bits.RotateLeft32(x[5]+x[1], 7)
+		x[13] ^= bits.RotateLeft32(x[9]+x[5], 9)
+		x[1] ^= bits.RotateLeft32(x[13]+x[9], 13)
+		x[5] ^= bits.RotateLeft32(x[1]+x[13], 18)
+		x[14] ^= bits.RotateLeft32(x[10]+x[6], 7)
+		x[2] ^= bits.RotateLeft32(x[14]+x[10], 9)
+		x[6] ^= bits.RotateLeft32(x[2]+x[14], 13)
+		x[10] ^= bits.RotateLeft32(x[6]+x[2], 18)
+		x[3] ^= bits.RotateLeft32(x[15]+x[11], 7)
+		x[7] ^= bits.RotateLeft32(x[3]+x[15], 9)
+		x[11] ^= bits.RotateLeft32(x[7]+x[3], 13)
+		x[15] ^= bits.RotateLeft32(x[11]+x[7], 18)
+		// row round
+		x[1] ^= bits.RotateLeft32(x[0]+x[3], 7)
+		x[2] ^= bits.RotateLeft32(x[1]+x[0], 9)
+		x[3] ^= bits.RotateLeft32(x[2]+x[1], 13)
+		x[0] ^= bits.RotateLeft32(x[3]+x[2], 18)
+		x[6] ^= bits.RotateLeft32(x[5]+x[4], 7)
+		x[7] ^= bits.RotateLeft32(x[6]+x[5], 9)
+		x[4] ^= bits.RotateLeft32(x[7]+x[6], 13)
+		x[5] ^= bits.RotateLeft32(x[4]+x[7], 18)
+		x[11] ^= bits.RotateLeft32(x[10]+x[9], 7)
+		x[8] ^= bits.RotateLeft32(x[11]+x[10], 9)
+		x[9] ^= bits.RotateLeft32(x[8]+x[11], 13)
+		x[10] ^= bits.RotateLeft32(x[9]+x[8], 18)
+		x[12] ^= bits.RotateLeft32(x[15]+x[14], 7)
+		x[13] ^= bits.RotateLeft32(x[12]+x[15], 9)
+		x[14] ^= bits.RotateLeft32(x[13]+x[12], 13)
+		x[15] ^= bits.RotateLeft32(x[14]+x[13], 18)
+	}
+	for i := range x {
+		binary.LittleEndian.PutUint32(out[i*4:], x[i]+s.state[i])
+	}
+}
@@ -0,0 +1,76 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+package ztcrypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	xsalsa20 "golang.org/x/crypto/salsa20"
+)
+
+// eSTREAM set 6 vector 0, the s20TV0 and s2012TV0 vectors of zerotier-one's selftest.cpp
+const (
+	salsaTestKey = "0f62b5085bae0154a7fa4da0f34699ec3f92e5388bde3184d72a7dd02376c91c"
+	salsaTestIV  = "288ff65dc42b92f9"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func salsaTestKeyIV(t *testing.T) (*[32]byte, []byte) {
+	t.Helper()
+	var key [32]byte
+	copy(key[:], mustHex(t, salsaTestKey))
+	return &key, mustHex(t, salsaTestIV)
+}
+
+func TestSalsa20KnownAnswer(t *testing.T) {
+	tests := []struct {
+		rounds    int
+		keystream string
+	}{
+		{12, "99db33ad11ce0ccb3bfdbf8d0c18160452d014cde989b4c411a559ff7c20a169e6dc9909d816becedc4063ce07cea828f44bf9b6c9a0a0b200e1b52af41859c5"},
+		{20, "5e5e71f90199340304abb22a37b6625bf883fb89ce3b21f54a10b81066ef87da30b77699aa7379da595c77dd59542da208e5954f89e40eb7aa80a84a6176663f"},
+	}
+	for _, tt := range tests {
+		key, iv := salsaTestKeyIV(t)
+		ks := make([]byte, salsaBlockLen)
+		newSalsa20(key, iv, tt.rounds).xor(ks, ks)
+		if got := hex.EncodeToString(ks); got != tt.keystream {
+			t.Errorf("Salsa20/%d keystream %s, want %s", tt.rounds, got, tt.keystream)
+		}
+	}
+}
+
+func TestSalsa20MatchesXCrypto(t *testing.T) {
+	// x/crypto only has 20 rounds, enough to check the block counter over several blocks
+	key, iv := salsaTestKeyIV(t)
+	want := make([]byte, 5*salsaBlockLen+17)
+	xsalsa20.XORKeyStream(want, want, iv, key)
+	got := make([]byte, len(want))
+	newSalsa20(key, iv, 20).xor(got, got)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Salsa20/20 keystream differs from x/crypto:\n got %x\nwant %x", got, want)
+	}
+
+	// a new call starts at the next block
+	s := newSalsa20(key, iv, 20)
+	first := make([]byte, 3)
+	s.xor(first, first)
+	next := make([]byte, salsaBlockLen)
+	s.xor(next, next)
+	if !bytes.Equal(first, want[:3]) || !bytes.Equal(next, want[salsaBlockLen:2*salsaBlockLen]) {
+		t.Error("xor continued within a partially used block")
+	}
+}
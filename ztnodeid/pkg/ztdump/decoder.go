@@ -0,0 +1,332 @@
+/*
+ *  SPDX-License-Identifier: AGPL-3.0-only
+ *  Copyright (C) 2023 by kmahyyg in Patmeow Limited
+ */
+
+// Package ztdump turns captured UDP datagrams into a timeline of ZeroTier packets, decrypting
+// those exchanged with identities whose private key is known.
+package ztdump
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+	"ztnodeid/pkg/node"
+	"ztnodeid/pkg/pcap"
+	"ztnodeid/pkg/protocol"
+	"ztnodeid/pkg/ztcrypto"
+)
+
+// packet states in Event.Status
+const (
+	StatusClear         = "clear"
+	StatusAuthenticated = "authenticated"
+	StatusDecrypted     = "decrypted"
+	StatusNoKey         = "no key"
+	StatusBadMAC        = "MAC check failed"
+	StatusUnsupported   = "unsupported cipher"
+)
+
+// Event is one datagram of the timeline, a fragment or a whole packet
+type Event struct {
+	Time     time.Time      `json:"time"`
+	Src      netip.AddrPort `json:"src"`
+	Dst      netip.AddrPort `json:"dst"`
+	PacketID string         `json:"packetId"`
+	From     string         `json:"from,omitempty"`
+	To       string         `json:"to"`
+	// Fragment is "n/total" for fragments, the packet is reported once all parts arrived
+	Fragment string `json:"fragment,omitempty"`
+	Verb     string `json:"verb,omitempty"`
+	Cipher   string `json:"cipher,omitempty"`
+	Hops     uint8  `json:"hops"`
+	Length   int    `json:"length"`
+	Status   string `json:"status,omitempty"`
+	Summary  string `json:"summary,omitempty"`
+}
+
+// String formats the event as a timeline line
+func (e *Event) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s %s > %s ", e.Time.Format("2006-01-02T15:04:05.000000Z07:00"), e.Src, e.Dst)
+	if e.Fragment != "" {
+		fmt.Fprintf(&sb, "%s fragment %s of %s, %d bytes", e.To, e.Fragment, e.PacketID, e.Length)
+		return sb.String()
+	}
+	verb := e.Verb
+	if verb == "" {
+		verb = "?"
+	}
+	fmt.Fprintf(&sb, "%s > %s %s %s, %s %s, hops %d, %d bytes", e.From, e.To, e.PacketID, verb, e.Cipher, e.Status, e.Hops, e.Length)
+	if e.Summary != "" {
+		sb.WriteString(": ")
+		sb.WriteString(e.Summary)
+	}
+	return sb.String()
+}
+
+type keyPair [2]node.ZtAddress
+
+// Decoder keeps the identities and partial packets seen so far, it is not safe for concurrent use
+type Decoder struct {
+	// Port only decodes datagrams from or to this UDP port when set
+	Port uint16
+
+	identities map[node.ZtAddress]*node.ZtIdentity
+	keys       map[keyPair][ztcrypto.SymmetricKeyLen]byte
+	asm        *protocol.Assembler
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{
+		identities: make(map[node.ZtAddress]*node.ZtIdentity),
+		keys:       make(map[keyPair][ztcrypto.SymmetricKeyLen]byte),
+		asm:        protocol.NewAssembler(),
+	}
+}
+
+// AddIdentity makes an identity known. Packets can be decrypted when the private key of one side
+// and the public key of the other are known. A private key replaces a known public identity.
+func (d *Decoder) AddIdentity(id *node.ZtIdentity) {
+	if known, ok := d.identities[id.Address]; ok && (known.HasPrivateKey() || !id.HasPrivateKey()) {
+		return
+	}
+	d.identities[id.Address] = id
+}
+
+// learn adds an identity seen on the wire once it passes local validation, which type 1 identities
+// never do
+func (d *Decoder) learn(id *node.ZtIdentity) bool {
+	if known, ok := d.identities[id.Address]; ok {
+		return known.PublicKeyString() == id.PublicKeyString()
+	}
+	if id.LocallyValidate() != nil {
+		return false
+	}
+	d.identities[id.Address] = id
+	return true
+}
+
+// key returns the key shared by a and b if either private key is known
+func (d *Decoder) key(a node.ZtAddress, b node.ZtAddress) (*[ztcrypto.SymmetricKeyLen]byte, bool) {
+	pair := keyPair{min(a, b), max(a, b)}
+	if k, ok := d.keys[pair]; ok {
+		return &k, true
+	}
+	ida, oka := d.identities[a]
+	idb, okb := d.identities[b]
+	if !oka || !okb {
+		return nil, false
+	}
+	if !ida.HasPrivateKey() {
+		ida, idb = idb, ida
+	}
+	k, err := ida.Agree(idb)
+	if err != nil {
+		return nil, false
+	}
+	d.keys[pair] = k
+	return &k, true
+}
+
+// IsZeroTier tells whether a datagram looks like a ZeroTier packet or fragment
+func IsZeroTier(b []byte) bool {
+	if protocol.IsFragment(b) {
+		dest, _ := node.NewZtAddressFromBytes(b[protocol.ZT_PACKET_FRAGMENT_IDX_DEST:])
+		return !dest.IsReserved()
+	}
+	h := &protocol.Header{}
+	if _, err := h.Deserialize(b); err != nil || len(b) > protocol.ZT_PROTO_MAX_PACKET_LENGTH {
+		return false
+	}
+	return !h.Destination.IsReserved() && !h.Source.IsReserved() && h.Cipher <= protocol.ZT_PROTO_CIPHER_SUITE__AES_GMAC_SIV
+}
+
+// Decode returns the events for a datagram: nothing for other traffic and incomplete packets,
+// the fragment, and the packet once it is complete
+func (d *Decoder) Decode(ts time.Time, u *pcap.UDP) []Event {
+	if d.Port != 0 && u.Src.Port() != d.Port && u.Dst.Port() != d.Port {
+		return nil
+	}
+	if !IsZeroTier(u.Payload) {
+		return nil
+	}
+	var events []Event
+	datagram := append([]byte(nil), u.Payload...)
+	if protocol.IsFragment(datagram) {
+		f := &protocol.Fragment{}
+		if _, err := f.Deserialize(datagram); err != nil {
+			return nil
+		}
+		events = append(events, Event{
+			Time:     ts,
+			Src:      u.Src,
+			Dst:      u.Dst,
+			PacketID: fmt.Sprintf("%016x", f.PacketID),
+			To:       f.Destination.String(),
+			Fragment: fmt.Sprintf("%d/%d", f.Number+1, f.Total),
+			Hops:     f.Hops,
+			Length:   len(datagram),
+		})
+	}
+	packet, complete, err := d.asm.Add(datagram, ts)
+	if err != nil || !complete {
+		return events
+	}
+	return append(events, d.packet(ts, u, packet))
+}
+
+func (d *Decoder) packet(ts time.Time, u *pcap.UDP, packet []byte) Event {
+	h := &protocol.Header{}
+	_, _ = h.Deserialize(packet)
+	e := Event{
+		Time:     ts,
+		Src:      u.Src,
+		Dst:      u.Dst,
+		PacketID: fmt.Sprintf("%016x", h.PacketID),
+		From:     h.Source.String(),
+		To:       h.Destination.String(),
+		Cipher:   cipherName(h.Cipher),
+		Hops:     h.Hops,
+		Length:   len(packet),
+	}
+	// HELLO is sent in clear, the identity inside gives the key to check it with
+	clearHello := h.Cipher == protocol.ZT_PROTO_CIPHER_SUITE__C25519_POLY1305_NONE && h.Verb == protocol.ZT_PROTO_VERB_HELLO
+	var hello *protocol.Hello
+	if clearHello {
+		hello = &protocol.Hello{}
+		if _, err := hello.Deserialize(packet[protocol.ZT_PROTO_MIN_PACKET_LENGTH:]); err != nil || hello.Identity.Address != h.Source || !d.learn(hello.Identity) {
+			hello = nil
+		}
+	}
+	switch h.Cipher {
+	case protocol.ZT_PROTO_CIPHER_SUITE__NONE:
+		e.Status = StatusClear
+	case protocol.ZT_PROTO_CIPHER_SUITE__AES_GMAC_SIV:
+		e.Status = StatusUnsupported
+	default:
+		key, ok := d.key(h.Source, h.Destination)
+		switch {
+		case !ok:
+			e.Status = StatusNoKey
+		case clearHello:
+			if _, dh, err := protocol.DearmorHello(packet, key); err != nil {
+				e.Status = StatusBadMAC
+			} else {
+				e.Status, hello = StatusAuthenticated, dh
+			}
+		default:
+			if err := ztcrypto.DearmorPacket(packet, key); err != nil {
+				e.Status = StatusBadMAC
+			} else if h.Cipher == protocol.ZT_PROTO_CIPHER_SUITE__C25519_POLY1305_NONE {
+				e.Status = StatusAuthenticated
+			} else {
+				e.Status = StatusDecrypted
+			}
+		}
+	}
+	// without encryption the payload is readable even if the MAC cannot be checked
+	readable := e.Status == StatusClear || e.Status == StatusDecrypted ||
+		h.Cipher == protocol.ZT_PROTO_CIPHER_SUITE__C25519_POLY1305_NONE
+	if !readable {
+		return e
+	}
+	p := &protocol.Packet{}
+	if _, err := p.Deserialize(packet); err != nil {
+		return e
+	}
+	e.Verb = protocol.VerbName(p.Verb)
+	switch {
+	case hello != nil:
+		e.Summary = summarizeHello(hello)
+	case clearHello:
+		e.Summary = "identity invalid or colliding"
+	case p.Compressed:
+		e.Summary = fmt.Sprintf("compressed payload, %d bytes", len(p.Payload))
+	default:
+		e.Summary = d.summarize(p)
+	}
+	return e
+}
+
+// summarize describes the payload of a readable packet
+func (d *Decoder) summarize(p *protocol.Packet) string {
+	switch p.Verb {
+	case protocol.ZT_PROTO_VERB_OK:
+		in := &protocol.InRe{}
+		if _, err := in.Deserialize(p.Payload); err != nil {
+			return "truncated"
+		}
+		s := fmt.Sprintf("in re %s %016x", protocol.VerbName(in.Verb), in.PacketID)
+		switch in.Verb {
+		case protocol.ZT_PROTO_VERB_HELLO:
+			ok := &protocol.OkHello{}
+			if _, err := ok.Deserialize(p.Payload); err != nil {
+				return s + ", " + err.Error()
+			}
+			s += fmt.Sprintf(", proto %d %d.%d.%d, sees us at %s", ok.ProtoVersion, ok.Major, ok.Minor, ok.Revision, ok.Destination)
+			for _, w := range ok.Worlds {
+				s += fmt.Sprintf(", sends world %d@%d", w.ID, w.Timestamp)
+			}
+		case protocol.ZT_PROTO_VERB_WHOIS:
+			ok := &protocol.OkWhois{}
+			if _, err := ok.Deserialize(p.Payload); err != nil {
+				return s + ", " + err.Error()
+			}
+			for _, id := range ok.Identities {
+				if d.learn(id) {
+					s += ", " + id.Address.String()
+				} else {
+					s += ", invalid " + id.Address.String()
+				}
+			}
+		}
+		return s
+	case protocol.ZT_PROTO_VERB_ERROR:
+		e := &protocol.ErrorReply{}
+		if _, err := e.Deserialize(p.Payload); err != nil {
+			return "truncated"
+		}
+		return fmt.Sprintf("in re %s %016x, %s", protocol.VerbName(e.Verb), e.PacketID, protocol.ErrorName(e.Code))
+	case protocol.ZT_PROTO_VERB_WHOIS:
+		w := &protocol.Whois{}
+		if _, err := w.Deserialize(p.Payload); err != nil {
+			return "truncated"
+		}
+		addrs := make([]string, 0, len(w.Addresses))
+		for _, a := range w.Addresses {
+			addrs = append(addrs, a.String())
+		}
+		return "for " + strings.Join(addrs, ", ")
+	default:
+		return fmt.Sprintf("%d bytes payload", len(p.Payload))
+	}
+}
+
+func summarizeHello(h *protocol.Hello) string {
+	s := fmt.Sprintf("proto %d %d.%d.%d, identity %s, planet %d@%d", h.ProtoVersion, h.Major, h.Minor, h.Revision,
+		h.Identity.Fingerprint(), h.PlanetID, h.PlanetTimestamp)
+	if h.Destination != nil {
+		s += ", sent to " + h.Destination.String()
+	}
+	for _, m := range h.Moons {
+		s += fmt.Sprintf(", moon %d@%d", m.ID, m.Timestamp)
+	}
+	return s
+}
+
+func cipherName(c uint8) string {
+	switch c {
+	case protocol.ZT_PROTO_CIPHER_SUITE__C25519_POLY1305_NONE:
+		return "poly1305"
+	case protocol.ZT_PROTO_CIPHER_SUITE__C25519_POLY1305_SALSA2012:
+		return "salsa2012"
+	case protocol.ZT_PROTO_CIPHER_SUITE__NONE:
+		return "none"
+	case protocol.ZT_PROTO_CIPHER_SUITE__AES_GMAC_SIV:
+		return "aes-gmac-siv"
+	default:
+		return fmt.Sprintf("%d", c)
+	}
+}